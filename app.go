@@ -2,13 +2,20 @@ package main
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/cyverse-de/app-exposer/auth"
 	"github.com/cyverse-de/app-exposer/common"
 	"github.com/cyverse-de/app-exposer/external"
+	"github.com/cyverse-de/app-exposer/groups"
 	"github.com/cyverse-de/app-exposer/instantlaunches"
 	"github.com/cyverse-de/app-exposer/internal"
+	"github.com/cyverse-de/app-exposer/tracing"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"github.com/labstack/echo/v4"
 )
@@ -54,6 +61,55 @@ type ExposerAppInit struct {
 	KeycloakRealm                 string
 	KeycloakClientID              string
 	KeycloakClientSecret          string
+	IdleTimeout                   time.Duration
+	IdleGracePeriod               time.Duration
+	IdleCheckInterval             time.Duration
+	AppIdleTimeouts               map[string]time.Duration
+	UserIdleTimeouts              []internal.UserIdleTimeout
+	TimeLimitWarnings             []time.Duration
+	TimeLimitCheckInterval        time.Duration
+	DefaultTimeLimitExtension     time.Duration
+	TimeLimitPolicy               internal.TimeLimitPolicy
+	UserTimeLimitPolicies         []internal.UserTimeLimitPolicy
+	GroupTimeLimitPolicies        map[string]internal.GroupTimeLimitPolicy
+	ReconcileInterval             time.Duration
+	OrphanGracePeriod             time.Duration
+	CleanUpOrphans                bool
+	MaxPodRestarts                int32
+	ImagePullTimeout              time.Duration
+	ExitOnPodFailure              bool
+	EventDedupeWindow             time.Duration
+	LeaderElectionEnabled         bool
+	LeaseName                     string
+	LeaseDuration                 time.Duration
+	LeaseRenewDeadline            time.Duration
+	LeaseRetryPeriod              time.Duration
+	StatusRequestTimeout          time.Duration
+	OutboxPollInterval            time.Duration
+	OutboxBatchSize               int
+	OutboxInitialBackoff          time.Duration
+	OutboxMaxBackoff              time.Duration
+	OutboxMaxAttempts             int
+	OutboxRetention               time.Duration
+	StatusTransport               string
+	AMQPURI                       string
+	AMQPExchange                  string
+	StatusUpdateInterval          time.Duration
+	NodePoolLabel                 string
+	LogStreamHeartbeat            time.Duration
+	MaxLogStreamsPerUser          int
+	MaxLogStreamDuration          time.Duration
+	ArchiveLogs                   bool
+	LogArchiveTailLines           int64
+	LogArchiveTimeout             time.Duration
+	UsageTimeout                  time.Duration
+	UsageReconcileInterval        time.Duration
+	KubernetesConfig              *rest.Config
+	GroupsURL                     string
+	GroupsUser                    string
+	GroupsTimeout                 time.Duration
+	GroupMembers                  []groups.Membership
+	Authenticator                 *auth.Authenticator
 }
 
 // NewExposerApp creates and returns a newly instantiated *ExposerApp.
@@ -82,6 +138,55 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 		KeycloakRealm:                 init.KeycloakRealm,
 		KeycloakClientID:              init.KeycloakClientID,
 		KeycloakClientSecret:          init.KeycloakClientSecret,
+		IdleTimeout:                   init.IdleTimeout,
+		IdleGracePeriod:               init.IdleGracePeriod,
+		IdleCheckInterval:             init.IdleCheckInterval,
+		AppIdleTimeouts:               init.AppIdleTimeouts,
+		UserIdleTimeouts:              init.UserIdleTimeouts,
+		TimeLimitWarnings:             init.TimeLimitWarnings,
+		TimeLimitCheckInterval:        init.TimeLimitCheckInterval,
+		DefaultTimeLimitExtension:     init.DefaultTimeLimitExtension,
+		TimeLimitPolicy:               init.TimeLimitPolicy,
+		UserTimeLimitPolicies:         init.UserTimeLimitPolicies,
+		GroupTimeLimitPolicies:        init.GroupTimeLimitPolicies,
+		ReconcileInterval:             init.ReconcileInterval,
+		OrphanGracePeriod:             init.OrphanGracePeriod,
+		CleanUpOrphans:                init.CleanUpOrphans,
+		MaxPodRestarts:                init.MaxPodRestarts,
+		ImagePullTimeout:              init.ImagePullTimeout,
+		ExitOnPodFailure:              init.ExitOnPodFailure,
+		EventDedupeWindow:             init.EventDedupeWindow,
+		LeaderElectionEnabled:         init.LeaderElectionEnabled,
+		LeaseName:                     init.LeaseName,
+		LeaseNamespace:                init.Namespace,
+		LeaseDuration:                 init.LeaseDuration,
+		LeaseRenewDeadline:            init.LeaseRenewDeadline,
+		LeaseRetryPeriod:              init.LeaseRetryPeriod,
+		StatusRequestTimeout:          init.StatusRequestTimeout,
+		OutboxPollInterval:            init.OutboxPollInterval,
+		OutboxBatchSize:               init.OutboxBatchSize,
+		OutboxInitialBackoff:          init.OutboxInitialBackoff,
+		OutboxMaxBackoff:              init.OutboxMaxBackoff,
+		OutboxMaxAttempts:             init.OutboxMaxAttempts,
+		OutboxRetention:               init.OutboxRetention,
+		StatusTransport:               init.StatusTransport,
+		AMQPURI:                       init.AMQPURI,
+		AMQPExchange:                  init.AMQPExchange,
+		StatusUpdateInterval:          init.StatusUpdateInterval,
+		NodePoolLabel:                 init.NodePoolLabel,
+		LogStreamHeartbeat:            init.LogStreamHeartbeat,
+		MaxLogStreamsPerUser:          init.MaxLogStreamsPerUser,
+		MaxLogStreamDuration:          init.MaxLogStreamDuration,
+		ArchiveLogs:                   init.ArchiveLogs,
+		LogArchiveTailLines:           init.LogArchiveTailLines,
+		LogArchiveTimeout:             init.LogArchiveTimeout,
+		UsageTimeout:                  init.UsageTimeout,
+		UsageReconcileInterval:        init.UsageReconcileInterval,
+		KubernetesConfig:              init.KubernetesConfig,
+		GroupsURL:                     init.GroupsURL,
+		GroupsUser:                    init.GroupsUser,
+		GroupsTimeout:                 init.GroupsTimeout,
+		GroupMembers:                  init.GroupMembers,
 	}
 
 	app := &ExposerApp{
@@ -97,11 +202,12 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 		UserSuffix:      init.UserSuffix,
 		MetadataBaseURL: init.MetadataBaseURL,
 		PermissionsURL:  init.PermissionsURL,
+		AuditMiddleware: app.internal.AuditMiddleware,
 	}
 
 	app.router.HTTPErrorHandler = func(err error, c echo.Context) {
 		code := http.StatusInternalServerError
-		var body interface{}
+		var body common.ErrorResponse
 
 		switch err := err.(type) {
 		case common.ErrorResponse:
@@ -109,7 +215,7 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 			body = err
 		case *common.ErrorResponse:
 			code = http.StatusBadRequest
-			body = err
+			body = *err
 		case *echo.HTTPError:
 			echoErr := err
 			code = echoErr.Code
@@ -118,11 +224,29 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 			body = common.NewErrorResponse(err)
 		}
 
+		ctx := c.Request().Context()
+		body.TraceID = tracing.TraceID(ctx)
+		if code >= http.StatusInternalServerError {
+			tracing.Logger(ctx).Error(err)
+		}
+
 		c.JSON(code, body) // nolint:errcheck
 	}
 
+	// The audit middleware comes first so that requests turned away by the
+	// role checks are recorded too.
+	adminMiddleware := []echo.MiddlewareFunc{app.internal.AuditMiddleware}
+
+	app.router.Use(tracing.Middleware())
+	if init.Authenticator != nil {
+		app.router.Use(init.Authenticator.Middleware(skipAuthentication))
+		adminMiddleware = append(adminMiddleware, init.Authenticator.AdminMiddleware())
+		ilInit.AdminMiddleware = init.Authenticator.AdminMiddleware()
+	}
+
 	app.router.GET("/", app.Greeting).Name = "greeting"
 	app.router.Static("/docs", "./docs")
+	app.router.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 
 	vice := app.router.Group("/vice")
 	vice.POST("/launch", app.internal.LaunchAppHandler)
@@ -133,8 +257,15 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 	vice.POST("/:id/save-output-files", app.internal.TriggerUploadsHandler)
 	vice.POST("/:id/exit", app.internal.ExitHandler)
 	vice.POST("/:id/save-and-exit", app.internal.SaveAndExitHandler)
+	vice.POST("/:id/suspend", app.internal.SuspendHandler)
+	vice.POST("/:id/resume", app.internal.ResumeHandler)
+	vice.POST("/:id/activity", app.internal.ActivityHandler)
 	vice.GET("/:analysis-id/pods", app.internal.PodsHandler)
 	vice.GET("/:analysis-id/logs", app.internal.LogsHandler)
+	vice.GET("/:analysis-id/logs/stream", app.internal.LogsStreamHandler)
+	vice.GET("/:analysis-id/logs/merged", app.internal.MergedLogsHandler)
+	vice.GET("/:analysis-id/logs/archived", app.internal.ArchivedLogsHandler)
+	vice.GET("/:analysis-id/usage", app.internal.UsageHandler)
 	vice.POST("/:analysis-id/time-limit", app.internal.TimeLimitUpdateHandler)
 	vice.GET("/:analysis-id/time-limit", app.internal.GetTimeLimitHandler)
 	vice.GET("/:host/url-ready", app.internal.URLReadyHandler)
@@ -148,10 +279,20 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 	vicelisting.GET("/services", app.internal.FilterableServicesHandler)
 	vicelisting.GET("/ingresses", app.internal.FilterableIngressesHandler)
 
-	viceadmin := vice.Group("/admin")
+	viceadmin := vice.Group("/admin", adminMiddleware...)
 	viceadmin.GET("/listing", app.internal.AdminFilterableResourcesHandler)
 	viceadmin.GET("/:host/description", app.internal.AdminDescribeAnalysisHandler)
 	viceadmin.GET("/:host/url-ready", app.internal.AdminURLReadyHandler)
+	viceadmin.GET("/drift", app.internal.AdminDriftHandler)
+	viceadmin.GET("/leader", app.internal.AdminLeaderHandler)
+	viceadmin.GET("/audit", app.internal.AdminAuditHandler)
+	viceadmin.GET("/usage/users", app.internal.AdminUserUsageHandler)
+	viceadmin.GET("/usage/apps", app.internal.AdminAppUsageHandler)
+
+	viceoutbox := viceadmin.Group("/outbox")
+	viceoutbox.GET("", app.internal.AdminListOutboxHandler)
+	viceoutbox.POST("/replay", app.internal.AdminReplayStuckOutboxHandler)
+	viceoutbox.POST("/:id/replay", app.internal.AdminReplayOutboxHandler)
 
 	viceanalyses := viceadmin.Group("/analyses")
 	viceanalyses.GET("/", app.internal.AdminFilterableResourcesHandler)
@@ -163,6 +304,12 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 	viceanalyses.POST("/:analysis-id/time-limit", app.internal.AdminTimeLimitUpdateHandler)
 	viceanalyses.GET("/:analysis-id/external-id", app.internal.AdminGetExternalIDHandler)
 
+	// The terminal is only available to operators, who can't be identified
+	// without authentication.
+	if init.Authenticator != nil {
+		viceanalyses.GET("/:analysis-id/exec", app.internal.AdminExecHandler, init.Authenticator.OperatorMiddleware())
+	}
+
 	svc := app.router.Group("/service")
 	svc.POST("/:name", app.external.CreateServiceHandler)
 	svc.PUT("/:name", app.external.UpdateServiceHandler)
@@ -187,6 +334,13 @@ func NewExposerApp(init *ExposerAppInit, ingressClass string, cs kubernetes.Inte
 	return app
 }
 
+// skipAuthentication returns true for the routes that don't need a token: the
+// greeting, the metrics, and the API docs.
+func skipAuthentication(c echo.Context) bool {
+	path := c.Path()
+	return path == "/" || path == "/metrics" || strings.HasPrefix(path, "/docs")
+}
+
 // Greeting lets the caller know that the service is up and should be receiving
 // requests.
 func (e *ExposerApp) Greeting(context echo.Context) error {
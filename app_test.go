@@ -46,6 +46,24 @@ func TestNewExposerApp(t *testing.T) {
 	}
 }
 
+func TestAdminRoutesWithoutAuthentication(t *testing.T) {
+	testapp := NewExposerApp(&ExposerAppInit{Namespace: "testing"}, "linkerd", fake.NewSimpleClientset())
+
+	// Without authentication the admin routes are left open, as they were
+	// before the role checks were added.
+	rec := httptest.NewRecorder()
+	testapp.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/vice/admin/leader", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code for /vice/admin/leader was %d, not %d", rec.Code, http.StatusOK)
+	}
+
+	for _, route := range testapp.router.Routes() {
+		if route.Path == "/vice/admin/analyses/:analysis-id/exec" {
+			t.Error("the exec route should not be registered without authentication")
+		}
+	}
+}
+
 func TestCreateService(t *testing.T) {
 	expectedNS := "testing"
 	testcs := fake.NewSimpleClientset()
@@ -1,6 +1,7 @@
 package apps
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 
@@ -30,9 +31,9 @@ const analysisIDByExternalIDQuery = `
 
 // GetAnalysisIDByExternalID returns the analysis ID based on the external ID
 // passed in.
-func (a *Apps) GetAnalysisIDByExternalID(externalID string) (string, error) {
+func (a *Apps) GetAnalysisIDByExternalID(ctx context.Context, externalID string) (string, error) {
 	var analysisID string
-	err := a.DB.QueryRow(analysisIDByExternalIDQuery, externalID).Scan(&analysisID)
+	err := a.DB.QueryRowContext(ctx, analysisIDByExternalIDQuery, externalID).Scan(&analysisID)
 	if err != nil {
 		return "", err
 	}
@@ -47,9 +48,9 @@ const analysisIDBySubdomainQuery = `
 
 // GetAnalysisIDBySubdomain returns the analysis ID based on the subdomain
 // generated for it.
-func (a *Apps) GetAnalysisIDBySubdomain(subdomain string) (string, error) {
+func (a *Apps) GetAnalysisIDBySubdomain(ctx context.Context, subdomain string) (string, error) {
 	var analysisID string
-	err := a.DB.QueryRow(analysisIDBySubdomainQuery, subdomain).Scan(&analysisID)
+	err := a.DB.QueryRowContext(ctx, analysisIDBySubdomainQuery, subdomain).Scan(&analysisID)
 	if err != nil {
 		return "", err
 	}
@@ -66,13 +67,13 @@ const getUserIPQuery = `
 `
 
 // GetUserIP returns the latest login ip address for the given user ID.
-func (a *Apps) GetUserIP(userID string) (string, error) {
+func (a *Apps) GetUserIP(ctx context.Context, userID string) (string, error) {
 	var (
 		ipAddr sql.NullString
 		retval string
 	)
 
-	err := a.DB.QueryRow(getUserIPQuery, userID).Scan(&ipAddr)
+	err := a.DB.QueryRowContext(ctx, getUserIPQuery, userID).Scan(&ipAddr)
 	if err != nil {
 		return "", err
 	}
@@ -93,9 +94,9 @@ const getAnalysisStatusQuery = `
 `
 
 // GetAnalysisStatus gets the current status of the overall Analysis/Job in the database.
-func (a *Apps) GetAnalysisStatus(analysisID string) (string, error) {
+func (a *Apps) GetAnalysisStatus(ctx context.Context, analysisID string) (string, error) {
 	var status string
-	err := a.DB.QueryRow(getAnalysisStatusQuery, analysisID).Scan(&status)
+	err := a.DB.QueryRowContext(ctx, getAnalysisStatusQuery, analysisID).Scan(&status)
 	if err != nil {
 		return "", err
 	}
@@ -111,9 +112,9 @@ const userByAnalysisIDQuery = `
 `
 
 // GetUserByAnalysisID returns the username and id of the user that launched the analysis.
-func (a *Apps) GetUserByAnalysisID(analysisID string) (string, string, error) {
+func (a *Apps) GetUserByAnalysisID(ctx context.Context, analysisID string) (string, string, error) {
 	var username, id string
-	err := a.DB.QueryRow(userByAnalysisIDQuery, analysisID).Scan(&username, &id)
+	err := a.DB.QueryRowContext(ctx, userByAnalysisIDQuery, analysisID).Scan(&username, &id)
 	if err != nil {
 		return "", "", err
 	}
@@ -128,8 +129,8 @@ const userByUsername = `
 `
 
 // GetUserID returns the user's UUID based on their full username, including domain suffix.
-func (a *Apps) GetUserID(username string) (string, error) {
+func (a *Apps) GetUserID(ctx context.Context, username string) (string, error) {
 	var id string
-	err := a.DB.QueryRow(userByUsername, username).Scan(&id)
+	err := a.DB.QueryRowContext(ctx, userByUsername, username).Scan(&id)
 	return id, err
 }
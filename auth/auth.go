@@ -0,0 +1,303 @@
+// Package auth authenticates callers with bearer tokens issued by Keycloak.
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// userKey is the key the authenticated user is stored under in the echo
+// context.
+const userKey = "auth-user"
+
+// Claims are the claims in a Keycloak access token that app-exposer uses.
+type Claims struct {
+	jwt.RegisteredClaims
+	AuthorizedParty   string `json:"azp,omitempty"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email,omitempty"`
+	RealmAccess       struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// User is a caller that has been authenticated with a token.
+type User struct {
+	// Username is the username from the token, without the user suffix.
+	Username string
+
+	// Roles contains the realm roles and the roles for every client in the
+	// token.
+	Roles []string
+
+	// Groups contains the groups in the token, without Keycloak's leading
+	// slash.
+	Groups []string
+
+	// Service is true for the service accounts with one of the service roles,
+	// which may act on behalf of other users by naming them in the user query
+	// parameter.
+	Service bool
+}
+
+// HasRole returns true if the user has any of the roles, either as a realm or
+// client role or as a group.
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, r := range u.Roles {
+			if r == role {
+				return true
+			}
+		}
+		for _, g := range u.Groups {
+			if g == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Config contains the settings for an Authenticator.
+type Config struct {
+	// Issuer is the issuer that tokens must come from.
+	Issuer string
+
+	// Audience is checked against the aud and azp claims if it's set.
+	Audience string
+
+	// UserSuffix is removed from usernames before they're compared.
+	UserSuffix string
+
+	// TrustedServiceMode lets requests without a token through, so that
+	// existing internal callers that pass the user in the query string keep
+	// working. Tokens are still validated when they're present, and the
+	// endpoints that need a role always require one.
+	TrustedServiceMode bool
+
+	// Keys is used to verify token signatures.
+	Keys KeySet
+
+	// SupportRoles are the roles or groups that can read the admin endpoints.
+	SupportRoles []string
+
+	// OperatorRoles are the roles or groups that can use every admin endpoint,
+	// including the ones that exit or change analyses.
+	OperatorRoles []string
+
+	// ServiceRoles are the roles or groups of the trusted services that may
+	// act on behalf of other users.
+	ServiceRoles []string
+}
+
+// Authenticator validates bearer tokens.
+type Authenticator struct {
+	cfg    *Config
+	parser *jwt.Parser
+}
+
+// New returns a new *Authenticator.
+func New(cfg *Config) *Authenticator {
+	return &Authenticator{
+		cfg:    cfg,
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"})),
+	}
+}
+
+// StripSuffix removes the user suffix from the username, if it's there.
+func (a *Authenticator) StripSuffix(username string) string {
+	if a.cfg.UserSuffix == "" {
+		return username
+	}
+	return strings.TrimSuffix(username, a.cfg.UserSuffix)
+}
+
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return a.cfg.Keys.Key(kid)
+}
+
+// Authenticate validates the token and returns the user it was issued to.
+func (a *Authenticator) Authenticate(tokenString string) (*User, error) {
+	claims := &Claims{}
+	if _, err := a.parser.ParseWithClaims(tokenString, claims, a.keyFunc); err != nil {
+		return nil, errors.Wrap(err, "invalid token")
+	}
+
+	if !claims.VerifyIssuer(a.cfg.Issuer, true) {
+		return nil, fmt.Errorf("token was issued by %s, not %s", claims.Issuer, a.cfg.Issuer)
+	}
+
+	if a.cfg.Audience != "" && !claims.VerifyAudience(a.cfg.Audience, true) && claims.AuthorizedParty != a.cfg.Audience {
+		return nil, fmt.Errorf("token was not issued for %s", a.cfg.Audience)
+	}
+
+	if claims.PreferredUsername == "" {
+		return nil, errors.New("token does not contain a username")
+	}
+
+	user := &User{
+		Username: a.StripSuffix(claims.PreferredUsername),
+		Roles:    append([]string{}, claims.RealmAccess.Roles...),
+	}
+	for _, access := range claims.ResourceAccess {
+		user.Roles = append(user.Roles, access.Roles...)
+	}
+	for _, group := range claims.Groups {
+		user.Groups = append(user.Groups, strings.TrimPrefix(group, "/"))
+	}
+	user.Service = user.HasRole(a.cfg.ServiceRoles...)
+
+	return user, nil
+}
+
+// bearerToken returns the token from the Authorization header of the request,
+// or an empty string if there isn't one.
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func unauthorized(c echo.Context, msg string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	return echo.NewHTTPError(http.StatusUnauthorized, msg)
+}
+
+// Middleware returns echo middleware that authenticates each request with the
+// bearer token in its Authorization header. Requests with a user query
+// parameter that doesn't match the token are rejected, unless the token
+// belongs to a trusted service. Requests for which skip returns true aren't
+// checked.
+func (a *Authenticator) Middleware(skip func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip != nil && skip(c) {
+				return next(c)
+			}
+
+			token := bearerToken(c.Request())
+			if token == "" {
+				if a.cfg.TrustedServiceMode {
+					return next(c)
+				}
+				return unauthorized(c, "a bearer token is required")
+			}
+
+			user, err := a.Authenticate(token)
+			if err != nil {
+				return unauthorized(c, err.Error())
+			}
+
+			if param := c.QueryParam("user"); param != "" && !user.Service && a.StripSuffix(param) != user.Username {
+				return echo.NewHTTPError(
+					http.StatusForbidden,
+					fmt.Sprintf("user %s does not match the authenticated user %s", param, user.Username),
+				)
+			}
+
+			SetCurrentUser(c, user)
+
+			return next(c)
+		}
+	}
+}
+
+// CurrentUser returns the user authenticated for the request, or nil if the
+// request didn't include a token.
+func CurrentUser(c echo.Context) *User {
+	user, _ := c.Get(userKey).(*User)
+	return user
+}
+
+// SetCurrentUser records the user authenticated for the request.
+func SetCurrentUser(c echo.Context, user *User) {
+	c.Set(userKey, user)
+}
+
+// isReadOnly returns true for the request methods that don't change anything.
+func isReadOnly(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// roleMiddleware returns echo middleware that only lets through users for
+// whom allowed returns true. A token is required even in trusted service mode,
+// since a request without one can't have a role.
+func (a *Authenticator) roleMiddleware(allowed func(*User, echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := CurrentUser(c)
+			if user == nil {
+				return unauthorized(c, "a bearer token is required")
+			}
+
+			if allowed(user, c) {
+				return next(c)
+			}
+
+			return echo.NewHTTPError(
+				http.StatusForbidden,
+				fmt.Sprintf("user %s is not allowed to %s %s", user.Username, c.Request().Method, c.Path()),
+			)
+		}
+	}
+}
+
+// AdminMiddleware returns echo middleware that only lets operators through,
+// except for read-only requests, which support staff can make as well.
+func (a *Authenticator) AdminMiddleware() echo.MiddlewareFunc {
+	return a.roleMiddleware(func(user *User, c echo.Context) bool {
+		if user.HasRole(a.cfg.OperatorRoles...) {
+			return true
+		}
+		return isReadOnly(c.Request().Method) && user.HasRole(a.cfg.SupportRoles...)
+	})
+}
+
+// OperatorMiddleware returns echo middleware that only lets operators through,
+// whatever the request method. It's for the endpoints that are reached with a
+// GET but can still change things, such as WebSocket endpoints. The token is
+// verified here rather than trusting Middleware to have done it, so that
+// neither trusted service mode nor the skip function can let a request
+// through without one.
+func (a *Authenticator) OperatorMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := bearerToken(c.Request())
+			if token == "" {
+				return unauthorized(c, "a bearer token is required")
+			}
+
+			user, err := a.Authenticate(token)
+			if err != nil {
+				return unauthorized(c, err.Error())
+			}
+
+			if !user.HasRole(a.cfg.OperatorRoles...) {
+				return echo.NewHTTPError(
+					http.StatusForbidden,
+					fmt.Sprintf("user %s is not allowed to %s %s", user.Username, c.Request().Method, c.Path()),
+				)
+			}
+
+			SetCurrentUser(c, user)
+
+			return next(c)
+		}
+	}
+}
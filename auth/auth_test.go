@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer = "https://keycloak.example.org/realms/CyVerse"
+	testKeyID  = "test-key"
+	testSuffix = "@iplantcollaborative.org"
+)
+
+var testKey *rsa.PrivateKey
+
+func init() {
+	var err error
+	if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+}
+
+// testJWKS returns the public part of testKey as a JSON Web Key Set.
+func testJWKS() []byte {
+	b, _ := json.Marshal(jwks{Keys: []jwk{{
+		Kid: testKeyID,
+		Kty: "RSA",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(testKey.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(testKey.PublicKey.E)).Bytes()),
+	}}})
+	return b
+}
+
+func testToken(t *testing.T, modify func(*Claims)) string {
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		AuthorizedParty:   "de",
+		PreferredUsername: "ipcdev",
+	}
+	claims.RealmAccess.Roles = []string{"de-users"}
+	if modify != nil {
+		modify(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+
+	signed, err := token.SignedString(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func testAuthenticator(trusted bool) *Authenticator {
+	return New(&Config{
+		Issuer:             testIssuer,
+		Audience:           "de",
+		UserSuffix:         testSuffix,
+		TrustedServiceMode: trusted,
+		Keys:               StaticKeySet{testKeyID: &testKey.PublicKey},
+		SupportRoles:       []string{"vice-support"},
+		OperatorRoles:      []string{"vice-operators"},
+		ServiceRoles:       []string{"de-services"},
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		description string
+		modify      func(*Claims)
+		valid       bool
+	}{
+		{"valid", nil, true},
+		{"expired", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, false},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://elsewhere.example.org/realms/CyVerse" }, false},
+		{"wrong audience", func(c *Claims) { c.AuthorizedParty = "other" }, false},
+		{"audience claim", func(c *Claims) { c.AuthorizedParty = "other"; c.Audience = jwt.ClaimStrings{"de"} }, true},
+		{"no username", func(c *Claims) { c.PreferredUsername = "" }, false},
+	}
+
+	a := testAuthenticator(false)
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			user, err := a.Authenticate(testToken(t, test.modify))
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, "ipcdev", user.Username)
+				assert.Contains(t, user.Roles, "de-users")
+			}
+		})
+	}
+}
+
+func TestAuthenticateUnknownKey(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{PreferredUsername: "ipcdev"})
+	token.Header["kid"] = "other-key"
+	signed, err := token.SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = testAuthenticator(false).Authenticate(signed)
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		description string
+		trusted     bool
+		token       string
+		query       string
+		status      int
+		username    string
+	}{
+		{"valid token", false, testToken(t, nil), "", http.StatusOK, "ipcdev"},
+		{"matching user", false, testToken(t, nil), "?user=ipcdev" + testSuffix, http.StatusOK, "ipcdev"},
+		{"mismatched user", false, testToken(t, nil), "?user=someoneelse", http.StatusForbidden, ""},
+		{"service acting for a user", false, testToken(t, func(c *Claims) {
+			c.PreferredUsername = "service-account-apps"
+			c.RealmAccess.Roles = []string{"de-services"}
+		}), "?user=someoneelse", http.StatusOK, "service-account-apps"},
+		{"invalid token", true, "not-a-token", "", http.StatusUnauthorized, ""},
+		{"missing token", false, "", "?user=ipcdev", http.StatusUnauthorized, ""},
+		{"trusted service", true, "", "?user=ipcdev", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var username string
+			e := echo.New()
+			e.Use(testAuthenticator(test.trusted).Middleware(func(c echo.Context) bool {
+				return c.Path() == "/metrics"
+			}))
+			e.GET("/vice/:host/url-ready", func(c echo.Context) error {
+				if user := CurrentUser(c); user != nil {
+					username = user.Username
+				}
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/vice/a1234/url-ready"+test.query, nil)
+			if test.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(test.status, rec.Code)
+			assert.Equal(test.username, username)
+			if test.status == http.StatusUnauthorized {
+				assert.Equal("Bearer", rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	support := testToken(t, func(c *Claims) { c.RealmAccess.Roles = append(c.RealmAccess.Roles, "vice-support") })
+	operator := testToken(t, func(c *Claims) { c.Groups = []string{"/vice-operators"} })
+	user := testToken(t, nil)
+
+	tests := []struct {
+		description string
+		trusted     bool
+		method      string
+		token       string
+		status      int
+	}{
+		{"support can read", false, http.MethodGet, support, http.StatusOK},
+		{"support cannot exit", false, http.MethodPost, support, http.StatusForbidden},
+		{"operator can read", false, http.MethodGet, operator, http.StatusOK},
+		{"operator can exit", false, http.MethodPost, operator, http.StatusOK},
+		{"user cannot read", false, http.MethodGet, user, http.StatusForbidden},
+		{"user cannot exit", false, http.MethodPost, user, http.StatusForbidden},
+		{"trusted service without a token", true, http.MethodPost, "", http.StatusUnauthorized},
+		{"trusted service without a token cannot read", true, http.MethodGet, "", http.StatusUnauthorized},
+		{"operator in trusted service mode", true, http.MethodPost, operator, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			a := testAuthenticator(test.trusted)
+
+			e := echo.New()
+			e.Use(a.Middleware(nil))
+			admin := e.Group("/vice/admin", a.AdminMiddleware())
+			admin.Any("/analyses/:analysis-id/exit", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(test.method, "/vice/admin/analyses/a1234/exit", nil)
+			if test.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestOperatorMiddleware(t *testing.T) {
+	support := testToken(t, func(c *Claims) { c.RealmAccess.Roles = append(c.RealmAccess.Roles, "vice-support") })
+	operator := testToken(t, func(c *Claims) { c.Groups = []string{"/vice-operators"} })
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{support, http.StatusForbidden},
+		{operator, http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"not-a-token", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		// Trusted service mode and skipping the route in Middleware don't let
+		// requests without an operator's token through.
+		a := testAuthenticator(true)
+
+		e := echo.New()
+		e.Use(a.Middleware(func(c echo.Context) bool { return true }))
+		e.GET("/vice/admin/analyses/:analysis-id/exec", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		}, a.OperatorMiddleware())
+
+		req := httptest.NewRequest(http.MethodGet, "/vice/admin/analyses/a1234/exec", nil)
+		if test.token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.status, rec.Code)
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	assert := assert.New(t)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(testJWKS()) // nolint:errcheck
+	}))
+	defer server.Close()
+
+	keys := NewRemoteKeySet(server.URL, nil)
+
+	key, err := keys.Key(testKeyID)
+	if assert.NoError(err) {
+		assert.Equal(testKey.PublicKey.N, key.N)
+	}
+
+	_, err = keys.Key("rotated-key")
+	assert.Error(err)
+	assert.Equal(1, fetches, "the keys should not be fetched again within the refresh interval")
+}
+
+func TestLoadKeySetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+	if err = ioutil.WriteFile(path, testJWKS(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadKeySetFile(path)
+	if assert.NoError(t, err) {
+		key, err := keys.Key(testKeyID)
+		assert.NoError(t, err)
+		assert.Equal(t, testKey.PublicKey.E, key.E)
+	}
+}
+
+func TestKeycloakURLs(t *testing.T) {
+	assert.Equal(t, testIssuer, KeycloakIssuer("https://keycloak.example.org/", "CyVerse"))
+	assert.Equal(t, testIssuer+"/protocol/openid-connect/certs", KeycloakJWKSURL("https://keycloak.example.org", "CyVerse"))
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeySet looks up the public keys used to verify the signatures on tokens.
+type KeySet interface {
+	Key(kid string) (*rsa.PublicKey, error)
+}
+
+// jwk is a single key in a JSON Web Key Set. Only RSA keys are supported,
+// since that's what Keycloak signs tokens with.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parseJWKS returns the RSA signing keys in a JSON Web Key Set, keyed by ID.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	set := &jwks{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, errors.Wrap(err, "error parsing the key set")
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding the modulus of key %s", k.Kid)
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding the exponent of key %s", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	}
+
+	return keys, nil
+}
+
+// StaticKeySet is a KeySet that doesn't change. It stands in for Keycloak in
+// tests and in environments that don't have it.
+type StaticKeySet map[string]*rsa.PublicKey
+
+// Key returns the key with the given ID.
+func (s StaticKeySet) Key(kid string) (*rsa.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return key, nil
+}
+
+// LoadKeySetFile reads a StaticKeySet from a JSON Web Key Set file.
+func LoadKeySetFile(path string) (StaticKeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading key set file %s", path)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading key set file %s", path)
+	}
+
+	return StaticKeySet(keys), nil
+}
+
+// RemoteKeySet is a KeySet that's downloaded from a JWKS endpoint, such as the
+// one for a Keycloak realm. The keys are fetched again when a token is signed
+// with a key that isn't in the set, which happens when the realm's keys are
+// rotated, but no more often than once every minRefresh.
+type RemoteKeySet struct {
+	url        string
+	client     *http.Client
+	minRefresh time.Duration
+
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	lock    sync.Mutex
+}
+
+// NewRemoteKeySet returns a *RemoteKeySet that downloads the keys from the
+// given URL.
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &RemoteKeySet{
+		url:        url,
+		client:     client,
+		minRefresh: time.Minute,
+		keys:       map[string]*rsa.PublicKey{},
+	}
+}
+
+func (r *RemoteKeySet) fetch() error {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return errors.Wrapf(err, "error fetching keys from %s", r.url)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading keys from %s", r.url)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d returned fetching keys from %s: %s", resp.StatusCode, r.url, body)
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing keys from %s", r.url)
+	}
+
+	r.keys = keys
+	return nil
+}
+
+// Key returns the key with the given ID, downloading the keys again if it
+// isn't known.
+func (r *RemoteKeySet) Key(kid string) (*rsa.PublicKey, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if key, ok := r.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(r.fetched) >= r.minRefresh {
+		r.fetched = time.Now()
+		if err := r.fetch(); err != nil {
+			return nil, err
+		}
+		if key, ok := r.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+// KeycloakIssuer returns the issuer of the tokens for a Keycloak realm.
+func KeycloakIssuer(baseURL, realm string) string {
+	return fmt.Sprintf("%s/realms/%s", strings.TrimRight(baseURL, "/"), realm)
+}
+
+// KeycloakJWKSURL returns the URL of the key set for a Keycloak realm.
+func KeycloakJWKSURL(baseURL, realm string) string {
+	return KeycloakIssuer(baseURL, realm) + "/protocol/openid-connect/certs"
+}
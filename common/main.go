@@ -22,6 +22,7 @@ type ErrorResponse struct {
 	Message   string                  `json:"message"`
 	ErrorCode string                  `json:"error_code,omitempty"`
 	Details   *map[string]interface{} `json:"details,omitempty"`
+	TraceID   string                  `json:"trace_id,omitempty"`
 }
 
 // ErrorBytes returns a byte-array representation of an ErrorResponse.
@@ -0,0 +1,133 @@
+package groups
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/metrics"
+)
+
+// client records the latency of requests to the groups service.
+var client = metrics.NewClient("groups")
+
+// Lookup finds the groups that a user belongs to.
+type Lookup interface {
+	// Groups returns the names of the groups the user belongs to. The user
+	// should be identified by their username without the domain suffix.
+	Groups(user string) ([]string, error)
+}
+
+// Group is a group returned by the groups service.
+type Group struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GroupList contains a list of groups returned by the groups service.
+type GroupList struct {
+	Groups []Group `json:"groups"`
+}
+
+// Groups looks up group memberships in the groups service.
+type Groups struct {
+	BaseURL string
+
+	// User is the user that requests to the groups service are made as.
+	User string
+	// Timeout limits how long a lookup can take. Zero means no limit.
+	Timeout time.Duration
+}
+
+// Groups returns the names of the groups the user belongs to. A user the groups
+// service doesn't know about doesn't belong to any groups.
+func (g *Groups) Groups(user string) ([]string, error) {
+	requrl, err := url.Parse(g.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	requrl.Path = filepath.Join(requrl.Path, "subjects", user, "groups")
+	requrl.RawQuery = url.Values{"user": []string{g.User}}.Encode()
+
+	ctx := context.Background()
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, requrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status code %d returned looking up the groups for %s: %s", resp.StatusCode, user, b)
+	}
+
+	list := &GroupList{}
+	if err = json.Unmarshal(b, list); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(list.Groups))
+	for _, group := range list.Groups {
+		names = append(names, group.Name)
+	}
+
+	return names, nil
+}
+
+// Local is a stand-in for the groups service that looks up group memberships
+// in a fixed list, for deployments that don't have a groups service.
+type Local struct {
+	groups map[string][]string
+}
+
+// Membership lists the members of a group. It's read from a list in the config
+// file rather than a map keyed by group name, since the config parser
+// lowercases map keys.
+type Membership struct {
+	Name    string   `mapstructure:"name"`
+	Members []string `mapstructure:"members"`
+}
+
+// NewLocal returns a *Local for the given group memberships.
+func NewLocal(memberships []Membership) *Local {
+	groups := map[string][]string{}
+	for _, membership := range memberships {
+		for _, user := range membership.Members {
+			groups[user] = append(groups[user], membership.Name)
+		}
+	}
+	for _, names := range groups {
+		sort.Strings(names)
+	}
+	return &Local{groups: groups}
+}
+
+// Groups returns the names of the groups the user belongs to.
+func (l *Local) Groups(user string) ([]string, error) {
+	return append([]string{}, l.groups[user]...), nil
+}
@@ -0,0 +1,68 @@
+package groups
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("de_grouper", r.URL.Query().Get("user"))
+		switch r.URL.Path {
+		case "/subjects/foo/groups":
+			w.Write([]byte(`{"groups":[{"id":"1","name":"iplant:labs:lab"},{"id":"2","name":"iplant:courses:course"}]}`)) // nolint:errcheck
+		case "/subjects/bar/groups":
+			http.Error(w, "subject not found", http.StatusNotFound)
+		default:
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	g := &Groups{BaseURL: server.URL, User: "de_grouper"}
+
+	names, err := g.Groups("foo")
+	assert.NoError(err)
+	assert.Equal([]string{"iplant:labs:lab", "iplant:courses:course"}, names)
+
+	names, err = g.Groups("bar")
+	assert.NoError(err)
+	assert.Empty(names, "an unknown user shouldn't belong to any groups")
+
+	_, err = g.Groups("baz")
+	assert.Error(err)
+}
+
+func TestGroupsTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	g := &Groups{BaseURL: server.URL, User: "de_grouper", Timeout: 10 * time.Millisecond}
+	_, err := g.Groups("foo")
+	assert.Error(t, err, "a slow groups service should time out")
+}
+
+func TestLocal(t *testing.T) {
+	l := NewLocal([]Membership{
+		{Name: "iplant:labs:Lab", Members: []string{"foo", "bar"}},
+		{Name: "course", Members: []string{"foo"}},
+	})
+
+	names, err := l.Groups("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"course", "iplant:labs:Lab"}, names)
+
+	names, err = l.Groups("quux")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
@@ -37,7 +37,7 @@ type DefaultInstantLaunchMapping struct {
 // LatestDefaultsHandler is the echo handler for the http API that returns the
 // default mapping of instant launches to file patterns.
 func (a *App) LatestDefaultsHandler(c echo.Context) error {
-	defaults, err := a.LatestDefaults()
+	defaults, err := a.LatestDefaults(c.Request().Context())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -54,7 +54,7 @@ func (a *App) UpdateLatestDefaultsHandler(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
-	updated, err := a.UpdateLatestDefaults(newdefaults)
+	updated, err := a.UpdateLatestDefaults(c.Request().Context(), newdefaults)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -67,7 +67,7 @@ func (a *App) UpdateLatestDefaultsHandler(c echo.Context) error {
 // DeleteLatestDefaultsHandler is the echo handler for the HTTP API that allows
 // the caller to delete the latest default mappings from the database.
 func (a *App) DeleteLatestDefaultsHandler(c echo.Context) error {
-	return a.DeleteLatestDefaults()
+	return a.DeleteLatestDefaults(c.Request().Context())
 }
 
 // AddLatestDefaultsHandler is the echo handler for the HTTP API that allows the
@@ -87,7 +87,7 @@ func (a *App) AddLatestDefaultsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
 
-	newentry, err := a.AddLatestDefaults(update, addedBy)
+	newentry, err := a.AddLatestDefaults(c.Request().Context(), update, addedBy)
 	if err != nil {
 		return err
 	}
@@ -103,7 +103,7 @@ func (a *App) DefaultsByVersionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot process version")
 	}
 
-	m, err := a.DefaultsByVersion(int(version))
+	m, err := a.DefaultsByVersion(c.Request().Context(), int(version))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -129,7 +129,7 @@ func (a *App) UpdateDefaultsByVersionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot process version")
 	}
 
-	updated, err := a.UpdateDefaultsByVersion(newvalue, int(version))
+	updated, err := a.UpdateDefaultsByVersion(c.Request().Context(), newvalue, int(version))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -148,7 +148,7 @@ func (a *App) DeleteDefaultsByVersionHandler(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot process version")
 	}
-	return a.DeleteDefaultsByVersion(int(version))
+	return a.DeleteDefaultsByVersion(c.Request().Context(), int(version))
 }
 
 // A ListAllDefaultsResponse is the response body for listing all of the default mappings.
@@ -164,7 +164,7 @@ type ListAllDefaultsResponse struct {
 // ListDefaultsHandler is the echo handler for the http API that returns a list of
 // all defaults listed in the database, regardless of version.
 func (a *App) ListDefaultsHandler(c echo.Context) error {
-	m, err := a.ListAllDefaults()
+	m, err := a.ListAllDefaults(c.Request().Context())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -172,6 +172,9 @@ type App struct {
 	UserSuffix      string
 	MetadataBaseURL string
 	Permissions     *permissions.Permissions
+
+	// adminMiddleware is added to the routes that change the default mappings.
+	adminMiddleware []echo.MiddlewareFunc
 }
 
 // Init configuration for the instant launches.
@@ -179,6 +182,12 @@ type Init struct {
 	UserSuffix      string
 	MetadataBaseURL string
 	PermissionsURL  string
+
+	// AuditMiddleware, if set, records the changes to the default mappings.
+	AuditMiddleware echo.MiddlewareFunc
+
+	// AdminMiddleware, if set, restricts who can change the default mappings.
+	AdminMiddleware echo.MiddlewareFunc
 }
 
 // New returns a newly created *App.
@@ -193,15 +202,24 @@ func New(db *sqlx.DB, group *echo.Group, init *Init) *App {
 		},
 	}
 
+	// The audit middleware comes first so that requests turned away by the
+	// admin middleware are recorded too.
+	if init.AuditMiddleware != nil {
+		instance.adminMiddleware = append(instance.adminMiddleware, init.AuditMiddleware)
+	}
+	if init.AdminMiddleware != nil {
+		instance.adminMiddleware = append(instance.adminMiddleware, init.AdminMiddleware)
+	}
+
 	instance.Group.GET("/quicklaunches/public", instance.ListViablePublicQuickLaunchesHandler)
 	instance.Group.GET("/mappings/defaults", instance.ListDefaultsHandler)
 	instance.Group.GET("/mappings/defaults/latest", instance.LatestDefaultsHandler)
-	instance.Group.PUT("/mappings/defaults/latest", instance.AddLatestDefaultsHandler)
-	instance.Group.POST("/mappings/defaults/latest", instance.UpdateLatestDefaultsHandler)
-	instance.Group.DELETE("/mappings/defaults/latest", instance.DeleteLatestDefaultsHandler)
+	instance.Group.PUT("/mappings/defaults/latest", instance.AddLatestDefaultsHandler, instance.adminMiddleware...)
+	instance.Group.POST("/mappings/defaults/latest", instance.UpdateLatestDefaultsHandler, instance.adminMiddleware...)
+	instance.Group.DELETE("/mappings/defaults/latest", instance.DeleteLatestDefaultsHandler, instance.adminMiddleware...)
 	instance.Group.GET("/mappings/defaults/:version", instance.DefaultsByVersionHandler)
-	instance.Group.POST("/mappings/defaults/:version", instance.UpdateDefaultsByVersionHandler)
-	instance.Group.DELETE("/mappings/defaults/:version", instance.DeleteDefaultsByVersionHandler)
+	instance.Group.POST("/mappings/defaults/:version", instance.UpdateDefaultsByVersionHandler, instance.adminMiddleware...)
+	instance.Group.DELETE("/mappings/defaults/:version", instance.DeleteDefaultsByVersionHandler, instance.adminMiddleware...)
 	instance.Group.GET("/mappings/:username", instance.AllUserMappingsHandler)
 	instance.Group.GET("/mappings/:username/latest", instance.UserMappingHandler)
 	instance.Group.PUT("/mappings/:username", instance.AddUserMappingHandler)
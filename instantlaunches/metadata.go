@@ -2,6 +2,7 @@ package instantlaunches
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
@@ -10,21 +11,25 @@ import (
 	"path"
 
 	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/app-exposer/metrics"
 	"github.com/labstack/echo/v4"
 	"github.com/valyala/fastjson"
 )
 
 var log = common.Log
 
+// metadataClient records the latency of requests to the metadata service.
+var metadataClient = metrics.NewClient("metadata")
+
 func handleError(err error, statusCode int) error {
 	log.Error(err)
 	return echo.NewHTTPError(statusCode, err.Error())
 }
 
 // InstantLaunchExists returns true if the id passed in exists in the database
-func (a *App) InstantLaunchExists(id string) (bool, error) {
+func (a *App) InstantLaunchExists(ctx context.Context, id string) (bool, error) {
 	var count int
-	err := a.DB.Get(&count, "SELECT COUNT(*) FROM instant_launches WHERE id = $1;", id)
+	err := a.DB.GetContext(ctx, &count, "SELECT COUNT(*) FROM instant_launches WHERE id = $1;", id)
 	return count > 0, err
 }
 
@@ -65,7 +70,7 @@ func (a *App) listAVUs(c echo.Context) ([]byte, *http.Response, error) {
 
 	log.Debug(fmt.Sprintf("metadata endpoint: GET %s", svc.String()))
 
-	resp, err := http.Get(svc.String())
+	resp, err := metadataClient.Get(svc.String())
 	if err != nil {
 		return nil, nil, handleError(err, http.StatusInternalServerError)
 	}
@@ -107,7 +112,7 @@ func (a *App) FullListMetadataHandler(c echo.Context) error {
 		targetIDs = append(targetIDs, string(avu.GetStringBytes("target_id")))
 	}
 
-	fullListing, err := a.ListFullInstantLaunchesByIDs(targetIDs)
+	fullListing, err := a.ListFullInstantLaunchesByIDs(c.Request().Context(), targetIDs)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "no instant launches found")
@@ -143,7 +148,7 @@ func (a *App) GetMetadataHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "user is missing")
 	}
 
-	exists, err := a.InstantLaunchExists(id)
+	exists, err := a.InstantLaunchExists(c.Request().Context(), id)
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
@@ -164,7 +169,7 @@ func (a *App) GetMetadataHandler(c echo.Context) error {
 
 	log.Debug(fmt.Sprintf("metadata endpoint: GET %s", svc.String()))
 
-	resp, err := http.Get(svc.String())
+	resp, err := metadataClient.Get(svc.String())
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
@@ -194,7 +199,7 @@ func (a *App) AddOrUpdateMetadataHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "user is missing")
 	}
 
-	exists, err := a.InstantLaunchExists(id)
+	exists, err := a.InstantLaunchExists(c.Request().Context(), id)
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
@@ -220,7 +225,7 @@ func (a *App) AddOrUpdateMetadataHandler(c echo.Context) error {
 
 	log.Debug(fmt.Sprintf("metadata endpoint: POST %s", svc.String()))
 
-	resp, err := http.Post(svc.String(), "application/json", bytes.NewReader(inBody))
+	resp, err := metadataClient.Post(svc.String(), "application/json", bytes.NewReader(inBody))
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
@@ -250,7 +255,7 @@ func (a *App) SetAllMetadataHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "user is missing")
 	}
 
-	exists, err := a.InstantLaunchExists(id)
+	exists, err := a.InstantLaunchExists(c.Request().Context(), id)
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
@@ -283,7 +288,7 @@ func (a *App) SetAllMetadataHandler(c echo.Context) error {
 
 	req.Header.Set("content-type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := metadataClient.Do(req)
 	if err != nil {
 		return handleError(err, http.StatusInternalServerError)
 	}
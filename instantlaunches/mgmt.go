@@ -24,7 +24,7 @@ func (a *App) AddInstantLaunchHandler(c echo.Context) error {
 		il.AddedBy = fmt.Sprintf("%s%s", il.AddedBy, a.UserSuffix)
 	}
 
-	newil, err := a.AddInstantLaunch(il.QuickLaunchID, il.AddedBy)
+	newil, err := a.AddInstantLaunch(c.Request().Context(), il.QuickLaunchID, il.AddedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -43,7 +43,7 @@ func (a *App) GetInstantLaunchHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "id is missing")
 	}
 
-	il, err := a.GetInstantLaunch(id)
+	il, err := a.GetInstantLaunch(c.Request().Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -63,7 +63,7 @@ func (a *App) FullInstantLaunchHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "id is missing")
 	}
 
-	il, err := a.FullInstantLaunch(id)
+	il, err := a.FullInstantLaunch(c.Request().Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -86,7 +86,7 @@ func (a *App) UpdateInstantLaunchHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
 
-	newvalue, err := a.UpdateInstantLaunch(id, updated.QuickLaunchID)
+	newvalue, err := a.UpdateInstantLaunch(c.Request().Context(), id, updated.QuickLaunchID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -105,7 +105,7 @@ func (a *App) DeleteInstantLaunchHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "id is missing")
 	}
 
-	err := a.DeleteInstantLaunch(id)
+	err := a.DeleteInstantLaunch(c.Request().Context(), id)
 	return err
 
 }
@@ -113,7 +113,7 @@ func (a *App) DeleteInstantLaunchHandler(c echo.Context) error {
 // ListInstantLaunchesHandler is the HTTP handler for listing all of the
 // registered Instant Launches.
 func (a *App) ListInstantLaunchesHandler(c echo.Context) error {
-	list, err := a.ListInstantLaunches()
+	list, err := a.ListInstantLaunches(c.Request().Context())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -127,7 +127,7 @@ func (a *App) ListInstantLaunchesHandler(c echo.Context) error {
 // FullListInstantLaunchesHandler is the HTTP handler for performing a full
 // listing of all registered instant launches.
 func (a *App) FullListInstantLaunchesHandler(c echo.Context) error {
-	list, err := a.FullListInstantLaunches()
+	list, err := a.FullListInstantLaunches(c.Request().Context())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -151,7 +151,7 @@ func (a *App) ListViablePublicQuickLaunchesHandler(c echo.Context) error {
 		user = fmt.Sprintf("%s%s", user, a.UserSuffix)
 	}
 
-	list, err := a.ListViablePublicQuickLaunches(user)
+	list, err := a.ListViablePublicQuickLaunches(c.Request().Context(), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
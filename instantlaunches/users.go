@@ -22,7 +22,7 @@ func (a *App) UserMappingHandler(c echo.Context) error {
 		user = fmt.Sprintf("%s%s", user, a.UserSuffix)
 	}
 
-	m, err := a.UserMapping(user)
+	m, err := a.UserMapping(c.Request().Context(), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -49,7 +49,7 @@ func (a *App) UpdateUserMappingHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
 
-	updated, err := a.UpdateUserMapping(user, newdefaults)
+	updated, err := a.UpdateUserMapping(c.Request().Context(), user, newdefaults)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -70,7 +70,7 @@ func (a *App) DeleteUserMappingHandler(c echo.Context) error {
 	if !strings.HasSuffix(user, a.UserSuffix) {
 		user = fmt.Sprintf("%s%s", user, a.UserSuffix)
 	}
-	return a.DeleteUserMapping(user)
+	return a.DeleteUserMapping(c.Request().Context(), user)
 }
 
 // AddUserMappingHandler is the HTTP handler for adding a new user mapping to the database.
@@ -89,7 +89,7 @@ func (a *App) AddUserMappingHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
 
-	retval, err := a.AddUserMapping(user, newvalue)
+	retval, err := a.AddUserMapping(c.Request().Context(), user, newvalue)
 	if err != nil {
 		return err
 	}
@@ -109,7 +109,7 @@ func (a *App) AllUserMappingsHandler(c echo.Context) error {
 		user = fmt.Sprintf("%s%s", user, a.UserSuffix)
 	}
 
-	m, err := a.AllUserMappings(user)
+	m, err := a.AllUserMappings(c.Request().Context(), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -137,7 +137,7 @@ func (a *App) UserMappingsByVersionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot process version")
 	}
 
-	m, err := a.UserMappingsByVersion(user, int(version))
+	m, err := a.UserMappingsByVersion(c.Request().Context(), user, int(version))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -172,7 +172,7 @@ func (a *App) UpdateUserMappingsByVersionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot parse JSON")
 	}
 
-	newversion, err := a.UpdateUserMappingsByVersion(user, int(version), update)
+	newversion, err := a.UpdateUserMappingsByVersion(c.Request().Context(), user, int(version), update)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
@@ -200,5 +200,5 @@ func (a *App) DeleteUserMappingsByVersionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot process version")
 	}
 
-	return a.DeleteUserMappingsByVersion(user, int(version))
+	return a.DeleteUserMappingsByVersion(c.Request().Context(), user, int(version))
 }
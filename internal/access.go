@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/app-exposer/apps"
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/cyverse-de/app-exposer/permissions"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// modifyPermissionLevel is the permission level a user other than the one who
+// launched an analysis needs to change it.
+const modifyPermissionLevel = "write"
+
+// callerUsername returns the user making the request, without the user suffix.
+// That's the user in the token, unless the token belongs to a trusted service
+// acting on behalf of the user in the user query parameter. Requests without a
+// token only get this far when authentication is disabled or in trusted
+// service mode, and the user parameter is used for those as it was before
+// tokens were checked. An empty string means the caller is unknown.
+func (i *Internal) callerUsername(c echo.Context) string {
+	param := strings.TrimSuffix(c.QueryParam("user"), i.UserSuffix)
+
+	user := auth.CurrentUser(c)
+	if user == nil {
+		return param
+	}
+	if user.Service && param != "" {
+		return param
+	}
+	return user.Username
+}
+
+// checkAnalysisAccess returns an error unless the caller launched the analysis
+// with the external ID or has been granted at least the given permission level
+// on it.
+func (i *Internal) checkAnalysisAccess(c echo.Context, externalID, level string) error {
+	user := i.callerUsername(c)
+	if user == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
+	}
+
+	a := apps.NewApps(i.db, i.UserSuffix)
+
+	analysisID, err := a.GetAnalysisIDByExternalID(c.Request().Context(), externalID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no analysis found for external-id %s", externalID))
+		}
+		return errors.Wrapf(err, "error looking up the analysis ID for external-id %s", externalID)
+	}
+
+	return i.checkUserAnalysisAccess(c, user, analysisID, level)
+}
+
+// checkAnalysisIDAccess is like checkAnalysisAccess, but for the analysis with
+// the analysis ID.
+func (i *Internal) checkAnalysisIDAccess(c echo.Context, analysisID, level string) error {
+	user := i.callerUsername(c)
+	if user == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
+	}
+
+	return i.checkUserAnalysisAccess(c, user, analysisID, level)
+}
+
+// checkUserAnalysisAccess returns an error unless the user launched the
+// analysis or has been granted at least the given permission level on it.
+func (i *Internal) checkUserAnalysisAccess(c echo.Context, user, analysisID, level string) error {
+	a := apps.NewApps(i.db, i.UserSuffix)
+
+	owner, _, err := a.GetUserByAnalysisID(c.Request().Context(), analysisID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no analysis found for analysis-id %s", analysisID))
+		}
+		return errors.Wrapf(err, "error looking up the user for analysis %s", analysisID)
+	}
+
+	if owner == user {
+		return nil
+	}
+
+	p := &permissions.Permissions{
+		BaseURL: i.PermissionsURL,
+	}
+
+	allowed, err := p.HasLevel(user, analysisID, level)
+	if err != nil {
+		return errors.Wrapf(err, "error checking the permissions of user %s on analysis %s", user, analysisID)
+	}
+
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("user %s does not have %s access to analysis %s", user, level, analysisID))
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// permissionsServer returns a fake permissions service that grants the
+// given permission level on every analysis, or nothing if the level is blank.
+func permissionsServer(level string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if level == "" {
+			fmt.Fprint(w, `{"permissions": []}`)
+			return
+		}
+		fmt.Fprintf(w, `{"permissions": [{"permission_level": "%s"}]}`, level)
+	}))
+}
+
+func TestCheckAnalysisAccess(t *testing.T) {
+	// Requests made by a trusted service or without a token name the user in
+	// the user parameter. Anyone else is the user in their token.
+	service := &auth.User{Username: "service-account-apps", Service: true}
+
+	tests := []struct {
+		description string
+		caller      *auth.User
+		user        string
+		level       string
+		found       bool
+		status      int
+	}{
+		{"owner", service, "ipcdev@example.org", "", true, 0},
+		{"owner without suffix", service, "ipcdev", "", true, 0},
+		{"owner with a token", &auth.User{Username: "ipcdev"}, "", "", true, 0},
+		{"shared with write access", service, "other", "write", true, 0},
+		{"shared as owner", service, "other", "own", true, 0},
+		{"shared with read access", service, "other", "read", true, http.StatusForbidden},
+		{"not shared", service, "other", "", true, http.StatusForbidden},
+		{"user parameter from a user", &auth.User{Username: "other"}, "ipcdev", "", true, http.StatusForbidden},
+		{"service acting as itself", service, "", "", true, http.StatusForbidden},
+		{"owner without a token", nil, "ipcdev", "", true, 0},
+		{"no token or user parameter", nil, "", "", false, http.StatusForbidden},
+		{"unknown analysis", service, "ipcdev", "", false, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			server := permissionsServer(test.level)
+			defer server.Close()
+
+			i, mock := setupInternal(t, nil)
+			i.PermissionsURL = server.URL
+
+			if test.found || test.status == http.StatusNotFound {
+				query := mock.ExpectQuery("SELECT j.id FROM jobs j JOIN job_steps s").WithArgs("external-id")
+				if test.found {
+					query.WillReturnRows(mock.NewRows([]string{"id"}).AddRow("analysis-id"))
+					mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+						WithArgs("analysis-id").
+						WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+				} else {
+					query.WillReturnError(sql.ErrNoRows)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/vice/external-id/exit?user="+test.user, nil)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			if test.caller != nil {
+				auth.SetCurrentUser(c, test.caller)
+			}
+
+			err := i.checkAnalysisAccess(c, "external-id", modifyPermissionLevel)
+			if test.status == 0 {
+				assert.NoError(err)
+			} else if assert.Error(err) {
+				httpErr, ok := err.(*echo.HTTPError)
+				if assert.True(ok) {
+					assert.Equal(test.status, httpErr.Code)
+				}
+			}
+			assert.NoError(mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCallerUsername(t *testing.T) {
+	tests := []struct {
+		description string
+		caller      *auth.User
+		query       string
+		expected    string
+	}{
+		{"user with a token", &auth.User{Username: "ipcdev"}, "", "ipcdev"},
+		{"user naming someone else", &auth.User{Username: "ipcdev"}, "?user=other", "ipcdev"},
+		{"service acting for a user", &auth.User{Username: "service-account-apps", Service: true}, "?user=ipcdev@example.org", "ipcdev"},
+		{"service acting as itself", &auth.User{Username: "service-account-apps", Service: true}, "", "service-account-apps"},
+		{"no token", nil, "?user=ipcdev@example.org", "ipcdev"},
+		{"nobody", nil, "", ""},
+	}
+
+	i, _ := setupInternal(t, nil)
+
+	for _, test := range tests {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/vice/analysis-id/logs"+test.query, nil), httptest.NewRecorder())
+		if test.caller != nil {
+			auth.SetCurrentUser(c, test.caller)
+		}
+		assert.Equal(t, test.expected, i.callerUsername(c), test.description)
+	}
+}
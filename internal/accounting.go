@@ -0,0 +1,460 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// gpuResourceName is the name of the resource requested for GPUs.
+const gpuResourceName = apiv1.ResourceName("nvidia.com/gpu")
+
+// bytesPerGiB is the number of bytes in a gibibyte.
+const bytesPerGiB = 1024 * 1024 * 1024
+
+// resourceTotals contains the total amount of each resource requested by the
+// containers in a pod.
+type resourceTotals struct {
+	CPUCores    float64
+	MemoryBytes int64
+	GPUs        int64
+}
+
+// requestedQuantity returns the amount of the resource requested by the
+// container in its smallest unit, millicores for CPU. Kubernetes uses the limit
+// as the request when only the limit is set, which is always the case for
+// GPUs.
+func requestedQuantity(container *apiv1.Container, name apiv1.ResourceName) int64 {
+	q, ok := container.Resources.Requests[name]
+	if !ok {
+		q, ok = container.Resources.Limits[name]
+	}
+	if !ok {
+		return 0
+	}
+	return quantityValue(name, q)
+}
+
+// requestedResources returns the resources requested by the containers in the
+// pod spec. Init containers are left out since they only run before the
+// analysis starts.
+func requestedResources(spec *apiv1.PodSpec) resourceTotals {
+	var (
+		totals     resourceTotals
+		millicores int64
+	)
+	for idx := range spec.Containers {
+		container := &spec.Containers[idx]
+		millicores += requestedQuantity(container, apiv1.ResourceCPU)
+		totals.MemoryBytes += requestedQuantity(container, apiv1.ResourceMemory)
+		totals.GPUs += requestedQuantity(container, gpuResourceName)
+	}
+	totals.CPUCores = float64(millicores) / 1000
+	return totals
+}
+
+// UsageRecord contains the compute used by an analysis, worked out from the
+// resources it requested and how long its deployment existed, less the time it
+// spent suspended.
+type UsageRecord struct {
+	AnalysisID     string    `json:"analysis_id" db:"analysis_id"`
+	ExternalID     string    `json:"external_id" db:"external_id"`
+	UserID         string    `json:"user_id" db:"user_id"`
+	Username       string    `json:"username" db:"username"`
+	AppID          string    `json:"app_id" db:"app_id"`
+	AppName        string    `json:"app_name" db:"app_name"`
+	StartDate      time.Time `json:"start_date" db:"start_date"`
+	EndDate        time.Time `json:"end_date" db:"end_date"`
+	CPUCores       float64   `json:"cpu_cores" db:"cpu_cores"`
+	MemoryBytes    int64     `json:"memory_bytes" db:"memory_bytes"`
+	GPUs           int64     `json:"gpus" db:"gpus"`
+	CPUCoreHours   float64   `json:"cpu_core_hours" db:"cpu_core_hours"`
+	MemoryGiBHours float64   `json:"memory_gib_hours" db:"memory_gib_hours"`
+	GPUHours       float64   `json:"gpu_hours" db:"gpu_hours"`
+	SuspendedHours float64   `json:"suspended_hours" db:"suspended_hours"`
+}
+
+// openUsageRecord is a usage record for an analysis whose deployment hasn't
+// been seen deleted yet, along with the end date of the analysis in the jobs
+// table.
+type openUsageRecord struct {
+	UsageRecord
+	SuspendedSeconds float64     `db:"suspended_seconds"`
+	SuspendedAt      pq.NullTime `db:"suspended_at"`
+	JobEndDate       time.Time   `db:"job_end_date"`
+}
+
+// UsageSummary contains the total compute used by the analyses of a user or of
+// an app.
+type UsageSummary struct {
+	Username       string  `json:"username,omitempty" db:"username"`
+	AppID          string  `json:"app_id,omitempty" db:"app_id"`
+	AppName        string  `json:"app_name,omitempty" db:"app_name"`
+	Analyses       int64   `json:"analyses" db:"analyses"`
+	CPUCoreHours   float64 `json:"cpu_core_hours" db:"cpu_core_hours"`
+	MemoryGiBHours float64 `json:"memory_gib_hours" db:"memory_gib_hours"`
+	GPUHours       float64 `json:"gpu_hours" db:"gpu_hours"`
+}
+
+const getUsageAnalysisSQL = `
+	SELECT j.id, j.user_id, u.username, j.app_id, j.app_name
+	  FROM jobs j
+	  JOIN job_steps s ON s.job_id = j.id
+	  JOIN users u ON u.id = j.user_id
+	 WHERE s.external_id = $1
+`
+
+// The record is opened without an end date when the analysis is launched, and
+// kept up to date with the time the analysis has spent suspended until it's
+// finished.
+const openUsageRecordSQL = `
+	INSERT INTO vice_usage_records (
+		analysis_id, external_id, user_id, username, app_id, app_name,
+		start_date, cpu_cores, memory_bytes, gpus,
+		suspended_seconds, suspended_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (analysis_id) DO UPDATE
+	   SET suspended_seconds = EXCLUDED.suspended_seconds,
+	       suspended_at = EXCLUDED.suspended_at
+	 WHERE vice_usage_records.end_date IS NULL
+`
+
+// An analysis is only recorded once, even if more than one instance of the
+// service sees its deployment deleted.
+const insertUsageRecordSQL = `
+	INSERT INTO vice_usage_records (
+		analysis_id, external_id, user_id, username, app_id, app_name,
+		start_date, end_date, cpu_cores, memory_bytes, gpus,
+		cpu_core_hours, memory_gib_hours, gpu_hours, suspended_hours
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	ON CONFLICT (analysis_id) DO UPDATE
+	   SET end_date = EXCLUDED.end_date,
+	       cpu_core_hours = EXCLUDED.cpu_core_hours,
+	       memory_gib_hours = EXCLUDED.memory_gib_hours,
+	       gpu_hours = EXCLUDED.gpu_hours,
+	       suspended_hours = EXCLUDED.suspended_hours,
+	       suspended_at = NULL
+	 WHERE vice_usage_records.end_date IS NULL
+`
+
+// The open records of analyses whose deployments are gone. Records started
+// after the deployments were listed are left alone, since their deployments
+// may have been created after the listing. The analyses that the jobs table
+// doesn't have an end date for are ended now.
+const listUnfinishedUsageSQL = `
+	SELECT r.analysis_id, r.external_id, r.user_id, r.username, r.app_id,
+	       r.app_name, r.start_date, r.cpu_cores, r.memory_bytes, r.gpus,
+	       r.suspended_seconds, r.suspended_at,
+	       coalesce(j.end_date, now()) AS job_end_date
+	  FROM vice_usage_records r
+	  JOIN jobs j ON j.id = r.analysis_id
+	 WHERE r.end_date IS NULL
+	   AND r.start_date < $2
+	   AND NOT (r.external_id = ANY($1))
+`
+
+const finishUsageRecordSQL = `
+	UPDATE vice_usage_records
+	   SET end_date = $2,
+	       cpu_core_hours = $3,
+	       memory_gib_hours = $4,
+	       gpu_hours = $5,
+	       suspended_hours = $6,
+	       suspended_at = NULL
+	 WHERE analysis_id = $1
+	   AND end_date IS NULL
+`
+
+// Blank filters match everything.
+const summarizeUsageByUserSQL = `
+	SELECT username,
+	       count(*) AS analyses,
+	       sum(cpu_core_hours) AS cpu_core_hours,
+	       sum(memory_gib_hours) AS memory_gib_hours,
+	       sum(gpu_hours) AS gpu_hours
+	  FROM vice_usage_records
+	 WHERE end_date IS NOT NULL
+	   AND ($1 = '' OR username = $1)
+	   AND ($2::timestamptz IS NULL OR end_date >= $2)
+	   AND ($3::timestamptz IS NULL OR end_date < $3)
+	 GROUP BY username
+	 ORDER BY username
+`
+
+// Blank filters match everything.
+const summarizeUsageByAppSQL = `
+	SELECT app_id,
+	       max(app_name) AS app_name,
+	       count(*) AS analyses,
+	       sum(cpu_core_hours) AS cpu_core_hours,
+	       sum(memory_gib_hours) AS memory_gib_hours,
+	       sum(gpu_hours) AS gpu_hours
+	  FROM vice_usage_records
+	 WHERE end_date IS NOT NULL
+	   AND ($1 = '' OR app_id = $1)
+	   AND ($2::timestamptz IS NULL OR end_date >= $2)
+	   AND ($3::timestamptz IS NULL OR end_date < $3)
+	 GROUP BY app_id
+	 ORDER BY app_id
+`
+
+// finish works out the compute used between the start of the record and the
+// end time, leaving out the time spent suspended.
+func (r *UsageRecord) finish(end time.Time, suspended time.Duration) {
+	r.EndDate = end
+	r.SuspendedHours = suspended.Hours()
+
+	hours := end.Sub(r.StartDate).Hours() - r.SuspendedHours
+	if hours < 0 {
+		hours = 0
+	}
+
+	r.CPUCoreHours = r.CPUCores * hours
+	r.MemoryGiBHours = float64(r.MemoryBytes) / bytesPerGiB * hours
+	r.GPUHours = float64(r.GPUs) * hours
+}
+
+// deploymentUsageRecord returns a usage record with the start time and the
+// resources requested by the deployment. The analysis, user and app fields are
+// left for the caller to fill in.
+func deploymentUsageRecord(deployment *appsv1.Deployment) *UsageRecord {
+	totals := requestedResources(&deployment.Spec.Template.Spec)
+
+	return &UsageRecord{
+		ExternalID:  deployment.Labels["external-id"],
+		StartDate:   deployment.CreationTimestamp.Time,
+		CPUCores:    totals.CPUCores,
+		MemoryBytes: totals.MemoryBytes,
+		GPUs:        totals.GPUs,
+	}
+}
+
+// newUsageRecord works out the compute used by the deployment between its
+// creation and the end time, less the time it spent suspended. The analysis,
+// user and app fields are left for the caller to fill in.
+func newUsageRecord(deployment *appsv1.Deployment, end time.Time) *UsageRecord {
+	record := deploymentUsageRecord(deployment)
+	record.finish(end, suspendedDuration(deployment, end))
+	return record
+}
+
+// lookUpUsageAnalysis fills in the analysis, user and app fields of the record.
+func (i *Internal) lookUpUsageAnalysis(ctx context.Context, record *UsageRecord) error {
+	if err := i.db.QueryRowContext(ctx, getUsageAnalysisSQL, record.ExternalID).Scan(
+		&record.AnalysisID,
+		&record.UserID,
+		&record.Username,
+		&record.AppID,
+		&record.AppName,
+	); err != nil {
+		return errors.Wrapf(err, "error looking up the analysis for external-id %s", record.ExternalID)
+	}
+	record.Username = strings.TrimSuffix(record.Username, i.UserSuffix)
+
+	return nil
+}
+
+// openUsage stores the usage record of an analysis whose deployment exists,
+// so that the analysis is accounted for even if the deletion of its deployment
+// is missed. The time the deployment has spent suspended so far is updated if
+// the record already exists.
+func (i *Internal) openUsage(ctx context.Context, deployment *appsv1.Deployment) error {
+	record := deploymentUsageRecord(deployment)
+	if err := i.lookUpUsageAnalysis(ctx, record); err != nil {
+		return err
+	}
+
+	seconds, at := suspension(deployment)
+	suspendedAt := pq.NullTime{}
+	if at != nil {
+		suspendedAt = pq.NullTime{Time: *at, Valid: true}
+	}
+
+	if _, err := i.db.ExecContext(
+		ctx,
+		openUsageRecordSQL,
+		record.AnalysisID,
+		record.ExternalID,
+		record.UserID,
+		record.Username,
+		record.AppID,
+		record.AppName,
+		record.StartDate,
+		record.CPUCores,
+		record.MemoryBytes,
+		record.GPUs,
+		seconds,
+		suspendedAt,
+	); err != nil {
+		return errors.Wrapf(err, "error opening the usage record for external-id %s", record.ExternalID)
+	}
+
+	return nil
+}
+
+// recordUsage stores the compute used by the analysis whose deployment was
+// deleted. The wall time runs from the creation of the deployment to its
+// deletion, or to now if the deletion time isn't set, and leaves out the time
+// the analysis spent suspended.
+func (i *Internal) recordUsage(ctx context.Context, deployment *appsv1.Deployment, now time.Time) error {
+	end := now
+	if deployment.DeletionTimestamp != nil {
+		end = deployment.DeletionTimestamp.Time
+	}
+
+	record := newUsageRecord(deployment, end)
+	if err := i.lookUpUsageAnalysis(ctx, record); err != nil {
+		return err
+	}
+
+	if _, err := i.db.ExecContext(
+		ctx,
+		insertUsageRecordSQL,
+		record.AnalysisID,
+		record.ExternalID,
+		record.UserID,
+		record.Username,
+		record.AppID,
+		record.AppName,
+		record.StartDate,
+		record.EndDate,
+		record.CPUCores,
+		record.MemoryBytes,
+		record.GPUs,
+		record.CPUCoreHours,
+		record.MemoryGiBHours,
+		record.GPUHours,
+		record.SuspendedHours,
+	); err != nil {
+		return errors.Wrapf(err, "error recording the usage for external-id %s", record.ExternalID)
+	}
+
+	return nil
+}
+
+// reconcileUsage opens usage records for the VICE deployments in the cluster
+// and finishes the open records of the analyses whose deployments are gone,
+// using the end dates in the jobs table. This catches the deployments whose
+// creation or deletion was missed, such as while no replica was the leader.
+func (i *Internal) reconcileUsage(ctx context.Context) error {
+	listed := time.Now()
+
+	depList, err := i.deploymentList(i.ViceNamespace, map[string]string{}, []string{})
+	if err != nil {
+		return errors.Wrap(err, "error listing deployments")
+	}
+
+	externalIDs := []string{}
+	for idx := range depList.Items {
+		deployment := &depList.Items[idx]
+		externalIDs = append(externalIDs, deployment.Labels["external-id"])
+
+		if err = i.openUsage(ctx, deployment); err != nil {
+			log.Error(err)
+		}
+	}
+
+	records := []openUsageRecord{}
+	if err = i.db.SelectContext(ctx, &records, listUnfinishedUsageSQL, pq.Array(externalIDs), listed); err != nil {
+		return errors.Wrap(err, "error listing unfinished usage records")
+	}
+
+	for idx := range records {
+		record := &records[idx]
+
+		suspended := time.Duration(record.SuspendedSeconds * float64(time.Second))
+		if record.SuspendedAt.Valid && record.JobEndDate.After(record.SuspendedAt.Time) {
+			suspended += record.JobEndDate.Sub(record.SuspendedAt.Time)
+		}
+		record.finish(record.JobEndDate, suspended)
+
+		log.Infof("finishing the usage record for external-id %s", record.ExternalID)
+		if _, err = i.db.ExecContext(
+			ctx,
+			finishUsageRecordSQL,
+			record.AnalysisID,
+			record.EndDate,
+			record.CPUCoreHours,
+			record.MemoryGiBHours,
+			record.GPUHours,
+			record.SuspendedHours,
+		); err != nil {
+			log.Error(errors.Wrapf(err, "error finishing the usage record for external-id %s", record.ExternalID))
+		}
+	}
+
+	return nil
+}
+
+// ReconcileUsage fires up a goroutine that periodically reconciles the usage
+// records with the cluster and the jobs table. The goroutine exits when the
+// context is cancelled.
+func (i *Internal) ReconcileUsage(ctx context.Context) {
+	go func() {
+		for {
+			log.Debug("reconciling VICE usage records")
+			if err := i.reconcileUsage(ctx); err != nil {
+				log.Error(err)
+			}
+			if !sleepContext(ctx, i.UsageReconcileInterval) {
+				return
+			}
+		}
+	}()
+}
+
+// summarizeUsage runs one of the usage summary queries with the filter and the
+// time range given by the start and end parameters.
+func (i *Internal) summarizeUsage(c echo.Context, query, filter string) ([]UsageSummary, error) {
+	start, err := parseTimeParam(c, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := parseTimeParam(c, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []UsageSummary{}
+	if err = i.db.SelectContext(c.Request().Context(), &summaries, query, filter, start, end); err != nil {
+		return nil, errors.Wrap(err, "error summarizing usage")
+	}
+
+	return summaries, nil
+}
+
+// AdminUserUsageHandler returns the compute used by each user's analyses. The
+// user parameter limits the summary to a single user, and the start and end
+// parameters to the analyses that ended in that time range.
+func (i *Internal) AdminUserUsageHandler(c echo.Context) error {
+	user := strings.TrimSuffix(c.QueryParam("user"), i.UserSuffix)
+
+	summaries, err := i.summarizeUsage(c, summarizeUsageByUserSQL, user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string][]UsageSummary{"users": summaries})
+}
+
+// AdminAppUsageHandler returns the compute used by the analyses of each app.
+// The app-id parameter limits the summary to a single app, and the start and
+// end parameters to the analyses that ended in that time range.
+func (i *Internal) AdminAppUsageHandler(c echo.Context) error {
+	summaries, err := i.summarizeUsage(c, summarizeUsageByAppSQL, c.QueryParam("app-id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string][]UsageSummary{"apps": summaries})
+}
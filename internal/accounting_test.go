@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func accountingDeployment(start time.Time) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "external-id",
+			Labels:            map[string]string{"external-id": "external-id"},
+			CreationTimestamp: metav1.NewTime(start),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					InitContainers: []apiv1.Container{
+						{
+							Name: "input-files",
+							Resources: apiv1.ResourceRequirements{
+								Requests: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("8")},
+							},
+						},
+					},
+					Containers: []apiv1.Container{
+						{
+							Name: "analysis",
+							Resources: apiv1.ResourceRequirements{
+								Requests: apiv1.ResourceList{
+									apiv1.ResourceCPU:    resource.MustParse("1500m"),
+									apiv1.ResourceMemory: resource.MustParse("4Gi"),
+								},
+								Limits: apiv1.ResourceList{
+									apiv1.ResourceCPU:    resource.MustParse("4"),
+									apiv1.ResourceMemory: resource.MustParse("8Gi"),
+									gpuResourceName:      resource.MustParse("1"),
+								},
+							},
+						},
+						{
+							Name: "vice-proxy",
+							Resources: apiv1.ResourceRequirements{
+								Limits: apiv1.ResourceList{
+									apiv1.ResourceCPU:    resource.MustParse("500m"),
+									apiv1.ResourceMemory: resource.MustParse("1Gi"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestRequestedResources(t *testing.T) {
+	deployment := accountingDeployment(time.Now())
+	totals := requestedResources(&deployment.Spec.Template.Spec)
+
+	assert.Equal(t, resourceTotals{CPUCores: 2, MemoryBytes: 5 * bytesPerGiB, GPUs: 1}, totals)
+}
+
+func TestRecordUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Minute)
+	deployment := accountingDeployment(start)
+
+	mock.ExpectQuery("SELECT j.id, j.user_id, u.username, j.app_id, j.app_name").
+		WithArgs("external-id").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "username", "app_id", "app_name"}).
+				AddRow("analysis-id", "user-id", "test@example.org", "app-id", "JupyterLab"),
+		)
+	mock.ExpectExec("INSERT INTO vice_usage_records").
+		WithArgs(
+			"analysis-id",
+			"external-id",
+			"user-id",
+			"test",
+			"app-id",
+			"JupyterLab",
+			start,
+			end,
+			2.0,
+			int64(5*bytesPerGiB),
+			int64(1),
+			3.0,
+			7.5,
+			1.5,
+			0.0,
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(internal.recordUsage(context.Background(), deployment, end))
+	assert.NoError(mock.ExpectationsWereMet())
+
+	// The deletion time is used if it's set.
+	deleted := metav1.NewTime(start.Add(time.Hour))
+	deployment.DeletionTimestamp = &deleted
+	record := newUsageRecord(deployment, deleted.Time)
+	assert.Equal(2.0, record.CPUCoreHours)
+	assert.Equal(5.0, record.MemoryGiBHours)
+	assert.Equal(1.0, record.GPUHours)
+
+	// The time spent suspended is left out, including the current suspension.
+	deployment.Annotations = map[string]string{
+		suspendedSecondsAnnotation: "900",
+		suspendedAtAnnotation:      start.Add(45 * time.Minute).Format(time.RFC3339),
+	}
+	record = newUsageRecord(deployment, deleted.Time)
+	assert.Equal(0.5, record.SuspendedHours)
+	assert.Equal(1.0, record.CPUCoreHours)
+	assert.Equal(0.5, record.GPUHours)
+}
+
+func TestReconcileUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	deployment := accountingDeployment(start)
+	deployment.Namespace = "vice-apps"
+	deployment.Labels["app-type"] = "interactive"
+
+	internal, mock := setupInternal(t, []runtime.Object{deployment})
+	defer internal.db.Close()
+
+	// The record of the running analysis is opened.
+	mock.ExpectQuery("SELECT j.id, j.user_id, u.username, j.app_id, j.app_name").
+		WithArgs("external-id").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "username", "app_id", "app_name"}).
+				AddRow("analysis-id", "user-id", "test@example.org", "app-id", "JupyterLab"),
+		)
+	mock.ExpectExec("INSERT INTO vice_usage_records").
+		WithArgs(
+			"analysis-id",
+			"external-id",
+			"user-id",
+			"test",
+			"app-id",
+			"JupyterLab",
+			start,
+			2.0,
+			int64(5*bytesPerGiB),
+			int64(1),
+			0.0,
+			sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// The record of an analysis whose deployment is gone is finished with
+	// the end date from the jobs table, less the half hour it was suspended.
+	mock.ExpectQuery("SELECT r.analysis_id").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"analysis_id", "external_id", "user_id", "username", "app_id", "app_name",
+				"start_date", "cpu_cores", "memory_bytes", "gpus",
+				"suspended_seconds", "suspended_at", "job_end_date",
+			}).AddRow(
+				"gone-id", "gone-external-id", "user-id", "test", "app-id", "JupyterLab",
+				start, 2.0, int64(bytesPerGiB), int64(0),
+				0.0, start.Add(90*time.Minute), start.Add(2*time.Hour),
+			),
+		)
+	mock.ExpectExec("UPDATE vice_usage_records").
+		WithArgs("gone-id", start.Add(2*time.Hour), 3.0, 1.5, 0.0, 0.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(internal.reconcileUsage(context.Background()))
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAdminUserUsageHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	mock.ExpectQuery("SELECT username").
+		WithArgs("test", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"username", "analyses", "cpu_core_hours", "memory_gib_hours", "gpu_hours"}).
+				AddRow("test", 2, 3.0, 7.5, 1.5),
+		)
+
+	e := echo.New()
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/vice/admin/usage/users?user=test@example.org&start=2021-06-01T00:00:00Z&end=2021-07-01T00:00:00Z",
+		nil,
+	)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if !assert.NoError(internal.AdminUserUsageHandler(c)) {
+		return
+	}
+	assert.Equal(http.StatusOK, rec.Code)
+
+	resp := map[string][]UsageSummary{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal([]UsageSummary{
+		{Username: "test", Analyses: 2, CPUCoreHours: 3, MemoryGiBHours: 7.5, GPUHours: 1.5},
+	}, resp["users"])
+	assert.NoError(mock.ExpectationsWereMet())
+
+	req = httptest.NewRequest(http.MethodGet, "/vice/admin/usage/apps?start=yesterday", nil)
+	err := internal.AdminAppUsageHandler(e.NewContext(req, httptest.NewRecorder()))
+	if assert.Error(err) {
+		assert.Equal(http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	}
+}
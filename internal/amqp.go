@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/messaging"
+	"github.com/pkg/errors"
+	modelv4 "gopkg.in/cyverse-de/model.v4"
+)
+
+// The transports that can be used to deliver status updates.
+const (
+	HTTPStatusTransport = "http"
+	AMQPStatusTransport = "amqp"
+)
+
+// jobUpdatePublisher is the part of *messaging.Client used to send status
+// updates.
+type jobUpdatePublisher interface {
+	PublishJobUpdate(u *messaging.UpdateMessage) error
+	Close()
+}
+
+// AMQPPublisher is an implementation of StatusDeliverer that publishes job
+// updates to an AMQP exchange with the jobs.updates routing key.
+type AMQPPublisher struct {
+	uri      string
+	exchange string
+
+	// connect opens a new connection to the broker. Replaced in tests.
+	connect func(uri, exchange string) (jobUpdatePublisher, error)
+
+	client jobUpdatePublisher
+	lock   sync.Mutex
+}
+
+// NewAMQPPublisher returns an *AMQPPublisher that publishes to the exchange on
+// the broker at the given URI. The connection is opened when the first update
+// is sent and reopened if publishing fails.
+func NewAMQPPublisher(uri, exchange string) *AMQPPublisher {
+	return &AMQPPublisher{
+		uri:      uri,
+		exchange: exchange,
+		connect:  connectAMQP,
+	}
+}
+
+func connectAMQP(uri, exchange string) (jobUpdatePublisher, error) {
+	client, err := messaging.NewClient(uri, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.SetupPublishing(exchange); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Deliver publishes the status update to the exchange.
+func (a *AMQPPublisher) Deliver(jobID string, status *AnalysisStatus) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.client == nil {
+		client, err := a.connect(a.uri, a.exchange)
+		if err != nil {
+			return errors.Wrapf(err, "error connecting to the AMQP broker to publish %s status for job %s", status.State, jobID)
+		}
+		a.client = client
+	}
+
+	update := &messaging.UpdateMessage{
+		Job:     &modelv4.Job{InvocationID: jobID},
+		State:   status.State,
+		Message: status.Message,
+		Sender:  status.Host,
+		SentOn:  strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+	}
+
+	if err := a.client.PublishJobUpdate(update); err != nil {
+		// The channel may be unusable after an error, so start over with a
+		// new connection next time.
+		a.client.Close()
+		a.client = nil
+		return errors.Wrapf(err, "error publishing %s status for job %s", status.State, jobID)
+	}
+
+	return nil
+}
+
+// newStatusDeliverer returns the StatusDeliverer for the configured transport.
+func newStatusDeliverer(init *Init) StatusDeliverer {
+	if init.StatusTransport == AMQPStatusTransport {
+		return NewAMQPPublisher(init.AMQPURI, init.AMQPExchange)
+	}
+	return NewJSLPublisher(init.JobStatusURL, init.StatusRequestTimeout)
+}
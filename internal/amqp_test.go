@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testAMQPClient records the job updates published to it, failing if err is
+// set.
+type testAMQPClient struct {
+	updates []*messaging.UpdateMessage
+	err     error
+	closed  bool
+}
+
+func (c *testAMQPClient) PublishJobUpdate(u *messaging.UpdateMessage) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.updates = append(c.updates, u)
+	return nil
+}
+
+func (c *testAMQPClient) Close() {
+	c.closed = true
+}
+
+func TestAMQPPublisher(t *testing.T) {
+	assert := assert.New(t)
+
+	connections := []*testAMQPClient{
+		{err: errors.New("channel closed")},
+		{},
+	}
+	connected := 0
+
+	publisher := NewAMQPPublisher("amqp://localhost", "de")
+	publisher.connect = func(uri, exchange string) (jobUpdatePublisher, error) {
+		client := connections[connected]
+		connected++
+		return client, nil
+	}
+
+	status := &AnalysisStatus{Host: "host", State: messaging.RunningState, Message: "still going"}
+
+	assert.Error(publisher.Deliver("external-id", status), "the publishing error should be returned")
+	assert.True(connections[0].closed, "the broken connection should be closed")
+
+	assert.NoError(publisher.Deliver("external-id", status), "a new connection should be opened")
+	assert.Equal(2, connected)
+
+	if assert.Len(connections[1].updates, 1) {
+		update := connections[1].updates[0]
+		assert.Equal("external-id", update.Job.InvocationID)
+		assert.Equal(messaging.RunningState, update.State)
+		assert.Equal("still going", update.Message)
+		assert.Equal("host", update.Sender)
+		assert.NotEmpty(update.SentOn)
+	}
+}
+
+func TestNewStatusDeliverer(t *testing.T) {
+	_, ok := newStatusDeliverer(&Init{StatusTransport: AMQPStatusTransport}).(*AMQPPublisher)
+	assert.True(t, ok, "the AMQP transport should use the AMQP publisher")
+
+	_, ok = newStatusDeliverer(&Init{StatusTransport: HTTPStatusTransport}).(*JSLPublisher)
+	assert.True(t, ok, "the HTTP transport should use job-status-listener")
+}
+
+// TestAMQPPublisherIntegration publishes an update to a real broker, such as a
+// local RabbitMQ container. Set RUN_INTEGRATION_TESTS and AMQP_URI to run it.
+func TestAMQPPublisherIntegration(t *testing.T) {
+	uri := os.Getenv("AMQP_URI")
+	if os.Getenv("RUN_INTEGRATION_TESTS") == "" || uri == "" {
+		t.Skip("set RUN_INTEGRATION_TESTS and AMQP_URI to run AMQP integration tests")
+	}
+
+	assert := assert.New(t)
+	exchange := "app-exposer-test"
+
+	client, err := messaging.NewClient(uri, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err = client.SetupPublishing(exchange); err != nil {
+		t.Fatal(err)
+	}
+
+	channel, err := client.CreateQueue("app-exposer-test-updates", exchange, messaging.UpdatesKey, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := channel.Consume("app-exposer-test-updates", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := NewAMQPPublisher(uri, exchange)
+	status := &AnalysisStatus{Host: "host", State: messaging.RunningState, Message: "still going"}
+	assert.NoError(publisher.Deliver("external-id", status))
+
+	select {
+	case delivery := <-deliveries:
+		update := &messaging.UpdateMessage{}
+		assert.NoError(json.Unmarshal(delivery.Body, update))
+		assert.Equal("external-id", update.Job.InvocationID)
+		assert.Equal(messaging.RunningState, update.State)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the update was never received")
+	}
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -17,7 +18,7 @@ func (i *Internal) AsyncDataHandler(c echo.Context) error {
 
 	apps := apps.NewApps(i.db, i.UserSuffix)
 
-	analysisID, err := apps.GetAnalysisIDByExternalID(externalID)
+	analysisID, err := apps.GetAnalysisIDByExternalID(c.Request().Context(), externalID)
 	if err != nil {
 		log.Error(err)
 		return err
@@ -40,7 +41,7 @@ func (i *Internal) AsyncDataHandler(c echo.Context) error {
 	userID := labels["user-id"]
 
 	subdomain := IngressName(userID, externalID)
-	ipAddr, err := apps.GetUserIP(userID)
+	ipAddr, err := apps.GetUserIP(c.Request().Context(), userID)
 	if err != nil {
 		log.Error(err)
 		return err
@@ -56,9 +57,9 @@ func (i *Internal) AsyncDataHandler(c echo.Context) error {
 // getExternalID returns the externalID associated with the analysisID. For now,
 // only returns the first result, since VICE analyses only have a single step in
 // the database.
-func (i *Internal) getExternalIDByAnalysisID(analysisID string) (string, error) {
+func (i *Internal) getExternalIDByAnalysisID(ctx context.Context, analysisID string) (string, error) {
 	apps := apps.NewApps(i.db, i.UserSuffix)
-	username, _, err := apps.GetUserByAnalysisID(analysisID)
+	username, _, err := apps.GetUserByAnalysisID(ctx, analysisID)
 	if err != nil {
 		return "", err
 	}
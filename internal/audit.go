@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// maxAuditPayload is the number of bytes of a request body kept in the audit
+// log.
+const maxAuditPayload = 2048
+
+// anonymousActor is recorded for requests that are turned away because they
+// weren't authenticated.
+const anonymousActor = "anonymous"
+
+// redactedValue replaces the values of credential-bearing fields in the
+// payloads kept in the audit log.
+const redactedValue = "REDACTED"
+
+// sensitiveFields are the parts of field names that mark a field as holding a
+// credential. Names are compared in lower case with dashes and underscores
+// removed.
+var sensitiveFields = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"credential",
+	"apikey",
+	"authorization",
+	"privatekey",
+}
+
+// AuditEntry is a mutating request recorded in the audit log.
+type AuditEntry struct {
+	ID          int64     `json:"id" db:"id"`
+	Actor       string    `json:"actor" db:"actor"`
+	Action      string    `json:"action" db:"action"`
+	TargetType  string    `json:"target_type" db:"target_type"`
+	TargetID    string    `json:"target_id" db:"target_id"`
+	Payload     string    `json:"payload" db:"payload"`
+	StatusCode  int       `json:"status_code" db:"status_code"`
+	Error       string    `json:"error,omitempty" db:"error"`
+	CreatedDate time.Time `json:"created_date" db:"created_date"`
+}
+
+const insertAuditEntrySQL = `
+	INSERT INTO vice_admin_audit (actor, action, target_type, target_id, payload, status_code, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+// Blank filters match everything.
+const listAuditEntriesSQL = `
+	SELECT id, actor, action, target_type, target_id, payload, status_code,
+	       coalesce(error, '') AS error, created_date
+	  FROM vice_admin_audit
+	 WHERE ($1 = '' OR actor = $1)
+	   AND ($2 = '' OR target_id = $2)
+	   AND ($3::timestamptz IS NULL OR created_date >= $3)
+	   AND ($4::timestamptz IS NULL OR created_date < $4)
+	 ORDER BY created_date DESC, id DESC
+	 LIMIT $5
+`
+
+// auditActor returns the authenticated user that made the request, or
+// anonymousActor if the request wasn't authenticated. Trusted services acting
+// on behalf of a user are recorded along with that user.
+func auditActor(c echo.Context) string {
+	user := auth.CurrentUser(c)
+	if user == nil {
+		return anonymousActor
+	}
+	if param := c.QueryParam("user"); user.Service && param != "" {
+		return fmt.Sprintf("%s (as %s)", user.Username, param)
+	}
+	return user.Username
+}
+
+// auditTarget returns the type and ID of the object changed by the request.
+func auditTarget(c echo.Context) (string, string) {
+	path := c.Path()
+
+	switch {
+	case c.Param("analysis-id") != "":
+		return "analysis", c.Param("analysis-id")
+	case strings.Contains(path, "/mappings/defaults"):
+		if version := c.Param("version"); version != "" {
+			return "instant-launch-defaults", version
+		}
+		return "instant-launch-defaults", "latest"
+	case c.Param("username") != "":
+		return "instant-launch-mapping", c.Param("username")
+	case strings.HasPrefix(path, "/instantlaunches"):
+		return "instant-launch", c.Param("id")
+	case strings.Contains(path, "/outbox"):
+		return "status-update", c.Param("id")
+	}
+
+	return "", ""
+}
+
+// auditStatus returns the status code of the response to a request, given the
+// error returned by its handler. The error handler hasn't run yet at this
+// point, so the status is worked out the same way it does.
+func auditStatus(c echo.Context, err error) int {
+	switch e := err.(type) {
+	case nil:
+		return c.Response().Status
+	case common.ErrorResponse, *common.ErrorResponse:
+		return http.StatusBadRequest
+	case *echo.HTTPError:
+		return e.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// isSensitiveField returns true if the field name looks like it holds a
+// credential.
+func isSensitiveField(name string) bool {
+	normalized := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	for _, field := range sensitiveFields {
+		if strings.Contains(normalized, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactJSON replaces the values of the sensitive fields in the decoded JSON
+// value, at any depth.
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitiveField(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for idx := range v {
+			v[idx] = redactJSON(v[idx])
+		}
+	}
+	return value
+}
+
+// redactQuery replaces the values of the sensitive parameters in the query
+// string.
+func redactQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "[unparseable query string omitted]"
+	}
+	for key := range values {
+		if isSensitiveField(key) {
+			values[key] = []string{redactedValue}
+		}
+	}
+	return values.Encode()
+}
+
+// redactBody returns the body with the values of its sensitive fields
+// replaced. Bodies that aren't JSON are left out entirely, since there's no
+// way to tell which parts of them are credentials.
+func redactBody(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("[%d byte body omitted]", len(body))
+	}
+
+	redacted, err := json.Marshal(redactJSON(value))
+	if err != nil {
+		return fmt.Sprintf("[%d byte body omitted]", len(body))
+	}
+	return string(redacted)
+}
+
+// auditPayload reads the request body and puts it back so that the handler can
+// still read all of it. The query string is included, since many endpoints
+// take their arguments there. Credentials are redacted from both, and at most
+// maxAuditPayload bytes of the body are kept.
+func auditPayload(c echo.Context) string {
+	req := c.Request()
+
+	var summary []string
+	if req.URL.RawQuery != "" {
+		summary = append(summary, redactQuery(req.URL.RawQuery))
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Error(errors.Wrap(err, "error reading the request body for the audit log"))
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if len(body) > 0 {
+			redacted := redactBody(body)
+			if len(redacted) > maxAuditPayload {
+				redacted = redacted[:maxAuditPayload] + "..."
+			}
+			summary = append(summary, redacted)
+		}
+	}
+
+	return strings.Join(summary, " ")
+}
+
+// AuditMiddleware records every mutating request that goes through it in the
+// audit log, along with its outcome. It doesn't decide who can make the
+// request; requests without an authenticated user are recorded as made by
+// anonymousActor. Failing to record a request doesn't fail the request.
+func (i *Internal) AuditMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+
+		payload := auditPayload(c)
+		err := next(c)
+
+		targetType, targetID := auditTarget(c)
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+		}
+
+		i.recordAudit(&AuditEntry{
+			Actor:      auditActor(c),
+			Action:     c.Request().Method + " " + c.Path(),
+			TargetType: targetType,
+			TargetID:   targetID,
+			Payload:    payload,
+			StatusCode: auditStatus(c, err),
+			Error:      errMsg,
+		})
+
+		return err
+	}
+}
+
+// recordAudit adds the entry to the audit log. The ID and creation date of the
+// entry are set by the database. Failures are logged rather than returned.
+func (i *Internal) recordAudit(entry *AuditEntry) {
+	if _, err := i.db.Exec(
+		insertAuditEntrySQL,
+		entry.Actor,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		entry.Payload,
+		entry.StatusCode,
+		entry.Error,
+	); err != nil {
+		log.Error(errors.Wrap(err, "error recording request in the audit log"))
+	}
+}
+
+// parseTimeParam parses an optional RFC 3339 time from the query string.
+func parseTimeParam(c echo.Context, name string) (pq.NullTime, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return pq.NullTime{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return pq.NullTime{}, echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+	}
+
+	return pq.NullTime{Time: t, Valid: true}, nil
+}
+
+// AdminAuditHandler lists the entries in the audit log, newest first. They
+// can be filtered by actor, target, and a time range given by the since and
+// until parameters.
+func (i *Internal) AdminAuditHandler(c echo.Context) error {
+	since, err := parseTimeParam(c, "since")
+	if err != nil {
+		return err
+	}
+
+	until, err := parseTimeParam(c, "until")
+	if err != nil {
+		return err
+	}
+
+	limit := 100
+	if value := c.QueryParam("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+
+	entries := []AuditEntry{}
+	if err = i.db.Select(
+		&entries,
+		listAuditEntriesSQL,
+		c.QueryParam("actor"),
+		c.QueryParam("target"),
+		since,
+		until,
+		limit,
+	); err != nil {
+		return errors.Wrap(err, "error listing audit log entries")
+	}
+
+	return c.JSON(http.StatusOK, map[string][]AuditEntry{"entries": entries})
+}
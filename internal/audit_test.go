@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// asUser returns middleware that authenticates every request as the user.
+func asUser(username string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth.SetCurrentUser(c, &auth.User{Username: username})
+			return next(c)
+		}
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	mock.ExpectExec("INSERT INTO vice_admin_audit").
+		WithArgs(
+			"admin",
+			"POST /vice/admin/analyses/:analysis-id/time-limit",
+			"analysis",
+			"analysis-id",
+			`token=REDACTED&user=admin {"auth":{"password":"REDACTED"},"duration":"24h"}`,
+			http.StatusForbidden,
+			sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	var body string
+	e := echo.New()
+	admin := e.Group("/vice/admin", asUser("admin"), internal.AuditMiddleware)
+	admin.POST("/analyses/:analysis-id/time-limit", func(c echo.Context) error {
+		b, _ := ioutil.ReadAll(c.Request().Body)
+		body = string(b)
+		return echo.NewHTTPError(http.StatusForbidden, "no more extensions")
+	})
+	admin.GET("/analyses/:analysis-id/time-limit", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/vice/admin/analyses/analysis-id/time-limit?user=admin&token=abc",
+		strings.NewReader(`{"duration":"24h","auth":{"password":"hunter2"}}`),
+	)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(`{"duration":"24h","auth":{"password":"hunter2"}}`, body, "the handler should still be able to read the body")
+
+	req = httptest.NewRequest(http.MethodGet, "/vice/admin/analyses/analysis-id/time-limit", nil)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NoError(mock.ExpectationsWereMet(), "only the POST should be recorded")
+}
+
+func TestAuditMiddlewareUnauthenticated(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	mock.ExpectExec("INSERT INTO vice_admin_audit").
+		WithArgs(
+			anonymousActor,
+			"POST /vice/admin/analyses/:analysis-id/exit",
+			"analysis",
+			"analysis-id",
+			"user=admin",
+			http.StatusOK,
+			sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	called := false
+	e := echo.New()
+	admin := e.Group("/vice/admin", internal.AuditMiddleware)
+	admin.POST("/analyses/:analysis-id/exit", func(c echo.Context) error {
+		called = true
+		return c.NoContent(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/vice/admin/analyses/analysis-id/exit?user=admin", nil))
+
+	assert.True(called, "auditing should not turn away anonymous requests")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAuditActor(t *testing.T) {
+	tests := []struct {
+		user     *auth.User
+		query    string
+		expected string
+	}{
+		{nil, "?user=ipcdev", anonymousActor},
+		{&auth.User{Username: "admin"}, "?user=ipcdev", "admin"},
+		{&auth.User{Username: "service-account-apps", Service: true}, "?user=ipcdev", "service-account-apps (as ipcdev)"},
+		{&auth.User{Username: "service-account-apps", Service: true}, "", "service-account-apps"},
+	}
+
+	for _, test := range tests {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/"+test.query, nil), httptest.NewRecorder())
+		if test.user != nil {
+			auth.SetCurrentUser(c, test.user)
+		}
+		assert.Equal(t, test.expected, auditActor(c))
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"name":"il"}`, `{"name":"il"}`},
+		{`{"api_key":"k","avus":[{"Access-Token":"t","value":"v"}]}`, `{"api_key":"REDACTED","avus":[{"Access-Token":"REDACTED","value":"v"}]}`},
+		{`password=hunter2`, `[16 byte body omitted]`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, redactBody([]byte(test.body)), test.body)
+	}
+}
+
+func TestAuditTarget(t *testing.T) {
+	tests := []struct {
+		path       string
+		names      []string
+		values     []string
+		targetType string
+		targetID   string
+	}{
+		{"/vice/admin/analyses/:analysis-id/exit", []string{"analysis-id"}, []string{"a1"}, "analysis", "a1"},
+		{"/instantlaunches/mappings/defaults/latest", nil, nil, "instant-launch-defaults", "latest"},
+		{"/instantlaunches/mappings/defaults/:version", []string{"version"}, []string{"2"}, "instant-launch-defaults", "2"},
+		{"/instantlaunches/mappings/:username/latest", []string{"username"}, []string{"ipcdev"}, "instant-launch-mapping", "ipcdev"},
+		{"/instantlaunches/:id", []string{"id"}, []string{"il1"}, "instant-launch", "il1"},
+		{"/vice/admin/outbox/:id/replay", []string{"id"}, []string{"42"}, "status-update", "42"},
+	}
+
+	e := echo.New()
+	for _, test := range tests {
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+		c.SetPath(test.path)
+		c.SetParamNames(test.names...)
+		c.SetParamValues(test.values...)
+
+		targetType, targetID := auditTarget(c)
+		assert.Equal(t, test.targetType, targetType, test.path)
+		assert.Equal(t, test.targetID, targetID, test.path)
+	}
+}
+
+func TestAdminAuditHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	columns := []string{"id", "actor", "action", "target_type", "target_id", "payload", "status_code", "error", "created_date"}
+	mock.ExpectQuery("SELECT id, actor, action").
+		WithArgs("admin", "", sqlmock.AnyArg(), sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "admin", "POST /vice/admin/analyses/:analysis-id/exit", "analysis", "a1", "", 200, "", time.Now()))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/?actor=admin&since=2021-01-01T00:00:00Z&limit=10", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(internal.AdminAuditHandler(e.NewContext(req, rec)))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"target_id":"a1"`)
+	assert.NoError(mock.ExpectationsWereMet())
+
+	req = httptest.NewRequest(http.MethodGet, "/?since=yesterday", nil)
+	err := internal.AdminAuditHandler(e.NewContext(req, httptest.NewRecorder()))
+	if httpErr, ok := err.(*echo.HTTPError); assert.True(ok, "an HTTP error should be returned") {
+		assert.Equal(http.StatusBadRequest, httpErr.Code)
+	}
+}
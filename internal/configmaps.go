@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 
 	"github.com/cyverse-de/model"
@@ -31,8 +32,8 @@ func excludesFileContents(job *model.Job) *bytes.Buffer {
 // that should be excluded from file uploads to iRODS by porklock. This does NOT
 // call the k8s API to actually create the ConfigMap, just returns the object
 // that can be passed to the API.
-func (i *Internal) excludesConfigMap(job *model.Job) (*apiv1.ConfigMap, error) {
-	labels, err := i.labelsFromJob(job)
+func (i *Internal) excludesConfigMap(ctx context.Context, job *model.Job) (*apiv1.ConfigMap, error) {
+	labels, err := i.labelsFromJob(ctx, job)
 	if err != nil {
 		return nil, err
 	}
@@ -83,8 +84,8 @@ func inputPathListContents(job *model.Job, pathListIdentifier, ticketsPathListId
 // list of paths that should be downloaded from iRODS by porklock as input
 // files for the VICE analysis. This does NOT call the k8s API to actually
 // create the ConfigMap, just returns the object that can be passed to the API.
-func (i *Internal) inputPathListConfigMap(job *model.Job) (*apiv1.ConfigMap, error) {
-	labels, err := i.labelsFromJob(job)
+func (i *Internal) inputPathListConfigMap(ctx context.Context, job *model.Job) (*apiv1.ConfigMap, error) {
+	labels, err := i.labelsFromJob(ctx, job)
 	if err != nil {
 		return nil, err
 	}
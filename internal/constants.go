@@ -45,6 +45,13 @@ const (
 	downloadKind     = "download"
 	uploadKind       = "upload"
 
+	userExitReason       = "user"
+	adminExitReason      = "admin"
+	idleExitReason       = "idle"
+	timeLimitExitReason  = "time-limit"
+	podFailureExitReason = "pod-failure"
+	orphanExitReason     = "orphan"
+
 	viceTolerationKey      = "vice"
 	viceTolerationOperator = "Equal"
 	viceTolerationValue    = "only"
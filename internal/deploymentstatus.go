@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// deploymentState is the part of a deployment's status that's worth telling
+// users about.
+type deploymentState struct {
+	Replicas            int32
+	ReadyReplicas       int32
+	AvailableReplicas   int32
+	UnavailableReplicas int32
+}
+
+func deploymentStateOf(deployment *appsv1.Deployment) deploymentState {
+	return deploymentState{
+		Replicas:            deployment.Status.Replicas,
+		ReadyReplicas:       deployment.Status.ReadyReplicas,
+		AvailableReplicas:   deployment.Status.AvailableReplicas,
+		UnavailableReplicas: deployment.Status.UnavailableReplicas,
+	}
+}
+
+// summary returns the message sent to users when the state changes.
+func (s deploymentState) summary(deploymentName, analysisName string) string {
+	return fmt.Sprintf(
+		"deployment %s for analysis %s summary: \n replicas: %d ready replicas: %d \n available replicas: %d \n unavailable replicas: %d",
+		deploymentName,
+		analysisName,
+		s.Replicas,
+		s.ReadyReplicas,
+		s.AvailableReplicas,
+		s.UnavailableReplicas,
+	)
+}
+
+// trackedDeployment records the state last published for a deployment, along
+// with any newer state that's waiting for the minimum interval to pass.
+type trackedDeployment struct {
+	name         string
+	analysisName string
+	published    *deploymentState
+	pending      *deploymentState
+	lastSent     time.Time
+	changes      int
+	timer        *time.Timer
+
+	// launching is true until the launch-to-ready latency has been recorded
+	// for a deployment that was first seen while the analysis was starting up.
+	launching bool
+}
+
+// newReplicaSetAvailableReason is the reason given in the Progressing
+// condition of a deployment once its pods have become available for the first
+// time. Later crashes don't change it.
+const newReplicaSetAvailableReason = "NewReplicaSetAvailable"
+
+// isLaunching returns true if the deployment's pods have never been ready,
+// which means the analysis was just launched. Deployments that were scaled
+// down and back up to suspend and resume the analysis have a newer generation,
+// and deployments that were ready before their pods crashed say so in their
+// Progressing condition.
+func isLaunching(deployment *appsv1.Deployment) bool {
+	if deployment.Generation > 1 || deployment.Status.ReadyReplicas > 0 {
+		return false
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == newReplicaSetAvailableReason {
+			return false
+		}
+	}
+
+	return true
+}
+
+// publishDeploymentState sends the state to the user. Must be called with
+// deploymentStatesLock held, which keeps the updates for an analysis in order.
+func (i *Internal) publishDeploymentState(jobID string, t *trackedDeployment, state deploymentState, now time.Time) error {
+	t.published = &state
+	t.pending = nil
+	t.lastSent = now
+	t.changes++
+
+	return i.statusPublisher.Running(jobID, state.summary(t.name, t.analysisName))
+}
+
+// recordDeploymentState publishes the state of the deployment if it has
+// changed since the last update that was sent. Updates that arrive less than
+// StatusUpdateInterval after the last one are held back, and only the latest
+// of them is sent once the interval has passed.
+func (i *Internal) recordDeploymentState(jobID string, deployment *appsv1.Deployment, now time.Time) error {
+	state := deploymentStateOf(deployment)
+
+	i.deploymentStatesLock.Lock()
+	defer i.deploymentStatesLock.Unlock()
+
+	t, ok := i.deploymentStates[jobID]
+	if !ok {
+		t = &trackedDeployment{launching: isLaunching(deployment)}
+		i.deploymentStates[jobID] = t
+	}
+	t.name = deployment.Name
+	t.analysisName = deployment.Labels["analysis-name"]
+
+	if t.launching && state.ReadyReplicas > 0 {
+		t.launching = false
+		metrics.LaunchReadySeconds.Observe(now.Sub(deployment.CreationTimestamp.Time).Seconds())
+	}
+
+	if t.published != nil && *t.published == state {
+		t.pending = nil
+		return nil
+	}
+
+	if wait := i.StatusUpdateInterval - now.Sub(t.lastSent); wait > 0 {
+		t.pending = &state
+		if t.timer == nil {
+			t.timer = time.AfterFunc(wait, func() {
+				if err := i.flushDeploymentState(jobID, time.Now()); err != nil {
+					log.Error(err)
+				}
+			})
+		}
+		return nil
+	}
+
+	return i.publishDeploymentState(jobID, t, state, now)
+}
+
+// flushDeploymentState publishes the state that was held back for the
+// analysis, if there is one.
+func (i *Internal) flushDeploymentState(jobID string, now time.Time) error {
+	i.deploymentStatesLock.Lock()
+	defer i.deploymentStatesLock.Unlock()
+
+	t, ok := i.deploymentStates[jobID]
+	if !ok {
+		return nil
+	}
+
+	t.timer = nil
+	if t.pending == nil {
+		return nil
+	}
+
+	return i.publishDeploymentState(jobID, t, *t.pending, now)
+}
+
+// finishDeploymentTracking stops tracking the deployment for the analysis and
+// returns a summary of its final state to include in the message sent when
+// it's deleted. Returns an empty string if the deployment wasn't tracked.
+func (i *Internal) finishDeploymentTracking(jobID string) string {
+	i.deploymentStatesLock.Lock()
+	defer i.deploymentStatesLock.Unlock()
+
+	t, ok := i.deploymentStates[jobID]
+	if !ok {
+		return ""
+	}
+
+	delete(i.deploymentStates, jobID)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+
+	final := t.pending
+	if final == nil {
+		final = t.published
+	}
+	if final == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"%d status changes were reported; the last state was %d of %d replicas ready",
+		t.changes,
+		final.ReadyReplicas,
+		final.Replicas,
+	)
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func statusDeployment(replicas, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "foo",
+			Labels: map[string]string{"analysis-name": "analysis"},
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:            replicas,
+			ReadyReplicas:       ready,
+			AvailableReplicas:   ready,
+			UnavailableReplicas: replicas - ready,
+		},
+	}
+}
+
+func TestRecordDeploymentState(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+	internal.StatusUpdateInterval = time.Minute
+
+	now := time.Now()
+
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(1, 0), now))
+	assert.Len(publisher.messages, 1, "the first state should be published")
+
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(1, 0), now.Add(2*time.Minute)))
+	assert.Len(publisher.messages, 1, "an unchanged state should not be published")
+
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(1, 1), now.Add(2*time.Minute)))
+	assert.Len(publisher.messages, 2, "a changed state should be published")
+	assert.Contains(publisher.messages[1], "ready replicas: 1")
+
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(2, 1), now.Add(2*time.Minute+time.Second)))
+	assert.Len(publisher.messages, 2, "changes within the minimum interval should be held back")
+
+	assert.NoError(internal.flushDeploymentState("external-id", now.Add(3*time.Minute)))
+	if assert.Len(publisher.messages, 3, "the held back state should be published") {
+		assert.Contains(publisher.messages[2], "replicas: 2")
+	}
+
+	summary := internal.finishDeploymentTracking("external-id")
+	assert.Equal("3 status changes were reported; the last state was 1 of 2 replicas ready", summary)
+	assert.Empty(internal.finishDeploymentTracking("external-id"), "the deployment should no longer be tracked")
+}
+
+func TestFinishDeploymentTrackingPending(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+	internal.StatusUpdateInterval = time.Minute
+
+	now := time.Now()
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(1, 0), now))
+	assert.NoError(internal.recordDeploymentState("external-id", statusDeployment(1, 1), now.Add(time.Second)))
+
+	summary := internal.finishDeploymentTracking("external-id")
+	assert.Equal("1 status changes were reported; the last state was 1 of 1 replicas ready", summary)
+	assert.Len(publisher.messages, 1, "the held back state should be dropped once the deployment is gone")
+}
+
+func TestIsLaunching(t *testing.T) {
+	launching := statusDeployment(1, 0)
+	launching.Generation = 1
+
+	resumed := statusDeployment(1, 0)
+	resumed.Generation = 3
+
+	crashed := statusDeployment(1, 0)
+	crashed.Generation = 1
+	crashed.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Reason: newReplicaSetAvailableReason},
+	}
+
+	tests := []struct {
+		description string
+		deployment  *appsv1.Deployment
+		expected    bool
+	}{
+		{"new analysis", launching, true},
+		{"ready analysis", statusDeployment(1, 1), false},
+		{"resumed analysis", resumed, false},
+		{"crashed analysis", crashed, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert.Equal(t, test.expected, isLaunching(test.deployment))
+		})
+	}
+}
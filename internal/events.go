@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// forwardedNormalEvents are the informational events that are worth passing
+// along to users. Every warning event is passed along.
+var forwardedNormalEvents = map[string]bool{
+	"Scheduled": true,
+	"Pulling":   true,
+	"Pulled":    true,
+	"Started":   true,
+}
+
+// externalIDRegexp matches the external ID that's part of the name of every
+// object created for a VICE analysis.
+var externalIDRegexp = regexp.MustCompile("[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}")
+
+// eventListers look up the objects that events are about in the informer
+// caches, so that forwarding events doesn't need a request to the API server
+// for each one.
+type eventListers struct {
+	pods        corelisters.PodLister
+	pvcs        corelisters.PersistentVolumeClaimLister
+	deployments appslisters.DeploymentLister
+}
+
+// newEventListers returns the listers for the VICE objects from the factory.
+func newEventListers(factory informers.SharedInformerFactory) *eventListers {
+	return &eventListers{
+		pods:        factory.Core().V1().Pods().Lister(),
+		pvcs:        factory.Core().V1().PersistentVolumeClaims().Lister(),
+		deployments: factory.Apps().V1().Deployments().Lister(),
+	}
+}
+
+// eventTime returns the last time the event was seen.
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
+
+// shouldForwardEvent returns true if the event is worth passing along to users.
+func shouldForwardEvent(event *corev1.Event) bool {
+	return event.Type == corev1.EventTypeWarning || forwardedNormalEvents[event.Reason]
+}
+
+// fieldPathContainer returns the name of the container in a field path such as
+// spec.containers{analysis}, or the field path itself if it doesn't name one.
+func fieldPathContainer(fieldPath string) string {
+	start := strings.Index(fieldPath, "{")
+	end := strings.LastIndex(fieldPath, "}")
+	if start < 0 || end <= start {
+		return fieldPath
+	}
+	return fieldPath[start+1 : end]
+}
+
+// eventMessage translates a Kubernetes event into a message for the user.
+func eventMessage(event *corev1.Event, analysisName string) string {
+	switch event.Reason {
+	case "FailedScheduling":
+		return fmt.Sprintf("analysis %s is waiting for resources to become available: %s", analysisName, event.Message)
+	case "FailedMount", "FailedAttachVolume":
+		return fmt.Sprintf("the data for analysis %s could not be mounted: %s", analysisName, event.Message)
+	case "Evicted":
+		return fmt.Sprintf("analysis %s was evicted from the node it was running on: %s", analysisName, event.Message)
+	case "Preempting", "Preempted":
+		return fmt.Sprintf("analysis %s was preempted by a higher priority workload: %s", analysisName, event.Message)
+	case "Scheduled":
+		return fmt.Sprintf("analysis %s has been scheduled to run", analysisName)
+	case "Pulling":
+		return fmt.Sprintf("downloading the container image for analysis %s", analysisName)
+	case "Pulled":
+		return fmt.Sprintf("finished downloading the container image for analysis %s", analysisName)
+	case "Started":
+		return fmt.Sprintf("container %s has started for analysis %s", fieldPathContainer(event.InvolvedObject.FieldPath), analysisName)
+	default:
+		return fmt.Sprintf("analysis %s: %s: %s", analysisName, event.Reason, event.Message)
+	}
+}
+
+// involvedObjectLabels returns the labels for the object the event is about,
+// or nil if it isn't a kind of object created for VICE analyses. Objects are
+// often gone by the time the events explaining what happened to them arrive,
+// such as pods that were evicted, so the labels of the analysis's deployment
+// are used for objects that can't be found. The deployment is named after the
+// external ID, which is part of the name of every object for the analysis.
+func involvedObjectLabels(listers *eventListers, event *corev1.Event) (map[string]string, error) {
+	var (
+		obj metav1.Object
+		err error
+	)
+
+	ref := event.InvolvedObject
+
+	switch ref.Kind {
+	case "Pod":
+		obj, err = listers.pods.Pods(ref.Namespace).Get(ref.Name)
+	case "PersistentVolumeClaim":
+		obj, err = listers.pvcs.PersistentVolumeClaims(ref.Namespace).Get(ref.Name)
+	case "Deployment":
+		obj, err = listers.deployments.Deployments(ref.Namespace).Get(ref.Name)
+	default:
+		return nil, nil
+	}
+
+	if err == nil {
+		return obj.GetLabels(), nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "error looking up %s %s for event %s", ref.Kind, ref.Name, event.Name)
+	}
+
+	externalID := externalIDRegexp.FindString(ref.Name)
+	if externalID == "" {
+		return nil, nil
+	}
+
+	deployment, err := listers.deployments.Deployments(ref.Namespace).Get(externalID)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up the deployment for %s %s for event %s", ref.Kind, ref.Name, event.Name)
+	}
+
+	return deployment.GetLabels(), nil
+}
+
+// eventIsNew records that a message has been sent for the analysis, returning
+// false if the same message was already sent within the deduplication window.
+// Kubernetes updates the count on repeated events rather than creating new
+// ones, so this keeps users from seeing the same message over and over.
+func (i *Internal) eventIsNew(externalID, msg string, now time.Time) bool {
+	i.forwardedEventsLock.Lock()
+	defer i.forwardedEventsLock.Unlock()
+
+	for key, sent := range i.forwardedEvents {
+		if now.Sub(sent) >= i.EventDedupeWindow {
+			delete(i.forwardedEvents, key)
+		}
+	}
+
+	key := externalID + "\n" + msg
+	if _, ok := i.forwardedEvents[key]; ok {
+		return false
+	}
+
+	i.forwardedEvents[key] = now
+	return true
+}
+
+// forwardEvent sends a Kubernetes event about one of the objects for a VICE
+// analysis to the user as a Running status update.
+func (i *Internal) forwardEvent(listers *eventListers, event *corev1.Event, now time.Time) error {
+	if !shouldForwardEvent(event) {
+		return nil
+	}
+
+	objLabels, err := involvedObjectLabels(listers, event)
+	if err != nil || objLabels == nil {
+		return err
+	}
+
+	if objLabels["app-type"] != "interactive" {
+		return nil
+	}
+
+	externalID, ok := objLabels["external-id"]
+	if !ok {
+		return fmt.Errorf("%s %s is missing the external-id label", event.InvolvedObject.Kind, event.InvolvedObject.Name)
+	}
+
+	msg := eventMessage(event, objLabels["analysis-name"])
+	if !i.eventIsNew(externalID, msg, now) {
+		return nil
+	}
+
+	return i.statusPublisher.Running(externalID, msg)
+}
+
+// ForwardVICEEvents fires up a goroutine that watches the Kubernetes events in
+// the VICE namespace and passes along the ones about VICE analyses to the
+// status receiving service, so that users can see why their analyses aren't
+// starting. Events from before the goroutine started are skipped, since they
+// were probably already sent. The objects the events are about are looked up
+// in informer caches, which are filled before any events are handled. The
+// goroutine exits when the context is cancelled.
+func (i *Internal) ForwardVICEEvents(ctx context.Context) {
+	started := time.Now()
+
+	go func(clientset kubernetes.Interface) {
+		for ctx.Err() == nil {
+			log.Debug("beginning to forward k8s events")
+			set := labels.Set(map[string]string{
+				"app-type": "interactive",
+			})
+			objectFactory := informers.NewSharedInformerFactoryWithOptions(
+				clientset,
+				0,
+				informers.WithNamespace(i.ViceNamespace),
+				informers.WithTweakListOptions(func(listoptions *metav1.ListOptions) {
+					listoptions.LabelSelector = set.AsSelector().String()
+				}),
+			)
+			listers := newEventListers(objectFactory)
+			objectFactory.Start(ctx.Done())
+			objectFactory.WaitForCacheSync(ctx.Done())
+
+			factory := informers.NewSharedInformerFactoryWithOptions(
+				clientset,
+				0,
+				informers.WithNamespace(i.ViceNamespace),
+			)
+
+			eventInformer := factory.Core().V1().Events().Informer()
+
+			handleEvent := func(obj interface{}) {
+				event, ok := obj.(*corev1.Event)
+				if !ok {
+					log.Error(errors.New("unexpected type event object"))
+					return
+				}
+
+				if eventTime(event).Before(started) {
+					return
+				}
+
+				if err := i.forwardEvent(listers, event, time.Now()); err != nil {
+					log.Error(err)
+				}
+			}
+
+			eventInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: handleEvent,
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					handleEvent(newObj)
+				},
+			})
+
+			eventInformer.Run(ctx.Done())
+		}
+	}(i.clientset)
+}
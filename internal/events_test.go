@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+)
+
+// podEvent creates a fake event about the pod with the given name.
+func podEvent(name, podName, eventType, reason, msg string) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vice-apps", Name: name},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "vice-apps",
+			Name:      podName,
+		},
+		Type:    eventType,
+		Reason:  reason,
+		Message: msg,
+	}
+}
+
+// startEventListers returns listers for the objects in the fake clientset,
+// along with the channel that stops their informers.
+func startEventListers(internal *Internal) (*eventListers, chan struct{}) {
+	stop := make(chan struct{})
+	factory := informers.NewSharedInformerFactory(internal.clientset, 0)
+	listers := newEventListers(factory)
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	return listers, stop
+}
+
+func TestEventMessage(t *testing.T) {
+	event := podEvent("event", "pod", corev1.EventTypeWarning, "FailedScheduling", "0/12 nodes are available: 12 Insufficient nvidia.com/gpu.")
+	assert.Equal(
+		t,
+		"analysis foo is waiting for resources to become available: 0/12 nodes are available: 12 Insufficient nvidia.com/gpu.",
+		eventMessage(event, "foo"),
+	)
+
+	assert.True(t, shouldForwardEvent(event), "warnings should be forwarded")
+	assert.True(t, shouldForwardEvent(podEvent("event", "pod", corev1.EventTypeNormal, "Pulling", "")))
+	assert.False(t, shouldForwardEvent(podEvent("event", "pod", corev1.EventTypeNormal, "Created", "")))
+
+	started := podEvent("event", "pod", corev1.EventTypeNormal, "Started", "")
+	started.InvolvedObject.FieldPath = "spec.containers{analysis}"
+	assert.Equal(t, "container analysis has started for analysis foo", eventMessage(started, "foo"))
+}
+
+func TestForwardEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	objs := []runtime.Object{
+		vicePod(now, waitingStatus("ContainerCreating", 0)),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "vice-apps", Name: "other-pod"}},
+	}
+
+	internal, _ := setupInternal(t, objs)
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+	internal.EventDedupeWindow = 10 * time.Minute
+
+	listers, stop := startEventListers(internal)
+	defer close(stop)
+
+	mountFailure := podEvent("mount", "analysis-pod", corev1.EventTypeWarning, "FailedMount", "MountVolume.SetUp failed")
+
+	assert.NoError(internal.forwardEvent(listers, mountFailure, now))
+	assert.NoError(internal.forwardEvent(listers, mountFailure, now.Add(time.Minute)), "repeated events should be ignored")
+	assert.NoError(internal.forwardEvent(listers, podEvent("other", "other-pod", corev1.EventTypeWarning, "FailedMount", ""), now))
+	assert.NoError(internal.forwardEvent(listers, podEvent("gone", "gone-pod", corev1.EventTypeWarning, "Evicted", ""), now))
+	assert.Len(publisher.messages, 1)
+
+	assert.NoError(internal.forwardEvent(listers, mountFailure, now.Add(time.Hour)), "events should be sent again after the window")
+	assert.Len(publisher.messages, 2)
+}
+
+func TestForwardEventForDeletedPod(t *testing.T) {
+	assert := assert.New(t)
+
+	externalID := testAnalyses[0].externalID
+	dep := viceDeployment(0, "vice-apps", "foo", externalID)
+	dep.Name = *externalID
+	dep.Labels["app-type"] = "interactive"
+	dep.Labels["analysis-name"] = "analysis"
+
+	internal, _ := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+	internal.EventDedupeWindow = 10 * time.Minute
+
+	listers, stop := startEventListers(internal)
+	defer close(stop)
+
+	// The pod is gone by the time the event about its eviction arrives.
+	evicted := podEvent("evicted", *externalID+"-5d8f7c9b4-x2v7k", corev1.EventTypeWarning, "Evicted", "the node was low on memory")
+	assert.NoError(internal.forwardEvent(listers, evicted, time.Now()))
+	if assert.Len(publisher.messages, 1) {
+		assert.Contains(publisher.messages[0], "analysis analysis was evicted")
+	}
+}
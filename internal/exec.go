@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// defaultExecCommand is run in the container if the caller doesn't ask for a
+// different command.
+const defaultExecCommand = "/bin/sh"
+
+// execUpgrader upgrades exec requests to WebSocket connections.
+var execUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// podExecutor runs a command in a container of a pod, connecting it to the
+// given streams, and returns when the command exits.
+type podExecutor func(podName string, opts *apiv1.PodExecOptions, streams remotecommand.StreamOptions) error
+
+// kubernetesPodExecutor returns a podExecutor that uses the exec subresource
+// of the pods in the namespace.
+func kubernetesPodExecutor(clientset kubernetes.Interface, config *rest.Config, namespace string) podExecutor {
+	return func(podName string, opts *apiv1.PodExecOptions, streams remotecommand.StreamOptions) error {
+		if config == nil {
+			return errors.New("exec is unavailable without a Kubernetes client config")
+		}
+
+		req := clientset.CoreV1().RESTClient().
+			Post().
+			Resource("pods").
+			Name(podName).
+			Namespace(namespace).
+			SubResource("exec").
+			VersionedParams(opts, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+		if err != nil {
+			return err
+		}
+
+		return executor.Stream(streams)
+	}
+}
+
+// execMessage is a message sent by the client over the exec WebSocket. Input
+// messages carry keystrokes for the shell, and resize messages carry the new
+// size of the client's terminal.
+type execMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// execSession connects the WebSocket to the streams of a remote command. The
+// output of the command is sent to the client as binary messages.
+type execSession struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+
+	stdin       *io.PipeReader
+	stdinWriter *io.PipeWriter
+	sizes       chan remotecommand.TerminalSize
+
+	bytesIn  int64
+	bytesOut int64
+}
+
+func newExecSession(conn *websocket.Conn) *execSession {
+	stdin, stdinWriter := io.Pipe()
+	return &execSession{
+		conn:        conn,
+		stdin:       stdin,
+		stdinWriter: stdinWriter,
+		sizes:       make(chan remotecommand.TerminalSize, 1),
+	}
+}
+
+// Write sends output from the command to the client.
+func (s *execSession) Write(p []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&s.bytesOut, int64(len(p)))
+	return len(p), nil
+}
+
+// Next returns the next terminal size requested by the client, or nil once
+// the client has disconnected.
+func (s *execSession) Next() *remotecommand.TerminalSize {
+	size, ok := <-s.sizes
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// readMessages passes the client's messages along to the command until the
+// client disconnects, then closes the command's input.
+func (s *execSession) readMessages() {
+	defer close(s.sizes)
+	defer s.stdinWriter.Close()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg := &execMessage{}
+		if err = json.Unmarshal(data, msg); err != nil {
+			log.Error(errors.Wrap(err, "error parsing exec message"))
+			continue
+		}
+
+		switch msg.Type {
+		case "input":
+			n, err := s.stdinWriter.Write([]byte(msg.Data))
+			atomic.AddInt64(&s.bytesIn, int64(n))
+			if err != nil {
+				return
+			}
+		case "resize":
+			// Only the latest size matters, so an unread one is replaced.
+			select {
+			case <-s.sizes:
+			default:
+			}
+			s.sizes <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
+		}
+	}
+}
+
+// close tells the client why the session ended and closes the connection. The
+// command's input is closed too, in case a write to it is still waiting.
+func (s *execSession) close(reason string) {
+	s.stdin.Close()
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	// Close reasons are limited to 123 bytes.
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)) // nolint:errcheck
+	s.conn.Close()
+}
+
+// AdminExecHandler opens an interactive shell in a container of the analysis's
+// pod and connects it to a WebSocket. The container defaults to the analysis
+// container, and the command to /bin/sh; either can be changed with the
+// container and command query parameters. The command parameter may be
+// repeated to pass arguments. The client sends input and resize messages as
+// JSON, and receives the terminal output as binary messages. The start and end
+// of every session are recorded in the audit log.
+func (i *Internal) AdminExecHandler(c echo.Context) error {
+	analysisID := c.Param("analysis-id")
+
+	externalID, err := i.getExternalIDByAnalysisID(c.Request().Context(), analysisID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	container := c.QueryParam("container")
+	if container == "" {
+		container = "analysis"
+	}
+
+	command := c.QueryParams()["command"]
+	if len(command) == 0 {
+		command = []string{defaultExecCommand}
+	}
+
+	pods, err := i.podList(i.ViceNamespace, map[string]string{"external-id": externalID}, []string{})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no pods found for analysis %s", analysisID))
+	}
+	pod := pods.Items[0]
+
+	found := false
+	for _, ctr := range pod.Spec.Containers {
+		if ctr.Name == container {
+			found = true
+		}
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("pod %s has no container named %s", pod.Name, container))
+	}
+
+	// The upgrader responds to the client itself if the upgrade fails.
+	conn, err := execUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "error opening exec session for analysis %s", analysisID))
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Actor:      auditActor(c),
+		Action:     "OPEN " + c.Path(),
+		TargetType: "analysis",
+		TargetID:   analysisID,
+		Payload:    fmt.Sprintf("pod=%s container=%s command=%s", pod.Name, container, strings.Join(command, " ")),
+		StatusCode: http.StatusSwitchingProtocols,
+	}
+	i.recordAudit(entry)
+
+	session := newExecSession(conn)
+	go session.readMessages()
+
+	start := time.Now()
+	err = i.podExec(pod.Name, &apiv1.PodExecOptions{
+		Container: container,
+		Command:   command,
+		Stdin:     true,
+		Stdout:    true,
+		TTY:       true,
+	}, remotecommand.StreamOptions{
+		Stdin:             session.stdin,
+		Stdout:            session,
+		Tty:               true,
+		TerminalSizeQueue: session,
+	})
+
+	reason := "the session has ended"
+	entry.Action = "CLOSE " + c.Path()
+	entry.StatusCode = http.StatusOK
+	if err != nil {
+		reason = err.Error()
+		entry.StatusCode = http.StatusInternalServerError
+		entry.Error = err.Error()
+	}
+	session.close(reason)
+
+	entry.Payload = fmt.Sprintf("%s duration=%s bytes-in=%d bytes-out=%d",
+		entry.Payload,
+		time.Since(start).Round(time.Second),
+		atomic.LoadInt64(&session.bytesIn),
+		atomic.LoadInt64(&session.bytesOut),
+	)
+	i.recordAudit(entry)
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestAdminExecHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	apps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"analysis_id": "analysis-id", "steps": [{"external_id": "external-id"}]}`)
+	}))
+	defer apps.Close()
+
+	i, mock := setupInternal(t, []runtime.Object{archivePod()})
+	i.AppsServiceBaseURL = apps.URL
+
+	// The fake shell echoes each line of input back after reporting the
+	// terminal size.
+	var execOpts *apiv1.PodExecOptions
+	i.podExec = func(podName string, opts *apiv1.PodExecOptions, streams remotecommand.StreamOptions) error {
+		execOpts = opts
+		size := streams.TerminalSizeQueue.Next()
+		fmt.Fprintf(streams.Stdout, "%dx%d\n", size.Width, size.Height)
+
+		scanner := bufio.NewScanner(streams.Stdin)
+		for scanner.Scan() {
+			if scanner.Text() == "exit" {
+				return nil
+			}
+			fmt.Fprintf(streams.Stdout, "you typed %s\n", scanner.Text())
+		}
+		return scanner.Err()
+	}
+
+	mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+	mock.ExpectExec("INSERT INTO vice_admin_audit").
+		WithArgs("admin", "OPEN /vice/admin/analyses/:analysis-id/exec", "analysis", "analysis-id",
+			"pod=analysis-pod container=analysis command=/bin/sh", http.StatusSwitchingProtocols, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO vice_admin_audit").
+		WithArgs("admin", "CLOSE /vice/admin/analyses/:analysis-id/exec", "analysis", "analysis-id",
+			sqlmock.AnyArg(), http.StatusOK, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	e := echo.New()
+	e.GET("/vice/admin/analyses/:analysis-id/exec", i.AdminExecHandler, asUser("admin"))
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/vice/admin/analyses/analysis-id/exec"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint:errcheck
+
+	assert.NoError(conn.WriteJSON(&execMessage{Type: "resize", Cols: 80, Rows: 24}))
+	assert.NoError(conn.WriteJSON(&execMessage{Type: "input", Data: "ls\n"}))
+	assert.NoError(conn.WriteJSON(&execMessage{Type: "input", Data: "exit\n"}))
+
+	var output strings.Builder
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			closeErr, ok := err.(*websocket.CloseError)
+			if assert.True(ok, err.Error()) {
+				assert.Equal("the session has ended", closeErr.Text)
+			}
+			break
+		}
+		output.Write(data)
+	}
+
+	assert.Equal("80x24\nyou typed ls\n", output.String())
+	assert.Equal("analysis", execOpts.Container)
+	assert.True(execOpts.TTY)
+
+	// The session is recorded after the connection is closed.
+	assert.Eventually(func() bool { return mock.ExpectationsWereMet() == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAdminExecHandlerUnknownContainer(t *testing.T) {
+	apps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"analysis_id": "analysis-id", "steps": [{"external_id": "external-id"}]}`)
+	}))
+	defer apps.Close()
+
+	i, mock := setupInternal(t, []runtime.Object{archivePod()})
+	i.AppsServiceBaseURL = apps.URL
+
+	mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+
+	req := httptest.NewRequest(http.MethodGet, "/vice/admin/analyses/analysis-id/exec?container=vice-proxy", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("analysis-id")
+	c.SetParamValues("analysis-id")
+
+	err := i.AdminExecHandler(c)
+	if assert.IsType(t, &echo.HTTPError{}, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	}
+}
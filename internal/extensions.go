@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/lib/pq"
+)
+
+// TimeLimitPolicy bounds how far the time limit of a VICE analysis may be
+// extended. A zero value for either field means that there's no limit.
+type TimeLimitPolicy struct {
+	// The number of times the time limit may be extended.
+	MaxExtensions int `mapstructure:"max-extensions"`
+
+	// The longest the analysis may run, measured from its start date to its
+	// planned end date.
+	MaxRuntime time.Duration `mapstructure:"max-runtime"`
+}
+
+// UserTimeLimitPolicy is a TimeLimitPolicy that applies to a single user. They're
+// configured as a list rather than a map keyed by username because viper
+// lowercases map keys.
+type UserTimeLimitPolicy struct {
+	TimeLimitPolicy `mapstructure:",squash"`
+	User            string `mapstructure:"user"`
+}
+
+// GroupTimeLimitPolicy is a TimeLimitPolicy that applies to the members of a
+// group.
+type GroupTimeLimitPolicy struct {
+	TimeLimitPolicy `mapstructure:",squash"`
+	Members         []string `mapstructure:"members"`
+}
+
+// moreGenerous returns a policy containing the more generous of each of the
+// limits in the two policies.
+func (p TimeLimitPolicy) moreGenerous(other TimeLimitPolicy) TimeLimitPolicy {
+	retval := p
+
+	if p.MaxExtensions != 0 && (other.MaxExtensions == 0 || other.MaxExtensions > p.MaxExtensions) {
+		retval.MaxExtensions = other.MaxExtensions
+	}
+
+	if p.MaxRuntime != 0 && (other.MaxRuntime == 0 || other.MaxRuntime > p.MaxRuntime) {
+		retval.MaxRuntime = other.MaxRuntime
+	}
+
+	return retval
+}
+
+// timeLimitPolicy returns the policy that applies to the user, who should be
+// identified by their username without the domain suffix. A policy for the
+// user takes precedence over everything else. Otherwise, the most generous of
+// the policies for the groups the user belongs to is used, falling back to the
+// default policy if the user isn't in any of them.
+func (i *Internal) timeLimitPolicy(user string) TimeLimitPolicy {
+	for _, userPolicy := range i.UserTimeLimitPolicies {
+		if userPolicy.User == user {
+			return userPolicy.TimeLimitPolicy
+		}
+	}
+
+	var (
+		policy TimeLimitPolicy
+		found  bool
+	)
+
+	for _, group := range i.GroupTimeLimitPolicies {
+		for _, member := range group.Members {
+			if member != user {
+				continue
+			}
+
+			if found {
+				policy = policy.moreGenerous(group.TimeLimitPolicy)
+			} else {
+				policy = group.TimeLimitPolicy
+				found = true
+			}
+		}
+	}
+
+	if found {
+		return policy
+	}
+
+	return i.TimeLimitPolicy
+}
+
+// analysisTiming contains the information needed to apply a TimeLimitPolicy
+// to an analysis.
+type analysisTiming struct {
+	StartDate      pq.NullTime
+	PlannedEndDate pq.NullTime
+	Extensions     int
+}
+
+// Extensions granted by administrators don't count against the policy.
+const getAnalysisTimingSQL = `
+	SELECT j.start_date,
+	       j.planned_end_date,
+	       (SELECT count(*)
+	          FROM vice_time_limit_extensions e
+	         WHERE e.analysis_id = j.id
+	           AND NOT e.admin) AS extensions
+	  FROM jobs j
+	 WHERE j.id = $2
+	   AND j.user_id = $1
+`
+
+// The job stays locked until the transaction ends, so concurrent extension
+// requests can't all be checked against the same number of extensions.
+const lockAnalysisTimingSQL = getAnalysisTimingSQL + `
+	FOR UPDATE OF j
+`
+
+const recordTimeLimitExtensionSQL = `
+	INSERT INTO vice_time_limit_extensions (analysis_id, extended_by, extension, admin)
+	VALUES ($1, $2, $3 * interval '1 second', $4)
+`
+
+// rowQuerier is implemented by both *sqlx.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getAnalysisTiming(ctx context.Context, q rowQuerier, query, userID, id string) (*analysisTiming, error) {
+	timing := &analysisTiming{}
+	err := q.QueryRowContext(ctx, query, userID, id).Scan(
+		&timing.StartDate,
+		&timing.PlannedEndDate,
+		&timing.Extensions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return timing, nil
+}
+
+// extensionsRemaining returns the number of times the time limit may still be
+// extended, or nil if there's no limit.
+func (p TimeLimitPolicy) extensionsRemaining(timing *analysisTiming) *int {
+	if p.MaxExtensions == 0 {
+		return nil
+	}
+
+	remaining := p.MaxExtensions - timing.Extensions
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &remaining
+}
+
+// extensionTimeRemaining returns how much longer the planned end date may be
+// pushed out, or nil if there's no limit.
+func (p TimeLimitPolicy) extensionTimeRemaining(timing *analysisTiming) *time.Duration {
+	if p.MaxRuntime == 0 || !timing.StartDate.Valid || !timing.PlannedEndDate.Valid {
+		return nil
+	}
+
+	remaining := timing.StartDate.Time.Add(p.MaxRuntime).Sub(timing.PlannedEndDate.Time)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &remaining
+}
+
+// buildExtensionError returns the error used when an extension request would
+// violate the time limit policy.
+func buildExtensionError(msg string, policy TimeLimitPolicy, timing *analysisTiming, extension time.Duration) error {
+	return common.ErrorResponse{
+		ErrorCode: "ERR_LIMIT_REACHED",
+		Message:   msg,
+		Details: &map[string]interface{}{
+			"maxExtensions":          policy.MaxExtensions,
+			"maxRuntime":             int64(policy.MaxRuntime.Seconds()),
+			"extensions":             timing.Extensions,
+			"requestedExtension":     int64(extension.Seconds()),
+			"extensionsRemaining":    policy.extensionsRemaining(timing),
+			"extensionTimeRemaining": durationSeconds(policy.extensionTimeRemaining(timing)),
+		},
+	}
+}
+
+// durationSeconds converts an optional duration to an optional number of
+// seconds.
+func durationSeconds(d *time.Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	seconds := int64(d.Seconds())
+	return &seconds
+}
+
+// validateExtension makes sure that extending the time limit by the given
+// amount doesn't violate the policy.
+func validateExtension(id string, policy TimeLimitPolicy, timing *analysisTiming, extension time.Duration) error {
+	if remaining := policy.extensionsRemaining(timing); remaining != nil && *remaining <= 0 {
+		msg := fmt.Sprintf("the time limit for analysis %s has already been extended %d times", id, timing.Extensions)
+		return buildExtensionError(msg, policy, timing, extension)
+	}
+
+	if remaining := policy.extensionTimeRemaining(timing); remaining != nil && extension > *remaining {
+		msg := fmt.Sprintf("extending the time limit for analysis %s by %s would exceed the maximum run time of %s", id, extension, policy.MaxRuntime)
+		return buildExtensionError(msg, policy, timing, extension)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestTimeLimitPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	internal.TimeLimitPolicy = TimeLimitPolicy{MaxExtensions: 2, MaxRuntime: 7 * 24 * time.Hour}
+	internal.UserTimeLimitPolicies = []UserTimeLimitPolicy{
+		{User: "special", TimeLimitPolicy: TimeLimitPolicy{MaxExtensions: 1}},
+	}
+	internal.GroupTimeLimitPolicies = map[string]GroupTimeLimitPolicy{
+		"lab": {
+			TimeLimitPolicy: TimeLimitPolicy{MaxExtensions: 5, MaxRuntime: 14 * 24 * time.Hour},
+			Members:         []string{"foo", "special"},
+		},
+		"course": {
+			TimeLimitPolicy: TimeLimitPolicy{MaxExtensions: 10, MaxRuntime: 0},
+			Members:         []string{"foo"},
+		},
+	}
+
+	assert.Equal(internal.TimeLimitPolicy, internal.timeLimitPolicy("bar"), "the default policy should be used")
+	assert.Equal(TimeLimitPolicy{MaxExtensions: 1}, internal.timeLimitPolicy("special"), "the user policy should be used")
+	assert.Equal(
+		TimeLimitPolicy{MaxExtensions: 10, MaxRuntime: 0},
+		internal.timeLimitPolicy("foo"),
+		"the most generous group limits should be used",
+	)
+}
+
+func TestValidateExtension(t *testing.T) {
+	start := time.Now().Add(-48 * time.Hour)
+	plannedEnd := start.Add(72 * time.Hour)
+	timing := &analysisTiming{
+		StartDate:      pq.NullTime{Time: start, Valid: true},
+		PlannedEndDate: pq.NullTime{Time: plannedEnd, Valid: true},
+		Extensions:     2,
+	}
+
+	tests := []struct {
+		description string
+		policy      TimeLimitPolicy
+		extension   time.Duration
+		allowed     bool
+	}{
+		{"no limits", TimeLimitPolicy{}, 72 * time.Hour, true},
+		{"extensions remaining", TimeLimitPolicy{MaxExtensions: 3}, 72 * time.Hour, true},
+		{"no extensions remaining", TimeLimitPolicy{MaxExtensions: 2}, 72 * time.Hour, false},
+		{"within the maximum run time", TimeLimitPolicy{MaxRuntime: 96 * time.Hour}, 24 * time.Hour, true},
+		{"beyond the maximum run time", TimeLimitPolicy{MaxRuntime: 96 * time.Hour}, 48 * time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := validateExtension("analysis", test.policy, timing, test.extension)
+			if test.allowed {
+				assert.NoError(t, err)
+			} else {
+				errResponse, ok := err.(common.ErrorResponse)
+				assert.True(t, ok, "the error should be an ErrorResponse")
+				assert.Equal(t, "ERR_LIMIT_REACHED", errResponse.ErrorCode)
+			}
+		})
+	}
+}
+
+func TestUpdateTimeLimitRefused(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	internal.TimeLimitPolicy = TimeLimitPolicy{MaxExtensions: 1}
+
+	start := time.Now()
+	mock.ExpectQuery("SELECT users.id FROM users").
+		WithArgs("foo" + internal.UserSuffix).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-id"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT j.start_date, j.planned_end_date.*FOR UPDATE OF j").
+		WithArgs("user-id", "analysis-id").
+		WillReturnRows(sqlmock.NewRows([]string{"start_date", "planned_end_date", "extensions"}).
+			AddRow(start, start.Add(72*time.Hour), 1))
+	mock.ExpectRollback()
+
+	_, err := internal.updateTimeLimit(context.Background(), "foo", "analysis-id", 24*time.Hour, true)
+	errResponse, ok := err.(common.ErrorResponse)
+	assert.True(ok, "the error should be an ErrorResponse")
+	assert.Equal("ERR_LIMIT_REACHED", errResponse.ErrorCode)
+	assert.NoError(mock.ExpectationsWereMet(), "the time limit should not be updated")
+}
+
+func TestUpdateTimeLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	newLimit := time.Now().Add(96 * time.Hour)
+	mock.ExpectQuery("SELECT users.id FROM users").
+		WithArgs("foo" + internal.UserSuffix).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-id"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT j.start_date, j.planned_end_date.*FOR UPDATE OF j").
+		WithArgs("user-id", "analysis-id").
+		WillReturnRows(sqlmock.NewRows([]string{"start_date", "planned_end_date", "extensions"}).
+			AddRow(time.Now(), time.Now().Add(72*time.Hour), 0))
+	mock.ExpectQuery("UPDATE ONLY jobs").
+		WithArgs("user-id", "analysis-id", float64(24*60*60)).
+		WillReturnRows(sqlmock.NewRows([]string{"planned_end_date"}).AddRow(newLimit))
+	mock.ExpectExec("INSERT INTO vice_time_limit_extensions").
+		WithArgs("analysis-id", "user-id", float64(24*60*60), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	outputMap, err := internal.updateTimeLimit(context.Background(), "foo", "analysis-id", 24*time.Hour, false)
+	assert.NoError(err, "the time limit should be extended")
+	assert.NotEmpty(outputMap["time_limit"])
+	assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// idleAction is what should be done about an analysis after checking how long
+// it has been idle.
+type idleAction int
+
+const (
+	idleNone idleAction = iota
+	idleWarn
+	idleExit
+)
+
+const recordActivitySQL = `
+	INSERT INTO vice_analysis_activity (external_id, last_activity)
+	VALUES ($1, now())
+	ON CONFLICT (external_id) DO UPDATE
+	   SET last_activity = EXCLUDED.last_activity,
+	       idle_warning_sent = NULL
+`
+
+const getActivitySQL = `
+	SELECT last_activity, idle_warning_sent
+	  FROM vice_analysis_activity
+	 WHERE external_id = $1
+`
+
+const recordIdleWarningSQL = `
+	INSERT INTO vice_analysis_activity (external_id, last_activity, idle_warning_sent)
+	VALUES ($1, $2, now())
+	ON CONFLICT (external_id) DO UPDATE
+	   SET idle_warning_sent = EXCLUDED.idle_warning_sent
+`
+
+const deleteActivitySQL = `
+	DELETE FROM vice_analysis_activity
+	 WHERE external_id = $1
+`
+
+// ActivityHandler records that the VICE analysis with the external ID in the
+// URL is in use. Intended to be called periodically by vice-proxy while it is
+// forwarding requests to the analysis. Only running analyses can be kept
+// alive, and only by trusted services or by users who can see the analysis.
+func (i *Internal) ActivityHandler(c echo.Context) error {
+	externalID := c.Param("id")
+	if externalID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
+	}
+
+	deployments, err := i.analysisDeployments(externalID)
+	if err != nil {
+		return err
+	}
+
+	running := false
+	for idx := range deployments {
+		if !isSuspended(&deployments[idx]) {
+			running = true
+		}
+	}
+	if !running {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("analysis with external-id %s is suspended", externalID))
+	}
+
+	if user := auth.CurrentUser(c); user == nil || !user.Service {
+		if err = i.checkAnalysisAccess(c, externalID, readPermissionLevel); err != nil {
+			return err
+		}
+	}
+
+	if _, err = i.db.ExecContext(c.Request().Context(), recordActivitySQL, externalID); err != nil {
+		return errors.Wrapf(err, "error recording activity for external-id %s", externalID)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// UserIdleTimeout is the idle timeout for a single user's analyses. They're
+// configured as a list rather than a map keyed by username because viper
+// lowercases map keys.
+type UserIdleTimeout struct {
+	User    string        `mapstructure:"user"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// idleTimeout returns the amount of time the analysis may sit idle before the
+// user is warned. Per-user thresholds take precedence over per-app thresholds,
+// which take precedence over the default. A value of zero disables idle checks.
+func (i *Internal) idleTimeout(deployment *appsv1.Deployment) time.Duration {
+	depLabels := deployment.GetLabels()
+
+	for _, userTimeout := range i.UserIdleTimeouts {
+		if labelValueString(userTimeout.User) == depLabels["username"] {
+			return userTimeout.Timeout
+		}
+	}
+
+	if timeout, ok := i.AppIdleTimeouts[depLabels["app-id"]]; ok {
+		return timeout
+	}
+
+	return i.IdleTimeout
+}
+
+// idleActionFor decides what to do about an analysis that has been idle for
+// the given amount of time. Users are always warned before an analysis is shut
+// down, and the grace period starts when the warning is sent.
+func idleActionFor(idle, timeout, grace time.Duration, warnedAt *time.Time, now time.Time) idleAction {
+	if timeout <= 0 || idle < timeout {
+		return idleNone
+	}
+
+	if warnedAt == nil {
+		return idleWarn
+	}
+
+	if now.Sub(*warnedAt) >= grace {
+		return idleExit
+	}
+
+	return idleNone
+}
+
+// checkIdleAnalysis warns the user about or shuts down a single analysis if
+// it has been idle for too long. The shutdown is cancelled along with the
+// context, and its activity is kept unless it succeeds so that the next check
+// tries again.
+func (i *Internal) checkIdleAnalysis(ctx context.Context, deployment *appsv1.Deployment, now time.Time) error {
+	externalID, ok := deployment.GetLabels()["external-id"]
+	if !ok {
+		return fmt.Errorf("deployment %s is missing the external-id label", deployment.GetName())
+	}
+
+	timeout := i.idleTimeout(deployment)
+	if timeout <= 0 {
+		return nil
+	}
+
+	var (
+		lastActivity pq.NullTime
+		warnedAt     pq.NullTime
+	)
+
+	err := i.db.QueryRow(getActivitySQL, externalID).Scan(&lastActivity, &warnedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrapf(err, "error looking up activity for external-id %s", externalID)
+	}
+
+	// Analyses that haven't reported any activity are idle from the moment
+	// they were launched.
+	lastActive := deployment.GetCreationTimestamp().Time
+	if lastActivity.Valid {
+		lastActive = lastActivity.Time
+	}
+
+	var warned *time.Time
+	if warnedAt.Valid {
+		warned = &warnedAt.Time
+	}
+
+	idle := now.Sub(lastActive)
+
+	switch idleActionFor(idle, timeout, i.IdleGracePeriod, warned, now) {
+	case idleWarn:
+		msg := fmt.Sprintf(
+			"analysis %s has been idle for %s and will be saved and shut down in %s unless it is used",
+			deployment.GetLabels()["analysis-name"],
+			idle.Round(time.Minute),
+			i.IdleGracePeriod,
+		)
+		if err = i.statusPublisher.Running(externalID, msg); err != nil {
+			log.Error(err)
+		}
+
+		if _, err = i.db.Exec(recordIdleWarningSQL, externalID, lastActive); err != nil {
+			return errors.Wrapf(err, "error recording idle warning for external-id %s", externalID)
+		}
+
+	case idleExit:
+		if !i.startPendingExit(externalID) {
+			return nil
+		}
+
+		msg := fmt.Sprintf(
+			"analysis %s has been idle for %s and is being saved and shut down",
+			deployment.GetLabels()["analysis-name"],
+			idle.Round(time.Minute),
+		)
+		if err = i.statusPublisher.Running(externalID, msg); err != nil {
+			log.Error(err)
+		}
+
+		go func() {
+			defer i.finishPendingExit(externalID)
+
+			if err := i.doSaveAndExit(ctx, externalID, idleExitReason); err != nil {
+				return
+			}
+
+			if _, err := i.db.ExecContext(ctx, deleteActivitySQL, externalID); err != nil {
+				log.Error(errors.Wrapf(err, "error removing activity for external-id %s", externalID))
+			}
+		}()
+	}
+
+	return nil
+}
+
+// startPendingExit records that an idle analysis or one that has reached its
+// time limit is being shut down, returning false if that was already the case.
+// Saving outputs can take longer than the check interval, so this keeps the
+// save-and-exit from being started twice.
+func (i *Internal) startPendingExit(externalID string) bool {
+	i.pendingExitsLock.Lock()
+	defer i.pendingExitsLock.Unlock()
+
+	if i.pendingExits[externalID] {
+		return false
+	}
+
+	i.pendingExits[externalID] = true
+	return true
+}
+
+// finishPendingExit clears the record created by startPendingExit.
+func (i *Internal) finishPendingExit(externalID string) {
+	i.pendingExitsLock.Lock()
+	defer i.pendingExitsLock.Unlock()
+
+	delete(i.pendingExits, externalID)
+}
+
+// checkIdleAnalyses looks at every running VICE analysis and handles the ones
+// that have been idle for too long. Suspended analyses are skipped.
+func (i *Internal) checkIdleAnalyses(ctx context.Context) {
+	deployments, err := i.deploymentList(i.ViceNamespace, map[string]string{}, []string{suspendedLabel})
+	if err != nil {
+		log.Error(errors.Wrap(err, "error listing deployments for idle checks"))
+		return
+	}
+
+	now := time.Now()
+
+	for _, deployment := range deployments.Items {
+		if err = i.checkIdleAnalysis(ctx, &deployment, now); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// MonitorIdleAnalyses fires up a goroutine that periodically warns users about
+// idle VICE analyses and saves and shuts them down if they stay idle. The
+// goroutine exits when the context is cancelled.
+func (i *Internal) MonitorIdleAnalyses(ctx context.Context) {
+	go func() {
+		for {
+			log.Debug("checking for idle analyses")
+			i.checkIdleAnalyses(ctx)
+			if !sleepContext(ctx, i.IdleCheckInterval) {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestIdleActionFor(t *testing.T) {
+	now := time.Now()
+	recently := now.Add(-10 * time.Minute)
+	longAgo := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		description string
+		idle        time.Duration
+		timeout     time.Duration
+		warnedAt    *time.Time
+		expected    idleAction
+	}{
+		{"not idle long enough", 30 * time.Minute, time.Hour, nil, idleNone},
+		{"idle checks disabled", 48 * time.Hour, 0, nil, idleNone},
+		{"idle without a warning", 2 * time.Hour, time.Hour, nil, idleWarn},
+		{"warned within the grace period", 2 * time.Hour, time.Hour, &recently, idleNone},
+		{"warned before the grace period", 3 * time.Hour, time.Hour, &longAgo, idleExit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			actual := idleActionFor(test.idle, test.timeout, time.Hour, test.warnedAt, now)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	internal.IdleTimeout = time.Hour
+	internal.AppIdleTimeouts = map[string]time.Duration{"app-1": 2 * time.Hour}
+	internal.UserIdleTimeouts = []UserIdleTimeout{{User: "foo_", Timeout: 3 * time.Hour}}
+
+	dep := viceDeployment(0, "vice-apps", "bar", nil)
+	assert.Equal(time.Hour, internal.idleTimeout(dep), "the default should be used")
+
+	dep.Labels["app-id"] = "app-1"
+	assert.Equal(2*time.Hour, internal.idleTimeout(dep), "the app timeout should be used")
+
+	dep = viceDeployment(0, "vice-apps", "foo_", nil)
+	dep.Labels["app-id"] = "app-1"
+	assert.Equal(3*time.Hour, internal.idleTimeout(dep), "the user timeout should be used")
+}
+
+func TestActivityHandler(t *testing.T) {
+	externalID := "test-external-id"
+
+	tests := []struct {
+		description string
+		caller      *auth.User
+		user        string
+		suspended   bool
+		missing     bool
+		owner       string
+		status      int
+	}{
+		{"owner", nil, "foo", false, false, "foo", http.StatusOK},
+		{"owner with a token", &auth.User{Username: "foo"}, "", false, false, "foo", http.StatusOK},
+		{"trusted service", &auth.User{Username: "vice-proxy", Service: true}, "", false, false, "", http.StatusOK},
+		{"someone else", &auth.User{Username: "bar"}, "", false, false, "foo", http.StatusForbidden},
+		{"nobody", nil, "", false, false, "", http.StatusForbidden},
+		{"suspended analysis", nil, "foo", true, false, "", http.StatusConflict},
+		{"unknown analysis", nil, "foo", false, true, "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			objs := []runtime.Object{}
+			if !test.missing {
+				dep := viceDeployment(0, "vice-apps", "foo", &externalID)
+				if test.suspended {
+					dep.Labels[suspendedLabel] = "true"
+				}
+				objs = append(objs, dep)
+			}
+
+			server := permissionsServer("")
+			defer server.Close()
+
+			internal, mock := setupInternal(t, objs)
+			defer internal.db.Close()
+			internal.PermissionsURL = server.URL
+
+			if test.owner != "" {
+				mock.ExpectQuery("SELECT j.id FROM jobs j JOIN job_steps s").
+					WithArgs(externalID).
+					WillReturnRows(mock.NewRows([]string{"id"}).AddRow("analysis-id"))
+				mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+					WithArgs("analysis-id").
+					WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow(test.owner+"@example.org", "user-id"))
+			}
+			if test.status == http.StatusOK {
+				mock.ExpectExec("INSERT INTO vice_analysis_activity").
+					WithArgs(externalID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			router := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/?user="+test.user, nil)
+			rec := httptest.NewRecorder()
+			c := router.NewContext(req, rec)
+			c.SetPath("/vice/:id/activity")
+			c.SetParamNames("id")
+			c.SetParamValues(externalID)
+			if test.caller != nil {
+				auth.SetCurrentUser(c, test.caller)
+			}
+
+			err := internal.ActivityHandler(c)
+			if test.status == http.StatusOK {
+				assert.NoError(err, "recording activity should not fail")
+				assert.Equal(http.StatusOK, rec.Code)
+			} else if assert.Error(err) {
+				assert.Equal(test.status, err.(*echo.HTTPError).Code)
+			}
+			assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+		})
+	}
+}
+
+func TestCheckIdleAnalysisWarns(t *testing.T) {
+	assert := assert.New(t)
+
+	externalID := "test-external-id"
+	dep := viceDeployment(0, "vice-apps", "foo", &externalID)
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+	internal.IdleTimeout = time.Hour
+	internal.IdleGracePeriod = time.Hour
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT last_activity, idle_warning_sent FROM vice_analysis_activity").
+		WithArgs(externalID).
+		WillReturnRows(mock.NewRows([]string{"last_activity", "idle_warning_sent"}).AddRow(now.Add(-2*time.Hour), nil))
+	mock.ExpectExec("INSERT INTO vice_analysis_activity").
+		WithArgs(externalID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(internal.checkIdleAnalysis(context.Background(), dep, now), "checking the analysis should not fail")
+	assert.Len(publisher.messages, 1, "a warning should be sent")
+	assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+}
@@ -1,16 +1,21 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cyverse-de/app-exposer/apps"
 	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/app-exposer/groups"
+	"github.com/cyverse-de/app-exposer/metrics"
 	"github.com/cyverse-de/app-exposer/permissions"
+	"github.com/cyverse-de/app-exposer/tracing"
 	"github.com/gosimple/slug"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -20,6 +25,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	"github.com/labstack/echo/v4"
 )
@@ -86,15 +93,83 @@ type Init struct {
 	KeycloakRealm                 string
 	KeycloakClientID              string
 	KeycloakClientSecret          string
+	IdleTimeout                   time.Duration
+	IdleGracePeriod               time.Duration
+	IdleCheckInterval             time.Duration
+	AppIdleTimeouts               map[string]time.Duration
+	UserIdleTimeouts              []UserIdleTimeout
+	TimeLimitWarnings             []time.Duration
+	TimeLimitCheckInterval        time.Duration
+	DefaultTimeLimitExtension     time.Duration
+	TimeLimitPolicy               TimeLimitPolicy
+	UserTimeLimitPolicies         []UserTimeLimitPolicy
+	GroupTimeLimitPolicies        map[string]GroupTimeLimitPolicy
+	ReconcileInterval             time.Duration
+	OrphanGracePeriod             time.Duration
+	CleanUpOrphans                bool
+	MaxPodRestarts                int32
+	ImagePullTimeout              time.Duration
+	ExitOnPodFailure              bool
+	EventDedupeWindow             time.Duration
+	LeaderElectionEnabled         bool
+	LeaseName                     string
+	LeaseNamespace                string
+	LeaseDuration                 time.Duration
+	LeaseRenewDeadline            time.Duration
+	LeaseRetryPeriod              time.Duration
+	StatusRequestTimeout          time.Duration
+	OutboxPollInterval            time.Duration
+	OutboxBatchSize               int
+	OutboxInitialBackoff          time.Duration
+	OutboxMaxBackoff              time.Duration
+	OutboxMaxAttempts             int
+	OutboxRetention               time.Duration
+	StatusTransport               string
+	AMQPURI                       string
+	AMQPExchange                  string
+	StatusUpdateInterval          time.Duration
+	NodePoolLabel                 string
+	LogStreamHeartbeat            time.Duration
+	MaxLogStreamsPerUser          int
+	MaxLogStreamDuration          time.Duration
+	ArchiveLogs                   bool
+	LogArchiveTailLines           int64
+	LogArchiveTimeout             time.Duration
+	UsageTimeout                  time.Duration
+	UsageReconcileInterval        time.Duration
+	KubernetesConfig              *rest.Config
+	GroupsURL                     string
+	GroupsUser                    string
+	GroupsTimeout                 time.Duration
+	GroupMembers                  []groups.Membership
 }
 
 // Internal contains information and operations for launching VICE apps inside the
 // local k8s cluster.
 type Internal struct {
 	Init
-	clientset       kubernetes.Interface
-	db              *sqlx.DB
-	statusPublisher AnalysisStatusPublisher
+	clientset           kubernetes.Interface
+	db                  *sqlx.DB
+	statusPublisher     AnalysisStatusPublisher
+	statusDeliverer     StatusDeliverer
+	pendingExits        map[string]bool
+	pendingExitsLock    sync.Mutex
+	forwardedEvents     map[string]time.Time
+	forwardedEventsLock sync.Mutex
+	leaderIdentity      string
+	leader              string
+	leaderLock          sync.RWMutex
+
+	deploymentStates     map[string]*trackedDeployment
+	deploymentStatesLock sync.Mutex
+
+	podLogs        logStreamer
+	podExec        podExecutor
+	metricsClient  metricsclient.Interface
+	kubeletSummary summaryReader
+	groupLookup    groups.Lookup
+	logStreams     map[string]int
+	logStreamsLock sync.Mutex
 }
 
 // New creates a new *Internal.
@@ -103,14 +178,28 @@ func New(init *Init, db *sqlx.DB, clientset kubernetes.Interface) *Internal {
 		Init:      *init,
 		db:        db,
 		clientset: clientset,
-		statusPublisher: &JSLPublisher{
-			statusURL: init.JobStatusURL,
+		statusPublisher: &OutboxPublisher{
+			db: db,
 		},
+		statusDeliverer: newStatusDeliverer(init),
+		pendingExits:    map[string]bool{},
+		forwardedEvents: map[string]time.Time{},
+		leaderIdentity:  hostname(),
+
+		deploymentStates: map[string]*trackedDeployment{},
+
+		podLogs:    kubernetesLogStreamer(clientset, init.ViceNamespace),
+		podExec:    kubernetesPodExecutor(clientset, init.KubernetesConfig, init.ViceNamespace),
+		logStreams: map[string]int{},
+
+		metricsClient:  newMetricsClient(init),
+		kubeletSummary: kubernetesSummaryReader(clientset, init.UsageTimeout),
+		groupLookup:    newGroupLookup(init),
 	}
 }
 
 // labelsFromJob returns a map[string]string that can be used as labels for K8s resources.
-func (i *Internal) labelsFromJob(job *model.Job) (map[string]string, error) {
+func (i *Internal) labelsFromJob(ctx context.Context, job *model.Job) (map[string]string, error) {
 	name := []rune(job.Name)
 
 	var stringmax int
@@ -121,7 +210,7 @@ func (i *Internal) labelsFromJob(job *model.Job) (map[string]string, error) {
 	}
 
 	a := apps.NewApps(i.db, i.UserSuffix)
-	ipAddr, err := a.GetUserIP(job.UserID)
+	ipAddr, err := a.GetUserIP(ctx, job.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -143,23 +232,29 @@ func (i *Internal) labelsFromJob(job *model.Job) (map[string]string, error) {
 // containing the files that should not be uploaded to iRODS. It then calls
 // the k8s API to create the ConfigMap if it does not already exist or to
 // update it if it does.
-func (i *Internal) UpsertExcludesConfigMap(job *model.Job) error {
-	excludesCM, err := i.excludesConfigMap(job)
+func (i *Internal) UpsertExcludesConfigMap(ctx context.Context, job *model.Job) error {
+	excludesCM, err := i.excludesConfigMap(ctx, job)
 	if err != nil {
 		return err
 	}
 
 	cmclient := i.clientset.CoreV1().ConfigMaps(i.ViceNamespace)
 
+	span := i.startK8sSpan(ctx, "get", "configmaps", excludesConfigMapName(job))
 	_, err = cmclient.Get(excludesConfigMapName(job), metav1.GetOptions{})
+	endK8sSpan(span, err)
 	if err != nil {
 		log.Info(err)
+		span := i.startK8sSpan(ctx, "create", "configmaps", excludesCM.Name)
 		_, err = cmclient.Create(excludesCM)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
 	} else {
+		span := i.startK8sSpan(ctx, "update", "configmaps", excludesCM.Name)
 		_, err = cmclient.Update(excludesCM)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
@@ -171,22 +266,28 @@ func (i *Internal) UpsertExcludesConfigMap(job *model.Job) error {
 // containing the path list of files to download from iRODS for the VICE analysis.
 // It then uses the k8s API to create the ConfigMap if it does not already exist or to
 // update it if it does.
-func (i *Internal) UpsertInputPathListConfigMap(job *model.Job) error {
-	inputCM, err := i.inputPathListConfigMap(job)
+func (i *Internal) UpsertInputPathListConfigMap(ctx context.Context, job *model.Job) error {
+	inputCM, err := i.inputPathListConfigMap(ctx, job)
 	if err != nil {
 		return err
 	}
 
 	cmclient := i.clientset.CoreV1().ConfigMaps(i.ViceNamespace)
 
+	span := i.startK8sSpan(ctx, "get", "configmaps", inputPathListConfigMapName(job))
 	_, err = cmclient.Get(inputPathListConfigMapName(job), metav1.GetOptions{})
+	endK8sSpan(span, err)
 	if err != nil {
+		span := i.startK8sSpan(ctx, "create", "configmaps", inputCM.Name)
 		_, err = cmclient.Create(inputCM)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
 	} else {
+		span := i.startK8sSpan(ctx, "update", "configmaps", inputCM.Name)
 		_, err = cmclient.Update(inputCM)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
@@ -198,33 +299,44 @@ func (i *Internal) UpsertInputPathListConfigMap(job *model.Job) error {
 // UpsertDeployment uses the Job passed in to assemble a Deployment for the
 // VICE analysis. If then uses the k8s API to create the Deployment if it does
 // not already exist or to update it if it does.
-func (i *Internal) UpsertDeployment(job *model.Job) error {
-	deployment, err := i.getDeployment(job)
+func (i *Internal) UpsertDeployment(ctx context.Context, job *model.Job) error {
+	deployment, err := i.getDeployment(ctx, job)
 	if err != nil {
 		return err
 	}
 
 	depclient := i.clientset.AppsV1().Deployments(i.ViceNamespace)
+	span := i.startK8sSpan(ctx, "get", "deployments", job.InvocationID)
 	_, err = depclient.Get(job.InvocationID, metav1.GetOptions{})
+	endK8sSpan(span, err)
 	if err != nil {
-		_, err = depclient.Create(deployment)
+		span := i.startK8sSpan(ctx, "create", "deployments", deployment.Name)
+		created, err := depclient.Create(deployment)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
+
+		// The usage reconciler opens the record later if this fails.
+		if err = i.openUsage(ctx, created); err != nil {
+			log.Error(err)
+		}
 	} else {
+		span := i.startK8sSpan(ctx, "update", "deployments", deployment.Name)
 		_, err = depclient.Update(deployment)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Create the persistent volumes and persistent volume claims for the job.
-	volumes, err := i.getPersistentVolumes(job)
+	volumes, err := i.getPersistentVolumes(ctx, job)
 	if err != nil {
 		return err
 	}
 
-	volumeclaims, err := i.getPersistentVolumeClaims(job)
+	volumeclaims, err := i.getPersistentVolumeClaims(ctx, job)
 	if err != nil {
 		return err
 	}
@@ -233,14 +345,20 @@ func (i *Internal) UpsertDeployment(job *model.Job) error {
 		pvclient := i.clientset.CoreV1().PersistentVolumes()
 
 		for _, volume := range volumes {
+			span := i.startK8sSpan(ctx, "get", "persistentvolumes", volume.GetName())
 			_, err = pvclient.Get(volume.GetName(), metav1.GetOptions{})
+			endK8sSpan(span, err)
 			if err != nil {
+				span := i.startK8sSpan(ctx, "create", "persistentvolumes", volume.Name)
 				_, err = pvclient.Create(volume)
+				endK8sSpan(span, err)
 				if err != nil {
 					return err
 				}
 			} else {
+				span := i.startK8sSpan(ctx, "update", "persistentvolumes", volume.Name)
 				_, err = pvclient.Update(volume)
+				endK8sSpan(span, err)
 				if err != nil {
 					return err
 				}
@@ -252,14 +370,20 @@ func (i *Internal) UpsertDeployment(job *model.Job) error {
 		pvcclient := i.clientset.CoreV1().PersistentVolumeClaims(i.ViceNamespace)
 
 		for _, volumeClaim := range volumeclaims {
+			span := i.startK8sSpan(ctx, "get", "persistentvolumeclaims", volumeClaim.GetName())
 			_, err = pvcclient.Get(volumeClaim.GetName(), metav1.GetOptions{})
+			endK8sSpan(span, err)
 			if err != nil {
+				span := i.startK8sSpan(ctx, "create", "persistentvolumeclaims", volumeClaim.Name)
 				_, err = pvcclient.Create(volumeClaim)
+				endK8sSpan(span, err)
 				if err != nil {
 					return err
 				}
 			} else {
+				span := i.startK8sSpan(ctx, "update", "persistentvolumeclaims", volumeClaim.Name)
 				_, err = pvcclient.Update(volumeClaim)
+				endK8sSpan(span, err)
 				if err != nil {
 					return err
 				}
@@ -268,29 +392,37 @@ func (i *Internal) UpsertDeployment(job *model.Job) error {
 	}
 
 	// Create the service for the job.
-	svc, err := i.getService(job, deployment)
+	svc, err := i.getService(ctx, job, deployment)
 	if err != nil {
 		return err
 	}
 	svcclient := i.clientset.CoreV1().Services(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "get", "services", job.InvocationID)
 	_, err = svcclient.Get(job.InvocationID, metav1.GetOptions{})
+	endK8sSpan(span, err)
 	if err != nil {
+		span := i.startK8sSpan(ctx, "create", "services", svc.Name)
 		_, err = svcclient.Create(svc)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Create the ingress for the job
-	ingress, err := i.getIngress(job, svc)
+	ingress, err := i.getIngress(ctx, job, svc)
 	if err != nil {
 		return err
 	}
 
 	ingressclient := i.clientset.ExtensionsV1beta1().Ingresses(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "get", "ingresses", ingress.Name)
 	_, err = ingressclient.Get(ingress.Name, metav1.GetOptions{})
+	endK8sSpan(span, err)
 	if err != nil {
+		span := i.startK8sSpan(ctx, "create", "ingresses", ingress.Name)
 		_, err = ingressclient.Create(ingress)
+		endK8sSpan(span, err)
 		if err != nil {
 			return err
 		}
@@ -302,19 +434,17 @@ func (i *Internal) UpsertDeployment(job *model.Job) error {
 // LaunchAppHandler is the HTTP handler that orchestrates the launching of a VICE analysis inside
 // the k8s cluster. This get passed to the router to be associated with a route. The Job
 // is passed in as the body of the request.
-func (i *Internal) LaunchAppHandler(c echo.Context) error {
-	var (
-		job *model.Job
-		err error
-	)
+func (i *Internal) LaunchAppHandler(c echo.Context) (err error) {
+	defer func() { metrics.RecordLaunch(err) }()
 
-	job = &model.Job{}
+	ctx := c.Request().Context()
+	job := &model.Job{}
 
 	if err = c.Bind(job); err != nil {
 		return err
 	}
 
-	if status, err := i.validateJob(job); err != nil {
+	if status, err := i.validateJob(c.Request().Context(), job); err != nil {
 		if validationErr, ok := err.(common.ErrorResponse); ok {
 			return validationErr
 		}
@@ -322,17 +452,17 @@ func (i *Internal) LaunchAppHandler(c echo.Context) error {
 	}
 
 	// Create the excludes file ConfigMap for the job.
-	if err = i.UpsertExcludesConfigMap(job); err != nil {
+	if err = i.UpsertExcludesConfigMap(ctx, job); err != nil {
 		return err
 	}
 
 	// Create the input path list config map
-	if err = i.UpsertInputPathListConfigMap(job); err != nil {
+	if err = i.UpsertInputPathListConfigMap(ctx, job); err != nil {
 		return err
 	}
 
 	// Create the deployment for the job.
-	if err = i.UpsertDeployment(job); err != nil {
+	if err = i.UpsertDeployment(ctx, job); err != nil {
 		return err
 	}
 
@@ -341,6 +471,9 @@ func (i *Internal) LaunchAppHandler(c echo.Context) error {
 
 // TriggerDownloadsHandler handles requests to trigger file downloads.
 func (i *Internal) TriggerDownloadsHandler(c echo.Context) error {
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
 	return i.doFileTransfer(c.Param("id"), downloadBasePath, downloadKind, true)
 }
 
@@ -353,7 +486,7 @@ func (i *Internal) AdminTriggerDownloadsHandler(c echo.Context) error {
 
 	analysisID := c.Param("analysis-id")
 
-	externalID, err := i.getExternalIDByAnalysisID(analysisID)
+	externalID, err := i.getExternalIDByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -363,6 +496,9 @@ func (i *Internal) AdminTriggerDownloadsHandler(c echo.Context) error {
 
 // TriggerUploadsHandler handles requests to trigger file uploads.
 func (i *Internal) TriggerUploadsHandler(c echo.Context) error {
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
 	return i.doFileTransfer(c.Param("id"), uploadBasePath, uploadKind, true)
 }
 
@@ -375,7 +511,7 @@ func (i *Internal) AdminTriggerUploadsHandler(c echo.Context) error {
 
 	analysisID := c.Param("analysis-id")
 
-	externalID, err := i.getExternalIDByAnalysisID(analysisID)
+	externalID, err := i.getExternalIDByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -383,7 +519,16 @@ func (i *Internal) AdminTriggerUploadsHandler(c echo.Context) error {
 	return i.doFileTransfer(externalID, uploadBasePath, uploadKind, true)
 }
 
-func (i *Internal) doExit(externalID string) error {
+// doExit deletes the Kubernetes objects for the VICE analysis. The reason is
+// used to count exits in the metrics.
+func (i *Internal) doExit(ctx context.Context, externalID, reason string) error {
+	logger := tracing.Logger(ctx)
+
+	// The logs go away with the pods, so they have to be saved first.
+	if i.ArchiveLogs {
+		i.archiveLogs(ctx, externalID, reason)
+	}
+
 	set := labels.Set(map[string]string{
 		"external-id": externalID,
 	})
@@ -394,40 +539,55 @@ func (i *Internal) doExit(externalID string) error {
 
 	// Delete the ingress
 	ingressclient := i.clientset.ExtensionsV1beta1().Ingresses(i.ViceNamespace)
+	span := i.startK8sSpan(ctx, "list", "ingresses", "")
 	ingresslist, err := ingressclient.List(listoptions)
+	endK8sSpan(span, err)
 	if err != nil {
 		return err
 	}
 
 	for _, ingress := range ingresslist.Items {
-		if err = ingressclient.Delete(ingress.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Error(err)
+		span := i.startK8sSpan(ctx, "delete", "ingresses", ingress.Name)
+		err = ingressclient.Delete(ingress.Name, &metav1.DeleteOptions{})
+		endK8sSpan(span, err)
+		if err != nil {
+			logger.Error(err)
 		}
 	}
 
 	// Delete the service
 	svcclient := i.clientset.CoreV1().Services(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "list", "services", "")
 	svclist, err := svcclient.List(listoptions)
+	endK8sSpan(span, err)
 	if err != nil {
 		return err
 	}
 
 	for _, svc := range svclist.Items {
-		if err = svcclient.Delete(svc.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Error(err)
+		span := i.startK8sSpan(ctx, "delete", "services", svc.Name)
+		err = svcclient.Delete(svc.Name, &metav1.DeleteOptions{})
+		endK8sSpan(span, err)
+		if err != nil {
+			logger.Error(err)
 		}
 	}
 
 	// Delete the deployment
 	depclient := i.clientset.AppsV1().Deployments(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "list", "deployments", "")
 	deplist, err := depclient.List(listoptions)
+	endK8sSpan(span, err)
 	if err != nil {
 		return err
 	}
 
 	for _, dep := range deplist.Items {
-		if err = depclient.Delete(dep.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Error(err)
+		span := i.startK8sSpan(ctx, "delete", "deployments", dep.Name)
+		err = depclient.Delete(dep.Name, &metav1.DeleteOptions{})
+		endK8sSpan(span, err)
+		if err != nil {
+			logger.Error(err)
 		}
 	}
 
@@ -435,33 +595,45 @@ func (i *Internal) doExit(externalID string) error {
 	// Delete persistent volume claims.
 	// This will automatically delete persistent volumes associated with them.
 	pvcclient := i.clientset.CoreV1().PersistentVolumeClaims(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "list", "persistentvolumeclaims", "")
 	pvclist, err := pvcclient.List(listoptions)
+	endK8sSpan(span, err)
 	if err != nil {
 		return err
 	}
 
 	for _, pvc := range pvclist.Items {
-		if err = pvcclient.Delete(pvc.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Error(err)
+		span := i.startK8sSpan(ctx, "delete", "persistentvolumeclaims", pvc.Name)
+		err = pvcclient.Delete(pvc.Name, &metav1.DeleteOptions{})
+		endK8sSpan(span, err)
+		if err != nil {
+			logger.Error(err)
 		}
 	}
 
 	// Delete the input files list and the excludes list config maps
 	cmclient := i.clientset.CoreV1().ConfigMaps(i.ViceNamespace)
+	span = i.startK8sSpan(ctx, "list", "configmaps", "")
 	cmlist, err := cmclient.List(listoptions)
+	endK8sSpan(span, err)
 	if err != nil {
 		return err
 	}
 
-	log.Infof("number of configmaps to be deleted for %s: %d", externalID, len(cmlist.Items))
+	logger.Infof("number of configmaps to be deleted for %s: %d", externalID, len(cmlist.Items))
 
 	for _, cm := range cmlist.Items {
-		log.Infof("deleting configmap %s for %s", cm.Name, externalID)
-		if err = cmclient.Delete(cm.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Error(err)
+		logger.Infof("deleting configmap %s for %s", cm.Name, externalID)
+		span := i.startK8sSpan(ctx, "delete", "configmaps", cm.Name)
+		err = cmclient.Delete(cm.Name, &metav1.DeleteOptions{})
+		endK8sSpan(span, err)
+		if err != nil {
+			logger.Error(err)
 		}
 	}
 
+	metrics.Exits.WithLabelValues(reason).Inc()
+
 	return nil
 }
 
@@ -471,7 +643,10 @@ func (i *Internal) doExit(externalID string) error {
 // namespace associated with the job. Deletes the following objects:
 // ingresses, services, deployments, and configmaps.
 func (i *Internal) ExitHandler(c echo.Context) error {
-	return i.doExit(c.Param("id"))
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
+	return i.doExit(c.Request().Context(), c.Param("id"), userExitReason)
 }
 
 // AdminExitHandler terminates the VICE analysis based on the analysisID and
@@ -482,12 +657,12 @@ func (i *Internal) AdminExitHandler(c echo.Context) error {
 
 	analysisID := c.Param("analysis-id")
 
-	externalID, err := i.getExternalIDByAnalysisID(analysisID)
+	externalID, err := i.getExternalIDByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	return i.doExit(externalID)
+	return i.doExit(c.Request().Context(), externalID, adminExitReason)
 }
 
 // getIDFromHost returns the external ID for the running VICE app, which
@@ -529,7 +704,7 @@ func (i *Internal) URLReadyHandler(c echo.Context) error {
 	// the user ID.
 	fixedUser := i.fixUsername(user)
 	a := apps.NewApps(i.db, i.UserSuffix)
-	_, err := a.GetUserID(fixedUser)
+	_, err := a.GetUserID(c.Request().Context(), fixedUser)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("user %s not found", fixedUser))
@@ -583,7 +758,7 @@ func (i *Internal) URLReadyHandler(c echo.Context) error {
 		"ready": ingressExists && serviceExists && podReady,
 	}
 
-	analysisID, err := a.GetAnalysisIDByExternalID(id)
+	analysisID, err := a.GetAnalysisIDByExternalID(c.Request().Context(), id)
 	if err != nil {
 		return err
 	}
@@ -665,6 +840,34 @@ func (i *Internal) AdminURLReadyHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, data)
 }
 
+// doSaveAndExit uploads the output files for the VICE analysis and then
+// terminates it. The exit happens even if the upload fails, since it's possible
+// to cancel an analysis that hasn't started yet. This blocks until the upload
+// is complete, so callers will usually want to run it in a goroutine.
+func (i *Internal) doSaveAndExit(ctx context.Context, externalID, reason string) error {
+	logger := tracing.Logger(ctx)
+
+	var err error
+
+	logger.Infof("calling doFileTransfer for %s", externalID)
+
+	// Trigger a blocking output file transfer request.
+	if err = i.doFileTransfer(externalID, uploadBasePath, uploadKind, false); err != nil {
+		logger.Error(errors.Wrap(err, "error doing file transfer")) // Log but don't exit. Possible to cancel a job that hasn't started yet
+	}
+
+	logger.Infof("calling VICEExit for %s", externalID)
+
+	if err = i.doExit(ctx, externalID, reason); err != nil {
+		err = errors.Wrapf(err, "error triggering analysis exit for %s", externalID)
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("after VICEExit for %s", externalID)
+	return nil
+}
+
 // SaveAndExitHandler handles requests to save the output files in iRODS and then exit.
 // The exit portion will only occur if the save operation succeeds. The operation is
 // performed inside of a goroutine so that the caller isn't waiting for hours/days for
@@ -672,27 +875,12 @@ func (i *Internal) AdminURLReadyHandler(c echo.Context) error {
 func (i *Internal) SaveAndExitHandler(c echo.Context) error {
 	log.Info("save and exit called")
 
-	// Since file transfers can take a while, we should do this asynchronously by default.
-	go func(c echo.Context) {
-		var err error
-
-		externalID := c.Param("id")
-
-		log.Infof("calling doFileTransfer for %s", externalID)
-
-		// Trigger a blocking output file transfer request.
-		if err = i.doFileTransfer(externalID, uploadBasePath, uploadKind, false); err != nil {
-			log.Error(errors.Wrap(err, "error doing file transfer")) // Log but don't exit. Possible to cancel a job that hasn't started yet
-		}
-
-		log.Infof("calling VICEExit for %s", externalID)
-
-		if err = i.doExit(externalID); err != nil {
-			log.Error(errors.Wrapf(err, "error triggering analysis exit for %s", externalID))
-		}
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
 
-		log.Infof("after VICEExit for %s", externalID)
-	}(c)
+	// Since file transfers can take a while, we should do this asynchronously by default.
+	go i.doSaveAndExit(tracing.Detach(c.Request().Context()), c.Param("id"), userExitReason) // nolint:errcheck
 
 	log.Info("leaving save and exit")
 
@@ -706,35 +894,22 @@ func (i *Internal) SaveAndExitHandler(c echo.Context) error {
 func (i *Internal) AdminSaveAndExitHandler(c echo.Context) error {
 	log.Info("admin save and exit called")
 
-	// Since file transfers can take a while, we should do this asynchronously by default.
-	go func(c echo.Context) {
-		var (
-			err        error
-			externalID string
-		)
-
-		log.Debug("calling doFileTransfer")
-
-		analysisID := c.Param("analysis-id")
+	// The request's context is cancelled and echo reuses the echo.Context once
+	// the handler returns, so only the analysis ID and the trace are carried
+	// over to the goroutine.
+	analysisID := c.Param("analysis-id")
+	ctx := tracing.Detach(c.Request().Context())
 
-		if externalID, err = i.getExternalIDByAnalysisID(analysisID); err != nil {
+	// Since file transfers can take a while, we should do this asynchronously by default.
+	go func() {
+		externalID, err := i.getExternalIDByAnalysisID(ctx, analysisID)
+		if err != nil {
 			log.Error(err)
 			return
 		}
 
-		// Trigger a blocking output file transfer request.
-		if err = i.doFileTransfer(externalID, uploadBasePath, uploadKind, false); err != nil {
-			log.Error(errors.Wrap(err, "error doing file transfer")) // Log but don't exit. Possible to cancel a job that hasn't started yet
-		}
-
-		log.Debug("calling VICEExit")
-
-		if err = i.doExit(externalID); err != nil {
-			log.Error(err)
-		}
-
-		log.Debug("after VICEExit")
-	}(c)
+		i.doSaveAndExit(ctx, externalID, adminExitReason) // nolint:errcheck
+	}()
 
 	log.Info("admin leaving save and exit")
 	return nil
@@ -742,27 +917,42 @@ func (i *Internal) AdminSaveAndExitHandler(c echo.Context) error {
 
 const updateTimeLimitSQL = `
 	UPDATE ONLY jobs
-	   SET planned_end_date = old_value.planned_end_date + interval '72 hours'
+	   SET planned_end_date = old_value.planned_end_date + $3 * interval '1 second'
 	  FROM (SELECT planned_end_date FROM jobs WHERE id = $2) AS old_value
 	 WHERE jobs.id = $2
 	   AND jobs.user_id = $1
  RETURNING jobs.planned_end_date
 `
 
-const getTimeLimitSQL = `
-	SELECT planned_end_date
-	  FROM jobs
-	 WHERE jobs.id = $2
-	   AND jobs.user_id = $1
-`
-
 const getUserIDSQL = `
 	SELECT users.id
 	  FROM users
 	 WHERE username = $1
 `
 
+// extensionDuration returns the amount of time requested in the duration query
+// parameter, which uses the format accepted by time.ParseDuration. The default
+// extension is returned if the parameter isn't present.
+func (i *Internal) extensionDuration(c echo.Context) (time.Duration, error) {
+	value := c.QueryParam("duration")
+	if value == "" {
+		return i.DefaultTimeLimitExtension, nil
+	}
+
+	extension, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if extension <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "duration must be positive")
+	}
+
+	return extension, nil
+}
+
 // TimeLimitUpdateHandler handles requests to update the time limit on an already running VICE app.
+// The extension is bounded by the time limit policy that applies to the user.
 func (i *Internal) TimeLimitUpdateHandler(c echo.Context) error {
 	log.Info("update time limit called")
 
@@ -773,7 +963,7 @@ func (i *Internal) TimeLimitUpdateHandler(c echo.Context) error {
 	)
 
 	// user is required
-	user = c.QueryParam("user")
+	user = i.callerUsername(c)
 	if user == "" {
 		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
 	}
@@ -786,7 +976,12 @@ func (i *Internal) TimeLimitUpdateHandler(c echo.Context) error {
 		return idErr
 	}
 
-	outputMap, err := i.updateTimeLimit(user, id)
+	extension, err := i.extensionDuration(c)
+	if err != nil {
+		return err
+	}
+
+	outputMap, err := i.updateTimeLimit(c.Request().Context(), user, id, extension, true)
 	if err != nil {
 		log.Error(err)
 		return err
@@ -797,7 +992,8 @@ func (i *Internal) TimeLimitUpdateHandler(c echo.Context) error {
 }
 
 // AdminTimeLimitUpdateHandler is basically the same as VICETimeLimitUpdate
-// except that it doesn't require user information in the request.
+// except that it doesn't require user information in the request. The time
+// limit policy is not enforced for administrators.
 func (i *Internal) AdminTimeLimitUpdateHandler(c echo.Context) error {
 	var (
 		err  error
@@ -810,14 +1006,19 @@ func (i *Internal) AdminTimeLimitUpdateHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
 	}
 
+	extension, err := i.extensionDuration(c)
+	if err != nil {
+		return err
+	}
+
 	apps := apps.NewApps(i.db, i.UserSuffix)
 
-	user, _, err = apps.GetUserByAnalysisID(id)
+	user, _, err = apps.GetUserByAnalysisID(c.Request().Context(), id)
 	if err != nil {
 		return err
 	}
 
-	outputMap, err := i.updateTimeLimit(user, id)
+	outputMap, err := i.updateTimeLimit(c.Request().Context(), user, id, extension, false)
 	if err != nil {
 		return err
 	}
@@ -833,11 +1034,12 @@ func (i *Internal) GetTimeLimitHandler(c echo.Context) error {
 		err        error
 		analysisID string
 		user       string
+		owner      string
 		userID     string
 	)
 
 	// user is required
-	user = c.QueryParam("user")
+	user = i.callerUsername(c)
 	if user == "" {
 		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
 	}
@@ -851,12 +1053,12 @@ func (i *Internal) GetTimeLimitHandler(c echo.Context) error {
 	apps := apps.NewApps(i.db, i.UserSuffix)
 
 	// Could use this to get the username, but we need to not break other services.
-	_, userID, err = apps.GetUserByAnalysisID(analysisID)
+	owner, userID, err = apps.GetUserByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return err
 	}
 
-	outputMap, err := i.getTimeLimit(userID, analysisID)
+	outputMap, err := i.getTimeLimit(c.Request().Context(), owner, userID, analysisID)
 	if err != nil {
 		return err
 	}
@@ -872,6 +1074,7 @@ func (i *Internal) AdminGetTimeLimitHandler(c echo.Context) error {
 	var (
 		err        error
 		analysisID string
+		owner      string
 		userID     string
 	)
 
@@ -884,12 +1087,12 @@ func (i *Internal) AdminGetTimeLimitHandler(c echo.Context) error {
 	apps := apps.NewApps(i.db, i.UserSuffix)
 
 	// Could use this to get the username, but we need to not break other services.
-	_, userID, err = apps.GetUserByAnalysisID(analysisID)
+	owner, userID, err = apps.GetUserByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return err
 	}
 
-	outputMap, err := i.getTimeLimit(userID, analysisID)
+	outputMap, err := i.getTimeLimit(c.Request().Context(), owner, userID, analysisID)
 	if err != nil {
 		return err
 	}
@@ -897,47 +1100,91 @@ func (i *Internal) AdminGetTimeLimitHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, outputMap)
 }
 
-func (i *Internal) getTimeLimit(userID, id string) (map[string]string, error) {
-	var err error
-
-	var timeLimit pq.NullTime
-	if err = i.db.QueryRow(getTimeLimitSQL, userID, id).Scan(&timeLimit); err != nil {
+// getTimeLimit returns the current time limit for the analysis along with how
+// much more it may be extended under the time limit policy that applies to the
+// user. Allowances without a limit are returned as "null".
+func (i *Internal) getTimeLimit(ctx context.Context, user, userID, id string) (map[string]string, error) {
+	timing, err := getAnalysisTiming(ctx, i.db, getAnalysisTimingSQL, userID, id)
+	if err != nil {
 		return nil, errors.Wrapf(err, "error retrieving time limit for user %s on analysis %s", userID, id)
 	}
 
 	outputMap := map[string]string{}
-	if timeLimit.Valid {
-		v, err := timeLimit.Value()
-		if err != nil {
-			return nil, errors.Wrapf(err, "error getting time limit for user %s on analysis %s", userID, id)
-		}
-		outputMap["time_limit"] = fmt.Sprintf("%d", v.(time.Time).Unix())
+	if timing.PlannedEndDate.Valid {
+		outputMap["time_limit"] = fmt.Sprintf("%d", timing.PlannedEndDate.Time.Unix())
 	} else {
 		outputMap["time_limit"] = "null"
 	}
 
+	policy := i.timeLimitPolicy(strings.TrimSuffix(user, i.UserSuffix))
+
+	if remaining := policy.extensionsRemaining(timing); remaining != nil {
+		outputMap["extensions_remaining"] = fmt.Sprintf("%d", *remaining)
+	} else {
+		outputMap["extensions_remaining"] = "null"
+	}
+
+	if remaining := durationSeconds(policy.extensionTimeRemaining(timing)); remaining != nil {
+		outputMap["extension_time_remaining"] = fmt.Sprintf("%d", *remaining)
+	} else {
+		outputMap["extension_time_remaining"] = "null"
+	}
+
 	return outputMap, nil
 }
 
-func (i *Internal) updateTimeLimit(user, id string) (map[string]string, error) {
+// updateTimeLimit extends the time limit of the analysis and records the
+// extension. If enforcePolicy is true, the extension is refused with an
+// ERR_LIMIT_REACHED error when it would violate the user's time limit policy.
+// Otherwise, the extension is recorded as an administrative one, which doesn't
+// count against the policy.
+func (i *Internal) updateTimeLimit(ctx context.Context, user, id string, extension time.Duration, enforcePolicy bool) (map[string]string, error) {
 	var (
 		err    error
 		userID string
 	)
 
-	if !strings.HasSuffix(user, userSuffix) {
-		user = fmt.Sprintf("%s%s", user, userSuffix)
+	if !strings.HasSuffix(user, i.UserSuffix) {
+		user = fmt.Sprintf("%s%s", user, i.UserSuffix)
 	}
 
-	if err = i.db.QueryRow(getUserIDSQL, user).Scan(&userID); err != nil {
+	if err = i.db.QueryRowContext(ctx, getUserIDSQL, user).Scan(&userID); err != nil {
 		return nil, errors.Wrapf(err, "error looking user ID for %s", user)
 	}
 
+	// The transaction is rolled back if the client goes away, so the row lock
+	// isn't held any longer than the request.
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	timing, err := getAnalysisTiming(ctx, tx, lockAnalysisTimingSQL, userID, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving time limit for user %s on analysis %s", userID, id)
+	}
+
+	if enforcePolicy {
+		policy := i.timeLimitPolicy(strings.TrimSuffix(user, i.UserSuffix))
+		if err = validateExtension(id, policy, timing, extension); err != nil {
+			return nil, err
+		}
+	}
+
 	var newTimeLimit pq.NullTime
-	if err = i.db.QueryRow(updateTimeLimitSQL, userID, id).Scan(&newTimeLimit); err != nil {
+	if err = tx.QueryRowContext(ctx, updateTimeLimitSQL, userID, id, extension.Seconds()).Scan(&newTimeLimit); err != nil {
 		return nil, errors.Wrapf(err, "error extending time limit for user %s on analysis %s", userID, id)
 	}
 
+	if _, err = tx.ExecContext(ctx, recordTimeLimitExtensionSQL, id, userID, extension.Seconds(), !enforcePolicy); err != nil {
+		return nil, errors.Wrapf(err, "error recording time limit extension for analysis %s", id)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Wrapf(err, "error committing time limit extension for analysis %s", id)
+	}
+
 	outputMap := map[string]string{}
 	if newTimeLimit.Valid {
 		v, err := newTimeLimit.Value()
@@ -967,7 +1214,7 @@ func (i *Internal) AdminGetExternalIDHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
 	}
 
-	externalID, err = i.getExternalIDByAnalysisID(analysisID)
+	externalID, err = i.getExternalIDByAnalysisID(c.Request().Context(), analysisID)
 	if err != nil {
 		return err
 	}
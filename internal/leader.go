@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// BackgroundTask starts a background loop that runs until the context is
+// cancelled. The loops that act on every VICE analysis in the cluster should
+// only run in one replica at a time.
+type BackgroundTask func(ctx context.Context)
+
+// LeaderStatus describes the state of the leader election.
+type LeaderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+// sleepContext waits for the given amount of time, returning false if the
+// context is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// setLeader records the identity of the current leader.
+func (i *Internal) setLeader(identity string) {
+	i.leaderLock.Lock()
+	defer i.leaderLock.Unlock()
+
+	i.leader = identity
+}
+
+// leaderStatus returns the current state of the leader election.
+func (i *Internal) leaderStatus() *LeaderStatus {
+	i.leaderLock.RLock()
+	defer i.leaderLock.RUnlock()
+
+	return &LeaderStatus{
+		Enabled:  i.LeaderElectionEnabled,
+		Identity: i.leaderIdentity,
+		Leader:   i.leader,
+		IsLeader: i.leader == i.leaderIdentity,
+	}
+}
+
+// startTasks starts each of the background tasks.
+func startTasks(ctx context.Context, tasks []BackgroundTask) {
+	for _, task := range tasks {
+		task(ctx)
+	}
+}
+
+// RunBackgroundTasks starts the background tasks and blocks until the context
+// is cancelled. If leader election is enabled, the tasks only run while this
+// replica holds the Lease, and the Lease is released when the context is
+// cancelled so that another replica can take over right away. Without leader
+// election the tasks run in every replica.
+func (i *Internal) RunBackgroundTasks(ctx context.Context, tasks []BackgroundTask) {
+	if !i.LeaderElectionEnabled {
+		i.setLeader(i.leaderIdentity)
+		startTasks(ctx, tasks)
+		<-ctx.Done()
+		return
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      i.LeaseName,
+			Namespace: i.LeaseNamespace,
+		},
+		Client: i.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: i.leaderIdentity,
+		},
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            i.LeaseName,
+		LeaseDuration:   i.LeaseDuration,
+		RenewDeadline:   i.LeaseRenewDeadline,
+		RetryPeriod:     i.LeaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Infof("%s became the leader, starting background tasks", i.leaderIdentity)
+				startTasks(leaderCtx, tasks)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("%s is no longer the leader, background tasks stopped", i.leaderIdentity)
+				i.setLeader("")
+			},
+			OnNewLeader: func(identity string) {
+				log.Infof("the current leader is %s", identity)
+				i.setLeader(identity)
+			},
+		},
+	}
+
+	// RunOrDie returns when leadership is lost, so keep trying to get it back
+	// until the context is cancelled.
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+	}
+}
+
+// AdminLeaderHandler returns the state of the leader election as seen by the
+// replica handling the request.
+func (i *Internal) AdminLeaderHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, i.leaderStatus())
+}
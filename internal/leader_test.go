@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSleepContext(t *testing.T) {
+	assert.True(t, sleepContext(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, sleepContext(ctx, time.Hour), "a cancelled context should stop the sleep")
+}
+
+func TestRunBackgroundTasksWithoutElection(t *testing.T) {
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	internal.leaderIdentity = "replica-1"
+
+	started := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go internal.RunBackgroundTasks(ctx, []BackgroundTask{
+		func(ctx context.Context) { close(started) },
+	})
+
+	<-started
+	status := internal.leaderStatus()
+	assert.False(t, status.Enabled)
+	assert.True(t, status.IsLeader, "every replica should act as the leader")
+	cancel()
+}
+
+func TestRunBackgroundTasksWithElection(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	internal.leaderIdentity = "replica-1"
+	internal.LeaderElectionEnabled = true
+	internal.LeaseName = "app-exposer"
+	internal.LeaseNamespace = "de"
+	internal.LeaseDuration = 2 * time.Second
+	internal.LeaseRenewDeadline = time.Second
+	internal.LeaseRetryPeriod = 100 * time.Millisecond
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		internal.RunBackgroundTasks(ctx, []BackgroundTask{
+			func(ctx context.Context) {
+				close(started)
+				go func() {
+					<-ctx.Done()
+					close(stopped)
+				}()
+			},
+		})
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the background tasks were never started")
+	}
+
+	status := internal.leaderStatus()
+	assert.True(status.Enabled)
+	assert.Equal("replica-1", status.Identity)
+
+	lease, err := internal.clientset.CoordinationV1().Leases("de").Get("app-exposer", metav1.GetOptions{})
+	if assert.NoError(err, "the lease should be created") {
+		assert.Equal("replica-1", *lease.Spec.HolderIdentity)
+	}
+
+	cancel()
+	<-stopped
+	<-done
+
+	lease, err = internal.clientset.CoordinationV1().Leases("de").Get("app-exposer", metav1.GetOptions{})
+	if assert.NoError(err) {
+		assert.Equal("", *lease.Spec.HolderIdentity, "the lease should be released on shutdown")
+	}
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
@@ -8,8 +9,10 @@ import (
 
 	"github.com/cyverse-de/app-exposer/apps"
 	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/app-exposer/groups"
 	"github.com/pkg/errors"
 	"github.com/cyverse-de/model"
+	"github.com/lib/pq"
 	v1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -33,7 +36,17 @@ func shouldCountStatus(status string) bool {
 	return countIt
 }
 
-func (i *Internal) countJobsForUser(username string) (int, error) {
+func (i *Internal) countJobsForUser(ctx context.Context, username string) (int, error) {
+	deployments, err := i.countedDeploymentsForUser(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	return len(deployments), nil
+}
+
+// countedDeploymentsForUser returns the user's deployments that count against
+// their job limit and resource quota.
+func (i *Internal) countedDeploymentsForUser(ctx context.Context, username string) ([]v1.Deployment, error) {
 	set := labels.Set(map[string]string{
 		"username": username,
 	})
@@ -45,7 +58,7 @@ func (i *Internal) countJobsForUser(username string) (int, error) {
 	depclient := i.clientset.AppsV1().Deployments(i.ViceNamespace)
 	deplist, err := depclient.List(listoptions)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	countedDeployments := []v1.Deployment{}
@@ -57,6 +70,12 @@ func (i *Internal) countJobsForUser(username string) (int, error) {
 			ok                                     bool
 		)
 
+		// Suspended analyses have been scaled down to zero replicas, so they
+		// aren't using any resources and shouldn't count against the limit.
+		if isSuspended(&deployment) {
+			continue
+		}
+
 		labels := deployment.GetLabels()
 
 		// If we don't have the external-id on the deployment, count it.
@@ -65,7 +84,7 @@ func (i *Internal) countJobsForUser(username string) (int, error) {
 			continue
 		}
 
-		if analysisID, err = a.GetAnalysisIDByExternalID(externalID); err != nil {
+		if analysisID, err = a.GetAnalysisIDByExternalID(ctx, externalID); err != nil {
 			// If we failed to get it from the database, count it because it
 			// shouldn't be running.
 			log.Error(err)
@@ -73,7 +92,7 @@ func (i *Internal) countJobsForUser(username string) (int, error) {
 			continue
 		}
 
-		analysisStatus, err = a.GetAnalysisStatus(analysisID)
+		analysisStatus, err = a.GetAnalysisStatus(ctx, analysisID)
 		if err != nil {
 			// If we failed to get the status, then something is horribly wrong.
 			// Count the analysis.
@@ -91,7 +110,7 @@ func (i *Internal) countJobsForUser(username string) (int, error) {
 		}
 	}
 
-	return len(countedDeployments), nil
+	return countedDeployments, nil
 }
 
 const getJobLimitForUserSQL = `
@@ -99,9 +118,9 @@ const getJobLimitForUserSQL = `
 	WHERE launcher = regexp_replace($1, '-', '_')
 `
 
-func (i *Internal) getJobLimitForUser(username string) (*int, error) {
+func (i *Internal) getJobLimitForUser(ctx context.Context, username string) (*int, error) {
 	var jobLimit int
-	err := i.db.QueryRow(getJobLimitForUserSQL, username).Scan(&jobLimit)
+	err := i.db.QueryRowContext(ctx, getJobLimitForUserSQL, username).Scan(&jobLimit)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -111,20 +130,106 @@ func (i *Internal) getJobLimitForUser(username string) (*int, error) {
 	return &jobLimit, nil
 }
 
+const getGroupJobLimitsSQL = `
+	SELECT group_name, concurrent_jobs FROM vice_group_job_limits
+	WHERE group_name = ANY($1)
+`
+
+// The sources of job limits reported in the limit error details. Limits that
+// come from a group are reported as groupLimitSource followed by the group
+// name.
+const (
+	userLimitSource    = "user"
+	groupLimitSource   = "group:"
+	defaultLimitSource = "default"
+)
+
+// groupJobLimit is the concurrent job limit for the members of a group.
+type groupJobLimit struct {
+	GroupName      string `db:"group_name"`
+	ConcurrentJobs int    `db:"concurrent_jobs"`
+}
+
+// getGroupJobLimits returns the job limits for the groups the user belongs
+// to. The groups can't always be looked up, and the user still gets their own
+// or the default limit when that happens, so errors from the lookup are only
+// logged.
+func (i *Internal) getGroupJobLimits(ctx context.Context, username string) ([]groupJobLimit, error) {
+	if i.groupLookup == nil {
+		return nil, nil
+	}
+
+	groupNames, err := i.groupLookup.Groups(strings.TrimSuffix(username, i.UserSuffix))
+	if err != nil {
+		log.Error(errors.Wrapf(err, "unable to look up the groups for %s", username))
+		return nil, nil
+	}
+	if len(groupNames) == 0 {
+		return nil, nil
+	}
+
+	limits := []groupJobLimit{}
+	if err = i.db.SelectContext(ctx, &limits, getGroupJobLimitsSQL, pq.Array(groupNames)); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// newGroupLookup returns a lookup that uses the groups service if its URL is
+// configured, and the group members listed in the configuration otherwise.
+func newGroupLookup(init *Init) groups.Lookup {
+	if init.GroupsURL != "" {
+		return &groups.Groups{
+			BaseURL: init.GroupsURL,
+			User:    init.GroupsUser,
+			Timeout: init.GroupsTimeout,
+		}
+	}
+	return groups.NewLocal(init.GroupMembers)
+}
+
+// effectiveJobLimit returns the concurrent job limit that applies to a user,
+// along with where it came from. The most generous of the user's own limit, or
+// the default limit if they don't have one, and the limits for their groups
+// wins. Jobs that have been explicitly disabled for the user stay disabled.
+// Returns nil if the default limit applies.
+func effectiveJobLimit(userLimit *int, defaultJobLimit int, groupLimits []groupJobLimit) (*int, string) {
+	if userLimit != nil && *userLimit <= 0 {
+		return userLimit, userLimitSource
+	}
+
+	jobLimit, source, best := userLimit, userLimitSource, defaultJobLimit
+	if userLimit == nil {
+		source = defaultLimitSource
+	} else {
+		best = *userLimit
+	}
+
+	for idx := range groupLimits {
+		if groupLimits[idx].ConcurrentJobs > best {
+			best = groupLimits[idx].ConcurrentJobs
+			jobLimit = &groupLimits[idx].ConcurrentJobs
+			source = groupLimitSource + groupLimits[idx].GroupName
+		}
+	}
+
+	return jobLimit, source
+}
+
 const getDefaultJobLimitSQL = `
 	SELECT concurrent_jobs FROM job_limits
 	WHERE launcher IS NULL
 `
 
-func (i *Internal) getDefaultJobLimit() (int, error) {
+func (i *Internal) getDefaultJobLimit(ctx context.Context) (int, error) {
 	var defaultJobLimit int
-	if err := i.db.QueryRow(getDefaultJobLimitSQL).Scan(&defaultJobLimit); err != nil {
+	if err := i.db.QueryRowContext(ctx, getDefaultJobLimitSQL).Scan(&defaultJobLimit); err != nil {
 		return 0, err
 	}
 	return defaultJobLimit, nil
 }
 
-func buildLimitError(code, msg string, defaultJobLimit, jobCount int, jobLimit *int) error {
+func buildLimitError(code, msg string, defaultJobLimit, jobCount int, jobLimit *int, limitSource string) error {
 	return common.ErrorResponse{
 		ErrorCode: code,
 		Message:   msg,
@@ -132,36 +237,37 @@ func buildLimitError(code, msg string, defaultJobLimit, jobCount int, jobLimit *
 			"defaultJobLimit": defaultJobLimit,
 			"jobCount":        jobCount,
 			"jobLimit":        jobLimit,
+			"jobLimitSource":  limitSource,
 		},
 	}
 }
 
-func validateJobLimits(user string, defaultJobLimit, jobCount int, jobLimit *int) (int, error) {
+func validateJobLimits(user string, defaultJobLimit, jobCount int, jobLimit *int, limitSource string) (int, error) {
 	switch {
 
 	// Jobs are disabled by default and the user has not been granted permission yet.
 	case jobLimit == nil && defaultJobLimit <= 0:
 		code := "ERR_PERMISSION_NEEDED"
 		msg := fmt.Sprintf("%s has not been granted permission to run jobs yet", user)
-		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// Jobs have been explicitly disabled for the user.
 	case jobLimit != nil && *jobLimit <= 0:
 		code := "ERR_FORBIDDEN"
 		msg := fmt.Sprintf("%s is not permitted to run jobs", user)
-		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// The user is using and has reached the default job limit.
 	case jobLimit == nil && jobCount >= defaultJobLimit:
 		code := "ERR_LIMIT_REACHED"
 		msg := fmt.Sprintf("%s is already running %d or more concurrent jobs", user, defaultJobLimit)
-		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// The user has explicitly been granted the ability to run jobs and has reached the limit.
 	case jobLimit != nil && jobCount >= *jobLimit:
 		code := "ERR_LIMIT_REACHED"
 		msg := fmt.Sprintf("%s is already running %d or more concurrent jobs", user, *jobLimit)
-		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return http.StatusBadRequest, buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// In every other case, we can permit the job to be launched.
 	default:
@@ -169,29 +275,47 @@ func validateJobLimits(user string, defaultJobLimit, jobCount int, jobLimit *int
 	}
 }
 
-func (i *Internal) validateJob(job *model.Job) (int, error) {
+func (i *Internal) validateJob(ctx context.Context, job *model.Job) (int, error) {
 
 	// Verify that the job type is supported by this service
 	if strings.ToLower(job.ExecutionTarget) != "interapps" {
 		return http.StatusInternalServerError, fmt.Errorf("job type %s is not supported by this service", job.Type)
 	}
 
+	return i.validateUserJobLimits(ctx, job.Submitter, jobRequestedResources(job))
+}
+
+// validateUserJobLimits makes sure that the user may run another VICE analysis
+// requesting the given resources without exceeding their concurrent job limit
+// or their resource quota.
+func (i *Internal) validateUserJobLimits(ctx context.Context, user string, requested resourceTotals) (int, error) {
 	// Get the username
-	usernameLabelValue := labelValueString(job.Submitter)
-	user := job.Submitter
+	usernameLabelValue := labelValueString(user)
 
 	// Validate the number of concurrent jobs for the user.
-	jobCount, err := i.countJobsForUser(usernameLabelValue)
+	deployments, err := i.countedDeploymentsForUser(ctx, usernameLabelValue)
 	if err != nil {
 		return http.StatusInternalServerError, errors.Wrapf(err, "unable to determine the number of jobs that %s is currently running", user)
 	}
-	jobLimit, err := i.getJobLimitForUser(user)
+	jobCount := len(deployments)
+	userJobLimit, err := i.getJobLimitForUser(ctx, user)
 	if err != nil {
 		return http.StatusInternalServerError, errors.Wrapf(err, "unable to determine the concurrent job limit for %s", user)
 	}
-	defaultJobLimit, err := i.getDefaultJobLimit()
+	defaultJobLimit, err := i.getDefaultJobLimit(ctx)
 	if err != nil {
 		return http.StatusInternalServerError, errors.Wrapf(err, "unable to determine the default concurrent job limit")
 	}
-	return validateJobLimits(user, defaultJobLimit, jobCount, jobLimit)
+	groupJobLimits, err := i.getGroupJobLimits(ctx, user)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrapf(err, "unable to determine the group job limits for %s", user)
+	}
+	jobLimit, limitSource := effectiveJobLimit(userJobLimit, defaultJobLimit, groupJobLimits)
+
+	if status, err := validateJobLimits(user, defaultJobLimit, jobCount, jobLimit, limitSource); err != nil {
+		return status, err
+	}
+
+	// Validate the total resources requested by the user's analyses.
+	return i.validateUserResourceQuota(ctx, user, deployments, requested)
 }
@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/groups"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/cyverse-de/model"
 	v1 "k8s.io/api/apps/v1"
@@ -93,6 +96,17 @@ func registerDefaultLimitQuery(mock sqlmock.Sqlmock, limit int) {
 		WillReturnRows(rows)
 }
 
+// registerQuotaQueries registers the resource quota queries for a user who
+// has neither their own quota nor a default quota.
+func registerQuotaQueries(mock sqlmock.Sqlmock, username string) {
+	columns := []string{"cpu_cores", "memory_bytes", "gpus"}
+	mock.ExpectQuery("SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas WHERE launcher =").
+		WithArgs(username).
+		WillReturnRows(mock.NewRows(columns))
+	mock.ExpectQuery("SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas WHERE launcher IS NULL").
+		WillReturnRows(mock.NewRows(columns))
+}
+
 // registerAnalysisIDQuery registers the query to get the analysis ID for an external ID
 // if that an external ID is provided. If no external ID is provided then we assume that
 // no query should be performed.
@@ -123,31 +137,36 @@ func registerAnalysisStatusQuery(mock sqlmock.Sqlmock, analysisID, status *strin
 
 // expectedLimitError builds the expected error code for the given values.
 func expectedLimitError(user string, defaultJobLimit, jobCount int, jobLimit *int) error {
+	limitSource := defaultLimitSource
+	if jobLimit != nil {
+		limitSource = userLimitSource
+	}
+
 	switch {
 
 	// Jobs are disabled by default and the user has not been granted permission yet.
 	case jobLimit == nil && defaultJobLimit <= 0:
 		code := "ERR_PERMISSION_NEEDED"
 		msg := fmt.Sprintf("%s has not been granted permission to run jobs yet", user)
-		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// Jobs have been explicitly disabled for the user.
 	case jobLimit != nil && *jobLimit <= 0:
 		code := "ERR_FORBIDDEN"
 		msg := fmt.Sprintf("%s is not permitted to run jobs", user)
-		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// The user is using and has reached the default job limit.
 	case jobLimit == nil && jobCount >= defaultJobLimit:
 		code := "ERR_LIMIT_REACHED"
 		msg := fmt.Sprintf("%s is already running %d or more concurrent jobs", user, defaultJobLimit)
-		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// The user has explicitly been granted the ability to run jobs and has reached the limit.
 	case jobLimit != nil && jobCount >= *jobLimit:
 		code := "ERR_LIMIT_REACHED"
 		msg := fmt.Sprintf("%s is already running %d or more concurrent jobs", user, *jobLimit)
-		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit)
+		return buildLimitError(code, msg, defaultJobLimit, jobCount, jobLimit, limitSource)
 
 	// In every other case, we can permit the job to be launched.
 	default:
@@ -160,6 +179,7 @@ func createTestSubmission(username string) *model.Job {
 	return &model.Job{
 		ExecutionTarget: "interapps",
 		Submitter:       username,
+		Steps:           []model.Step{{}},
 	}
 }
 
@@ -294,9 +314,14 @@ func TestLimitChecks(t *testing.T) {
 			registerLimitQuery(mock, test.username, test.limit)
 			registerDefaultLimitQuery(mock, test.defaultLimit)
 
-			// Run the limit check.
-			status, err := internal.validateJob(createTestSubmission(test.username))
+			// The quotas are only looked up once the job limit check passes.
 			expectedError := expectedLimitError(test.username, test.defaultLimit, len(test.analyses), test.limit)
+			if expectedError == nil {
+				registerQuotaQueries(mock, test.username)
+			}
+
+			// Run the limit check.
+			status, err := internal.validateJob(context.Background(), createTestSubmission(test.username))
 			if expectedError == nil {
 				assert.Equalf(http.StatusOK, status, "the status code should be %d", http.StatusOK)
 				assert.NoError(err, "no error should be returned")
@@ -321,3 +346,67 @@ func TestLabelValueReplacement(t *testing.T) {
 	assert.Equal("u-u-u-xxx-foo_bar-xxx-u-u-u", labelValueString("___foo_bar___"))
 	assert.Equal("u-u-u-u-xxx-foo__bar-baz__quux-xxx-u-u-u-u", labelValueString("____foo__bar--baz__quux____"))
 }
+
+func TestEffectiveJobLimit(t *testing.T) {
+	groupLimits := []groupJobLimit{
+		{GroupName: "course", ConcurrentJobs: 3},
+		{GroupName: "lab", ConcurrentJobs: 10},
+	}
+
+	tests := []struct {
+		description  string
+		userLimit    *int
+		defaultLimit int
+		groupLimits  []groupJobLimit
+		limit        *int
+		source       string
+	}{
+		{"default limit", nil, 2, nil, nil, defaultLimitSource},
+		{"user limit", intPointer(5), 2, nil, intPointer(5), userLimitSource},
+		{"most generous group", nil, 2, groupLimits, intPointer(10), "group:lab"},
+		{"group more generous than the user", intPointer(5), 2, groupLimits, intPointer(10), "group:lab"},
+		{"user more generous than the groups", intPointer(20), 2, groupLimits, intPointer(20), userLimitSource},
+		{"default more generous than the groups", nil, 20, groupLimits, nil, defaultLimitSource},
+		{"group granting permission", nil, 0, groupLimits[:1], intPointer(3), "group:course"},
+		{"banned user", intPointer(0), 2, groupLimits, intPointer(0), userLimitSource},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			limit, source := effectiveJobLimit(test.userLimit, test.defaultLimit, test.groupLimits)
+			assert.Equal(t, test.limit, limit)
+			assert.Equal(t, test.source, source)
+		})
+	}
+}
+
+func TestGroupJobLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	objs := []runtime.Object{
+		viceDeployment(0, "vice-apps", "foo", nil),
+		viceDeployment(1, "vice-apps", "foo", nil),
+		viceDeployment(2, "vice-apps", "foo", nil),
+	}
+	internal, mock := setupInternal(t, objs)
+	defer internal.db.Close()
+	internal.groupLookup = groups.NewLocal([]groups.Membership{
+		{Name: "lab", Members: []string{"foo", "bar"}},
+		{Name: "course", Members: []string{"foo"}},
+		{Name: "other", Members: []string{"bar"}},
+	})
+
+	registerLimitQuery(mock, "foo", nil)
+	registerDefaultLimitQuery(mock, 2)
+	mock.ExpectQuery("SELECT group_name, concurrent_jobs FROM vice_group_job_limits").
+		WithArgs(pq.Array([]string{"course", "lab"})).
+		WillReturnRows(mock.NewRows([]string{"group_name", "concurrent_jobs"}).AddRow("lab", 3).AddRow("course", 1))
+
+	status, err := internal.validateJob(context.Background(), createTestSubmission("foo"))
+	assert.Equal(http.StatusBadRequest, status)
+	assert.Equal(
+		buildLimitError("ERR_LIMIT_REACHED", "foo is already running 3 or more concurrent jobs", 2, 3, intPointer(3), "group:lab"),
+		err,
+	)
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/apps"
+	"github.com/cyverse-de/app-exposer/tracing"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// readPermissionLevel is the permission level a user other than the one who
+// launched an analysis needs to read its archived logs.
+const readPermissionLevel = "read"
+
+// LogArchive contains the logs of an analysis captured before it was shut
+// down.
+type LogArchive struct {
+	AnalysisID  string         `json:"analysis_id" db:"analysis_id"`
+	ExternalID  string         `json:"external_id" db:"external_id"`
+	Reason      string         `json:"reason" db:"reason"`
+	Logs        types.JSONText `json:"logs" db:"logs"`
+	CreatedDate time.Time      `json:"created_date" db:"created_date"`
+}
+
+const insertLogArchiveSQL = `
+	INSERT INTO vice_log_archives (analysis_id, external_id, reason, logs)
+	VALUES ($1, $2, $3, $4)
+`
+
+const listLogArchivesSQL = `
+	SELECT analysis_id, external_id, reason, logs, created_date
+	  FROM vice_log_archives
+	 WHERE analysis_id = $1
+	 ORDER BY created_date
+`
+
+// collectedLogs is the result of collecting the logs of an analysis.
+type collectedLogs struct {
+	merged *MergedLogs
+	err    error
+}
+
+// collectLogsContext collects the logs like collectLogs, but gives up once the
+// context is done. The reads from the pods can't be cancelled, so they finish
+// in the background.
+func (i *Internal) collectLogsContext(ctx context.Context, externalID string, opts *apiv1.PodLogOptions) (*MergedLogs, error) {
+	done := make(chan collectedLogs, 1)
+	go func() {
+		merged, err := i.collectLogs(ctx, externalID, opts)
+		done <- collectedLogs{merged, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.merged, result.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "gave up collecting the logs for external-id %s", externalID)
+	}
+}
+
+// archiveLogs saves the merged logs of every container in the analysis's pods
+// in the database so that they're still available after the pods are deleted.
+// Failing to archive the logs doesn't stop the analysis from being shut down,
+// so errors are only logged, and the archive is abandoned after
+// LogArchiveTimeout so that it can't hold up the exit.
+func (i *Internal) archiveLogs(ctx context.Context, externalID, reason string) {
+	if i.LogArchiveTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.LogArchiveTimeout)
+		defer cancel()
+	}
+
+	ctx, span := tracing.Start(ctx, "archive logs", attribute.String("external_id", externalID))
+	logger := tracing.Logger(ctx)
+
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	a := apps.NewApps(i.db, i.UserSuffix)
+	analysisID, err := a.GetAnalysisIDByExternalID(ctx, externalID)
+	if err != nil {
+		err = errors.Wrapf(err, "error looking up the analysis ID for external-id %s", externalID)
+		logger.Error(err)
+		return
+	}
+
+	opts := &apiv1.PodLogOptions{}
+	if i.LogArchiveTailLines > 0 {
+		tailLines := i.LogArchiveTailLines
+		opts.TailLines = &tailLines
+	}
+
+	merged, err := i.collectLogsContext(ctx, externalID, opts)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	// There's nothing to keep if the pods are already gone.
+	if len(merged.Lines) == 0 && len(merged.Errors) == 0 {
+		return
+	}
+
+	archive, err := json.Marshal(merged)
+	if err != nil {
+		err = errors.Wrapf(err, "error encoding the logs for external-id %s", externalID)
+		logger.Error(err)
+		return
+	}
+
+	if _, err = i.db.ExecContext(ctx, insertLogArchiveSQL, analysisID, externalID, reason, types.JSONText(archive)); err != nil {
+		err = errors.Wrapf(err, "error archiving the logs for external-id %s", externalID)
+		logger.Error(err)
+		return
+	}
+
+	logger.Infof("archived %d log lines for external-id %s", len(merged.Lines), externalID)
+}
+
+// ArchivedLogsHandler returns the logs archived when the analysis was shut
+// down. The caller must have launched the analysis or have been granted at
+// least read access to it. Access is checked first so that callers can't find
+// out which analyses have archived logs.
+func (i *Internal) ArchivedLogsHandler(c echo.Context) error {
+	analysisID := c.Param("analysis-id")
+	if analysisID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
+	}
+
+	if err := i.checkAnalysisIDAccess(c, analysisID, readPermissionLevel); err != nil {
+		return err
+	}
+
+	archives := []LogArchive{}
+	if err := i.db.SelectContext(c.Request().Context(), &archives, listLogArchivesSQL, analysisID); err != nil {
+		return errors.Wrapf(err, "error listing the archived logs for analysis %s", analysisID)
+	}
+
+	if len(archives) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no archived logs found for analysis %s", analysisID))
+	}
+
+	return c.JSON(http.StatusOK, map[string][]LogArchive{"archives": archives})
+}
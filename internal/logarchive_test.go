@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func archivePod() *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    map[string]string{"external-id": "external-id", "app-type": "interactive"},
+		},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "analysis"}},
+		},
+	}
+}
+
+func TestDoExitArchivesLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	i, mock := setupInternal(t, []runtime.Object{archivePod()})
+	i.ArchiveLogs = true
+	i.LogArchiveTailLines = 100
+
+	var tailLines int64
+	i.podLogs = func(ctx context.Context, podName string, opts *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		tailLines = *opts.TailLines
+		return ioutil.NopCloser(strings.NewReader("2021-06-01T12:00:00Z killed\n")), nil
+	}
+
+	mock.ExpectQuery("SELECT j.id FROM jobs j JOIN job_steps s").
+		WithArgs("external-id").
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow("analysis-id"))
+	mock.ExpectExec("INSERT INTO vice_log_archives").
+		WithArgs("analysis-id", "external-id", timeLimitExitReason, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(i.doExit(context.Background(), "external-id", timeLimitExitReason))
+	assert.NoError(mock.ExpectationsWereMet())
+	assert.Equal(int64(100), tailLines)
+}
+
+func TestArchiveLogsWithoutPods(t *testing.T) {
+	i, mock := setupInternal(t, nil)
+	i.ArchiveLogs = true
+
+	mock.ExpectQuery("SELECT j.id FROM jobs j JOIN job_steps s").
+		WithArgs("external-id").
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow("analysis-id"))
+
+	i.archiveLogs(context.Background(), "external-id", userExitReason)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveLogsTimeout(t *testing.T) {
+	i, mock := setupInternal(t, []runtime.Object{archivePod()})
+	i.ArchiveLogs = true
+	i.LogArchiveTimeout = 10 * time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+	i.podLogs = func(ctx context.Context, podName string, opts *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		<-release
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	mock.ExpectQuery("SELECT j.id FROM jobs j JOIN job_steps s").
+		WithArgs("external-id").
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow("analysis-id"))
+
+	done := make(chan struct{})
+	go func() {
+		i.archiveLogs(context.Background(), "external-id", userExitReason)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("archiving the logs should give up after the timeout")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing should be archived")
+}
+
+func TestArchivedLogsHandlerForbidden(t *testing.T) {
+	server := permissionsServer("")
+	defer server.Close()
+
+	i, mock := setupInternal(t, nil)
+	i.PermissionsURL = server.URL
+	mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+
+	req := httptest.NewRequest(http.MethodGet, "/vice/analysis-id/logs/archived", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	auth.SetCurrentUser(c, &auth.User{Username: "other"})
+	c.SetParamNames("analysis-id")
+	c.SetParamValues("analysis-id")
+
+	err := i.ArchivedLogsHandler(c)
+	if assert.IsType(t, &echo.HTTPError{}, err) {
+		assert.Equal(t, http.StatusForbidden, err.(*echo.HTTPError).Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "the archives should not be listed")
+}
+
+func TestArchivedLogsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	logs, err := json.Marshal(&MergedLogs{Lines: []MergedLogLine{{Container: "analysis", Line: "killed"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, mock := setupInternal(t, nil)
+	mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+	mock.ExpectQuery("SELECT analysis_id, external_id, reason, logs, created_date FROM vice_log_archives").
+		WithArgs("analysis-id").
+		WillReturnRows(
+			mock.NewRows([]string{"analysis_id", "external_id", "reason", "logs", "created_date"}).
+				AddRow("analysis-id", "external-id", podFailureExitReason, logs, time.Now()),
+		)
+
+	req := httptest.NewRequest(http.MethodGet, "/vice/analysis-id/logs/archived", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	auth.SetCurrentUser(c, &auth.User{Username: "ipcdev"})
+	c.SetParamNames("analysis-id")
+	c.SetParamValues("analysis-id")
+
+	if assert.NoError(i.ArchivedLogsHandler(c)) {
+		assert.Equal(http.StatusOK, rec.Code)
+		assert.Contains(rec.Body.String(), `"reason":"pod-failure"`)
+		assert.Contains(rec.Body.String(), `"line":"killed"`)
+	}
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestArchivedLogsHandlerNotFound(t *testing.T) {
+	i, mock := setupInternal(t, nil)
+	mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("ipcdev@example.org", "user-id"))
+	mock.ExpectQuery("SELECT analysis_id, external_id, reason, logs, created_date FROM vice_log_archives").
+		WithArgs("analysis-id").
+		WillReturnRows(mock.NewRows([]string{"analysis_id", "external_id", "reason", "logs", "created_date"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/vice/analysis-id/logs/archived", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	auth.SetCurrentUser(c, &auth.User{Username: "ipcdev"})
+	c.SetParamNames("analysis-id")
+	c.SetParamValues("analysis-id")
+
+	err := i.ArchivedLogsHandler(c)
+	if assert.IsType(t, &echo.HTTPError{}, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -11,13 +13,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cyverse-de/app-exposer/metrics"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
 )
 
+// appsClient records the latency of requests to the apps service.
+var appsClient = metrics.NewClient("apps")
+
 // VICEStep contains information about an analysis step associated with a running
 // VICE job.
 type VICEStep struct {
@@ -54,7 +61,7 @@ func (i *Internal) getExternalIDs(user, analysisID string) ([]string, error) {
 	q.Set("user", user)
 	analysisLookupURL.RawQuery = q.Encode()
 
-	resp, err := http.Get(analysisLookupURL.String())
+	resp, err := appsClient.Get(analysisLookupURL.String())
 	if err != nil {
 		return nil, errors.Wrapf(err, "error from GET %s", analysisLookupURL.String())
 	}
@@ -103,50 +110,72 @@ type VICELogEntry struct {
 //   container - String containing the name of the container to display logs from. Defaults
 //               the value 'analysis', since this is VICE-specific.
 func (i *Internal) LogsHandler(c echo.Context) error {
-	var (
-		err        error
-		id         string
-		since      int64
-		sinceTime  int64
-		podName    string
-		container  string
-		previous   bool
-		tailLines  int64
-		timestamps bool
-		user       string
-		logOpts    *apiv1.PodLogOptions
-	)
-
 	// id is required
-	id = c.Param("analysis-id")
+	id := c.Param("analysis-id")
 	if id == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
 	}
 
 	// user is required
-	user = c.QueryParam("user")
+	user := i.callerUsername(c)
 	if user == "" {
 		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
 	}
 
-	externalIDs, err := i.getExternalIDs(user, id)
+	logOpts, err := logOptions(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
-	if len(externalIDs) < 1 {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("no external-ids found for analysis-id %s", id))
+	// follow needs to be false for now since upstream services end up using a full thread to process
+	// a stream of updates
+	logOpts.Follow = false
+
+	podName, err := i.analysisPodName(user, id)
+	if err != nil {
+		return err
 	}
 
-	//Just use the first external-id for now.
-	externalID := externalIDs[0]
+	// Finally, actually get the logs and write the response out
+	logReadCloser, err := i.podLogs(c.Request().Context(), podName, logOpts)
+	if err != nil {
+		return err
+	}
+	defer logReadCloser.Close()
+
+	bodyBytes, err := ioutil.ReadAll(logReadCloser)
+	if err != nil {
+		return err
+	}
+
+	bodyLines := strings.Split(string(bodyBytes), "\n")
+	newSinceTime := fmt.Sprintf("%d", time.Now().Unix())
+
+	return c.JSON(http.StatusOK, &VICELogEntry{
+		SinceTime: newSinceTime,
+		Lines:     bodyLines,
+	})
+
+}
+
+// logOptions returns the pod log options in the query parameters accepted by
+// LogsHandler.
+func logOptions(c echo.Context) (*apiv1.PodLogOptions, error) {
+	var (
+		err        error
+		since      int64
+		sinceTime  int64
+		previous   bool
+		tailLines  int64
+		timestamps bool
+	)
 
-	logOpts = &apiv1.PodLogOptions{}
+	logOpts := &apiv1.PodLogOptions{}
 
 	// previous is optional
 	if c.QueryParam("previous") != "" {
 		if previous, err = strconv.ParseBool(c.QueryParam("previous")); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
 		logOpts.Previous = previous
@@ -155,7 +184,7 @@ func (i *Internal) LogsHandler(c echo.Context) error {
 	// since is optional
 	if c.QueryParam("since") != "" {
 		if since, err = strconv.ParseInt(c.QueryParam("since"), 10, 64); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
 		logOpts.SinceSeconds = &since
@@ -163,7 +192,7 @@ func (i *Internal) LogsHandler(c echo.Context) error {
 
 	if c.QueryParam("since-time") != "" {
 		if sinceTime, err = strconv.ParseInt(c.QueryParam("since-time"), 10, 64); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
 		convertedSinceTime := metav1.Unix(sinceTime, 0)
@@ -173,20 +202,16 @@ func (i *Internal) LogsHandler(c echo.Context) error {
 	// tail-lines is optional
 	if c.QueryParam("tail-lines") != "" {
 		if tailLines, err = strconv.ParseInt(c.QueryParam("tail-lines"), 10, 64); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
 		logOpts.TailLines = &tailLines
 	}
 
-	// follow needs to be false for now since upstream services end up using a full thread to process
-	// a stream of updates
-	logOpts.Follow = false
-
 	// timestamps is optional
 	if c.QueryParam("timestamps") != "" {
 		if timestamps, err = strconv.ParseBool(c.QueryParam("timestamps")); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
 		logOpts.Timestamps = timestamps
@@ -194,51 +219,62 @@ func (i *Internal) LogsHandler(c echo.Context) error {
 
 	// container is optional, but should have a default value of "analysis"
 	if c.QueryParam("container") != "" {
-		container = c.QueryParam("container")
+		logOpts.Container = c.QueryParam("container")
 	} else {
-		container = "analysis"
+		logOpts.Container = "analysis"
 	}
 
-	logOpts.Container = container
+	return logOpts, nil
+}
 
-	// We're getting a list of pods associated with the first external-id for the analysis,
-	// but we're only going to use the first pod for now.
-	podList, err := i.getPods(externalID)
+// analysisExternalID returns the external ID of the analysis, checking with the
+// apps service that the user can see the analysis.
+func (i *Internal) analysisExternalID(user, analysisID string) (string, error) {
+	externalIDs, err := i.getExternalIDs(user, analysisID)
 	if err != nil {
-		return err
+		return "", echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	if len(podList) < 1 {
-		return fmt.Errorf("no pods found for analysis %s with external ID %s", id, externalID)
+	if len(externalIDs) < 1 {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("no external-ids found for analysis-id %s", analysisID))
 	}
 
-	podName = podList[0].Name
+	//Just use the first external-id for now.
+	return externalIDs[0], nil
+}
 
-	// Finally, actually get the logs and write the response out
-	podLogs := i.clientset.CoreV1().Pods(i.ViceNamespace).GetLogs(podName, logOpts)
+// analysisPodName returns the name of the pod for the analysis, checking with
+// the apps service that the user can see the analysis.
+func (i *Internal) analysisPodName(user, analysisID string) (string, error) {
+	externalID, err := i.analysisExternalID(user, analysisID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	logReadCloser, err := podLogs.Stream()
+	// We're getting a list of pods associated with the first external-id for the analysis,
+	// but we're only going to use the first pod for now.
+	podList, err := i.getPods(externalID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer logReadCloser.Close()
 
-	bodyBytes, err := ioutil.ReadAll(logReadCloser)
-	if err != nil {
-		return err
+	if len(podList) < 1 {
+		return "", fmt.Errorf("no pods found for analysis %s with external ID %s", analysisID, externalID)
 	}
 
-	bodyLines := strings.Split(string(bodyBytes), "\n")
-	newSinceTime := fmt.Sprintf("%d", time.Now().Unix())
+	return podList[0].Name, nil
+}
 
-	return c.JSON(http.StatusOK, &VICELogEntry{
-		SinceTime: newSinceTime,
-		Lines:     bodyLines,
-	})
+// logStreamer opens a stream of the log of a container in a pod. Cancelling
+// the context closes the stream, even before its first byte arrives.
+type logStreamer func(ctx context.Context, podName string, opts *apiv1.PodLogOptions) (io.ReadCloser, error)
 
+// kubernetesLogStreamer returns a logStreamer that reads logs from the pods in
+// the namespace.
+func kubernetesLogStreamer(clientset kubernetes.Interface, namespace string) logStreamer {
+	return func(ctx context.Context, podName string, opts *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		return clientset.CoreV1().Pods(namespace).GetLogs(podName, opts).Context(ctx).Stream()
+	}
 }
 
 // Contains information about pods returned by the VICEPods handler.
@@ -273,7 +309,7 @@ func (i *Internal) getPods(externalID string) ([]retPod, error) {
 // just returns pod info in the format `{"pods" : [{}]}`
 func (i *Internal) PodsHandler(c echo.Context) error {
 	analysisID := c.Param("analysis-id")
-	user := c.QueryParam("user")
+	user := i.callerUsername(c)
 
 	if user == "" {
 		return echo.NewHTTPError(http.StatusForbidden, "user not set")
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// acquireLogStream counts a new log stream for the user, returning false if
+// the user already has as many open as they're allowed.
+func (i *Internal) acquireLogStream(user string) bool {
+	i.logStreamsLock.Lock()
+	defer i.logStreamsLock.Unlock()
+
+	if i.MaxLogStreamsPerUser > 0 && i.logStreams[user] >= i.MaxLogStreamsPerUser {
+		return false
+	}
+	i.logStreams[user]++
+	return true
+}
+
+// releaseLogStream stops counting one of the user's log streams.
+func (i *Internal) releaseLogStream(user string) {
+	i.logStreamsLock.Lock()
+	defer i.logStreamsLock.Unlock()
+
+	if i.logStreams[user] <= 1 {
+		delete(i.logStreams, user)
+		return
+	}
+	i.logStreams[user]--
+}
+
+// writeEvent writes a Server-Sent Event to the response and flushes it. The
+// event type is left out if it's blank, which makes it a message event.
+func writeEvent(resp *echo.Response, event, data string) {
+	if event != "" {
+		fmt.Fprintf(resp, "event: %s\n", event)
+	}
+	fmt.Fprintf(resp, "data: %s\n\n", data)
+	resp.Flush()
+}
+
+// endLogStream tells the caller why the stream is ending after the context is
+// done. Nothing is sent if the caller disconnected.
+func endLogStream(ctx context.Context, resp *echo.Response) {
+	if ctx.Err() == context.DeadlineExceeded {
+		writeEvent(resp, "end", "the log stream has been open for too long")
+	}
+}
+
+// readLogLines sends each line read from r on lines until r is exhausted, an
+// error occurs, or the context is cancelled, then sends the error, if any, on
+// done.
+func readLogLines(ctx context.Context, r io.Reader, lines chan<- string, done chan<- error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			select {
+			case lines <- strings.TrimRight(line, "\r\n"):
+			case <-ctx.Done():
+				done <- nil
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			done <- err
+			return
+		}
+	}
+}
+
+// LogsStreamHandler follows the log of a container in the analysis's pod and
+// sends each line to the caller as a Server-Sent Event. Accepts the same query
+// parameters as LogsHandler. A comment is sent every LogStreamHeartbeat so that
+// proxies don't close an idle connection, and an end event is sent when the
+// log ends, which happens when the pod is deleted, or when the stream has been
+// open for MaxLogStreamDuration. The stream is closed when the caller
+// disconnects.
+func (i *Internal) LogsStreamHandler(c echo.Context) error {
+	id := c.Param("analysis-id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
+	}
+
+	user := i.callerUsername(c)
+	if user == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
+	}
+
+	logOpts, err := logOptions(c)
+	if err != nil {
+		return err
+	}
+	logOpts.Follow = true
+
+	podName, err := i.analysisPodName(user, id)
+	if err != nil {
+		return err
+	}
+
+	if !i.acquireLogStream(user) {
+		return echo.NewHTTPError(
+			http.StatusTooManyRequests,
+			fmt.Sprintf("user %s already has %d log streams open", user, i.MaxLogStreamsPerUser),
+		)
+	}
+	defer i.releaseLogStream(user)
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	if i.MaxLogStreamDuration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, i.MaxLogStreamDuration)
+		defer cancel()
+	}
+
+	stream, err := i.podLogs(ctx, podName, logOpts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// Reads from the stream don't take a context, so closing it is the surest
+	// way to interrupt them.
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go readLogLines(ctx, stream, lines, done)
+
+	var heartbeat <-chan time.Time
+	if i.LogStreamHeartbeat > 0 {
+		ticker := time.NewTicker(i.LogStreamHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case line := <-lines:
+			writeEvent(resp, "", line)
+
+		case <-heartbeat:
+			fmt.Fprint(resp, ": heartbeat\n\n")
+			resp.Flush()
+
+		case err := <-done:
+			// Closing the stream when the context is done ends the read too,
+			// so the context decides why the stream ended.
+			if ctx.Err() != nil {
+				endLogStream(ctx, resp)
+			} else if err != nil {
+				log.Error(err)
+				writeEvent(resp, "end", err.Error())
+			} else {
+				writeEvent(resp, "end", "the log has ended")
+			}
+			return nil
+
+		case <-ctx.Done():
+			endLogStream(ctx, resp)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// setupLogStream returns an *Internal with a single pod for the analysis and
+// a fake apps service that returns its external ID.
+func setupLogStream(t *testing.T, logs logStreamer) (*Internal, func()) {
+	apps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"analysis_id": "analysis-id", "steps": [{"external_id": "external-id"}]}`)
+	}))
+
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    map[string]string{"external-id": "external-id"},
+		},
+	}
+
+	i, _ := setupInternal(t, []runtime.Object{pod})
+	i.AppsServiceBaseURL = apps.URL
+	i.podLogs = logs
+
+	return i, apps.Close
+}
+
+func streamRequest(ctx context.Context, i *Internal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/vice/analysis-id/logs/stream", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	auth.SetCurrentUser(c, &auth.User{Username: "ipcdev"})
+	c.SetParamNames("analysis-id")
+	c.SetParamValues("analysis-id")
+
+	if err := i.LogsStreamHandler(c); err != nil {
+		httpErr := err.(*echo.HTTPError)
+		rec.Code = httpErr.Code
+	}
+
+	return rec
+}
+
+func TestLogsStreamHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var opts *apiv1.PodLogOptions
+	i, cleanup := setupLogStream(t, func(ctx context.Context, podName string, o *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		opts = o
+		return ioutil.NopCloser(strings.NewReader("line one\nline two\n")), nil
+	})
+	defer cleanup()
+
+	rec := streamRequest(context.Background(), i)
+
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("text/event-stream", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal("data: line one\n\ndata: line two\n\nevent: end\ndata: the log has ended\n\n", rec.Body.String())
+	assert.True(opts.Follow)
+	assert.Equal("analysis", opts.Container)
+	assert.Empty(i.logStreams)
+}
+
+func TestLogsStreamHandlerDisconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	reader, writer := io.Pipe()
+	i, cleanup := setupLogStream(t, func(context.Context, string, *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		return reader, nil
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		fmt.Fprintln(writer, "line one")
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	finished := make(chan *httptest.ResponseRecorder)
+	go func() { finished <- streamRequest(ctx, i) }()
+
+	select {
+	case rec := <-finished:
+		assert.Contains(rec.Body.String(), "data: line one\n\n")
+		assert.NotContains(rec.Body.String(), "event: end")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream was not closed when the client disconnected")
+	}
+
+	// Writing to the pipe fails once the handler has closed the log stream.
+	_, err := writer.Write([]byte("line two\n"))
+	assert.Error(err)
+	assert.Empty(i.logStreams)
+}
+
+func TestLogsStreamHandlerLimit(t *testing.T) {
+	i, cleanup := setupLogStream(t, func(context.Context, string, *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	})
+	defer cleanup()
+
+	i.MaxLogStreamsPerUser = 1
+	assert.True(t, i.acquireLogStream("ipcdev"))
+
+	rec := streamRequest(context.Background(), i)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	i.releaseLogStream("ipcdev")
+	rec = streamRequest(context.Background(), i)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLogsStreamHandlerMaxDuration(t *testing.T) {
+	reader, _ := io.Pipe()
+	i, cleanup := setupLogStream(t, func(context.Context, string, *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		return reader, nil
+	})
+	defer cleanup()
+
+	i.MaxLogStreamDuration = 50 * time.Millisecond
+	i.LogStreamHeartbeat = 10 * time.Millisecond
+
+	rec := streamRequest(context.Background(), i)
+	assert.Contains(t, rec.Body.String(), ": heartbeat\n\n")
+	assert.Contains(t, rec.Body.String(), "event: end\ndata: the log stream has been open for too long\n\n")
+}
+
+func TestLogsStreamHandlerSlowStart(t *testing.T) {
+	// The pod never sends its first byte, so the stream only opens once the
+	// context is done.
+	i, cleanup := setupLogStream(t, func(ctx context.Context, _ string, _ *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		<-ctx.Done()
+		return nil, echo.NewHTTPError(http.StatusGatewayTimeout, ctx.Err().Error())
+	})
+	defer cleanup()
+
+	i.MaxLogStreamDuration = 50 * time.Millisecond
+
+	finished := make(chan struct{})
+	go func() {
+		streamRequest(context.Background(), i)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		assert.Empty(t, i.logStreams, "the stream slot should be released")
+	case <-time.After(5 * time.Second):
+		t.Fatal("opening the stream was not cancelled")
+	}
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// MergedLogLine is a line from the log of one of the containers in an
+// analysis's pods.
+type MergedLogLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Previous  bool      `json:"previous"`
+	Line      string    `json:"line"`
+}
+
+// MergedLogs contains the logs of every container in an analysis's pods,
+// merged into a single timeline.
+type MergedLogs struct {
+	SinceTime string          `json:"since_time"`
+	Lines     []MergedLogLine `json:"lines"`
+
+	// Errors contains the errors encountered reading the logs, keyed by pod
+	// and container. A container that hasn't started yet has no log to read,
+	// so these don't fail the whole request.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// logSource is a single container log to read.
+type logSource struct {
+	pod       string
+	container string
+	previous  bool
+}
+
+// logSources returns the logs to read for the pod: one for each init container
+// and container, plus one for the previous instance of every container that
+// has restarted.
+func logSources(pod *apiv1.Pod) []logSource {
+	restarted := map[string]bool{}
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.RestartCount > 0 {
+			restarted[status.Name] = true
+		}
+	}
+
+	var sources []logSource
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if restarted[container.Name] {
+			sources = append(sources, logSource{pod: pod.Name, container: container.Name, previous: true})
+		}
+		sources = append(sources, logSource{pod: pod.Name, container: container.Name})
+	}
+
+	return sources
+}
+
+// parseLogLine splits a log line requested with timestamps into its timestamp
+// and the rest of the line. The zero time is returned if the line doesn't
+// start with a timestamp.
+func parseLogLine(line string) (time.Time, string) {
+	fields := strings.SplitN(line, " ", 2)
+	timestamp, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return time.Time{}, line
+	}
+	if len(fields) < 2 {
+		return timestamp, ""
+	}
+	return timestamp, fields[1]
+}
+
+// readLogSource reads the log for a single container. Lines without a
+// timestamp are given the timestamp of the line before them so that they stay
+// in place when the logs are merged.
+func (i *Internal) readLogSource(ctx context.Context, source logSource, opts *apiv1.PodLogOptions) ([]MergedLogLine, error) {
+	containerOpts := opts.DeepCopy()
+	containerOpts.Container = source.container
+	containerOpts.Previous = source.previous
+	containerOpts.Timestamps = true
+	containerOpts.Follow = false
+
+	stream, err := i.podLogs(ctx, source.pod, containerOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var (
+		lines []MergedLogLine
+		last  time.Time
+	)
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		timestamp, line := parseLogLine(scanner.Text())
+		if timestamp.IsZero() {
+			timestamp = last
+		}
+		last = timestamp
+
+		lines = append(lines, MergedLogLine{
+			Timestamp: timestamp,
+			Pod:       source.pod,
+			Container: source.container,
+			Previous:  source.previous,
+			Line:      line,
+		})
+	}
+
+	return lines, scanner.Err()
+}
+
+// collectLogs reads the logs of every container in the pods for the analysis
+// with the external ID, including the previous instances of containers that
+// have restarted, and merges them by timestamp. The container and previous
+// fields of the options are ignored.
+func (i *Internal) collectLogs(ctx context.Context, externalID string, opts *apiv1.PodLogOptions) (*MergedLogs, error) {
+	pods, err := i.podList(i.ViceNamespace, map[string]string{"external-id": externalID}, []string{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing pods for external-id %s", externalID)
+	}
+
+	merged := &MergedLogs{
+		SinceTime: fmt.Sprintf("%d", time.Now().Unix()),
+		Lines:     []MergedLogLine{},
+	}
+
+	for _, pod := range pods.Items {
+		for _, source := range logSources(&pod) {
+			lines, err := i.readLogSource(ctx, source, opts)
+			if err != nil {
+				if merged.Errors == nil {
+					merged.Errors = map[string]string{}
+				}
+				key := source.pod + "/" + source.container
+				if source.previous {
+					key += " (previous)"
+				}
+				merged.Errors[key] = err.Error()
+			}
+			merged.Lines = append(merged.Lines, lines...)
+		}
+	}
+
+	sort.SliceStable(merged.Lines, func(a, b int) bool {
+		return merged.Lines[a].Timestamp.Before(merged.Lines[b].Timestamp)
+	})
+
+	return merged, nil
+}
+
+// MergedLogsHandler returns the logs of every container in the analysis's pods,
+// including the init containers and the previous instances of containers that
+// have restarted, merged into a single timeline. Each line is tagged with the
+// container it came from. Accepts the since, since-time and tail-lines query
+// parameters accepted by LogsHandler, which apply to each container's log.
+func (i *Internal) MergedLogsHandler(c echo.Context) error {
+	id := c.Param("analysis-id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
+	}
+
+	user := i.callerUsername(c)
+	if user == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
+	}
+
+	logOpts, err := logOptions(c)
+	if err != nil {
+		return err
+	}
+
+	externalID, err := i.analysisExternalID(user, id)
+	if err != nil {
+		return err
+	}
+
+	merged, err := i.collectLogs(c.Request().Context(), externalID, logOpts)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, merged)
+}
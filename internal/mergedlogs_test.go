@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestParseLogLine(t *testing.T) {
+	timestamp, line := parseLogLine("2021-06-01T12:00:00.123456789Z starting jupyter")
+	assert.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC), timestamp)
+	assert.Equal(t, "starting jupyter", line)
+
+	timestamp, line = parseLogLine("  at line 2")
+	assert.True(t, timestamp.IsZero())
+	assert.Equal(t, "  at line 2", line)
+}
+
+func TestCollectLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    map[string]string{"external-id": "external-id", "app-type": "interactive"},
+		},
+		Spec: apiv1.PodSpec{
+			InitContainers: []apiv1.Container{{Name: "input-files"}},
+			Containers:     []apiv1.Container{{Name: "vice-proxy"}, {Name: "analysis"}},
+		},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "vice-proxy"},
+				{Name: "analysis", RestartCount: 1},
+			},
+		},
+	}
+
+	logs := map[string]string{
+		"input-files": "2021-06-01T12:00:01Z downloading inputs\n",
+		"vice-proxy":  "2021-06-01T12:00:03Z proxying\n2021-06-01T12:00:05Z request\n",
+		"analysis (previous)": "2021-06-01T12:00:02Z starting\n" +
+			"2021-06-01T12:00:02.5Z panic: out of memory\n" +
+			"  goroutine 1\n",
+	}
+
+	i, _ := setupInternal(t, []runtime.Object{pod})
+	i.podLogs = func(ctx context.Context, podName string, opts *apiv1.PodLogOptions) (io.ReadCloser, error) {
+		assert.True(opts.Timestamps)
+		key := opts.Container
+		if opts.Previous {
+			key += " (previous)"
+		}
+		content, ok := logs[key]
+		if !ok {
+			return nil, errors.New("container is waiting to start")
+		}
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	}
+
+	merged, err := i.collectLogs(context.Background(), "external-id", &apiv1.PodLogOptions{Container: "analysis"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	var summary []string
+	for _, line := range merged.Lines {
+		summary = append(summary, line.Container+": "+line.Line)
+	}
+	assert.Equal([]string{
+		"input-files: downloading inputs",
+		"analysis: starting",
+		"analysis: panic: out of memory",
+		"analysis:   goroutine 1",
+		"vice-proxy: proxying",
+		"vice-proxy: request",
+	}, summary)
+	assert.True(merged.Lines[1].Previous)
+	assert.Equal(map[string]string{"analysis-pod/analysis": "container is waiting to start"}, merged.Errors)
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cyverse-de/messaging"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// OutboxPublisher is an implementation of AnalysisStatusPublisher that writes
+// status updates to the vice_status_outbox table instead of sending them right
+// away. The updates are delivered by DeliverStatusUpdates, which retries them
+// until they go through, so a job-status-listener outage doesn't lose them.
+type OutboxPublisher struct {
+	db *sqlx.DB
+}
+
+// OutboxMessage is a status update stored in the outbox.
+type OutboxMessage struct {
+	ID            int64       `json:"id" db:"id"`
+	ExternalID    string      `json:"externalID" db:"external_id"`
+	State         string      `json:"state" db:"state"`
+	Message       string      `json:"message" db:"message"`
+	Host          string      `json:"host" db:"host"`
+	CreatedDate   time.Time   `json:"createdDate" db:"created_date"`
+	Attempts      int         `json:"attempts" db:"attempts"`
+	NextAttempt   pq.NullTime `json:"-" db:"next_attempt"`
+	LastError     string      `json:"lastError" db:"last_error"`
+	DeliveredDate pq.NullTime `json:"-" db:"delivered_date"`
+}
+
+// New messages are ready to be delivered right away.
+const enqueueStatusSQL = `
+	INSERT INTO vice_status_outbox (external_id, state, message, host, next_attempt)
+	VALUES ($1, $2, $3, $4, now())
+`
+
+// Messages are delivered in order for each analysis, so a message isn't ready
+// until every earlier message for the same analysis has been delivered.
+const readyStatusUpdatesSQL = `
+	SELECT o.id, o.external_id, o.state, o.message, o.host, o.created_date,
+	       o.attempts, o.next_attempt, coalesce(o.last_error, '') AS last_error,
+	       o.delivered_date
+	  FROM vice_status_outbox o
+	 WHERE o.delivered_date IS NULL
+	   AND o.next_attempt <= now()
+	   AND NOT EXISTS (
+	       SELECT 1
+	         FROM vice_status_outbox p
+	        WHERE p.external_id = o.external_id
+	          AND p.delivered_date IS NULL
+	          AND p.id < o.id
+	       )
+	 ORDER BY o.id
+	 LIMIT $1
+`
+
+const markStatusDeliveredSQL = `
+	UPDATE vice_status_outbox
+	   SET delivered_date = now(),
+	       attempts = attempts + 1,
+	       last_error = NULL
+	 WHERE id = $1
+`
+
+const markStatusFailedSQL = `
+	UPDATE vice_status_outbox
+	   SET attempts = attempts + 1,
+	       last_error = $2,
+	       next_attempt = now() + $3 * interval '1 second'
+	 WHERE id = $1
+`
+
+// A NULL next_attempt means the message has run out of attempts and is
+// waiting to be replayed.
+const markStatusStuckSQL = `
+	UPDATE vice_status_outbox
+	   SET attempts = attempts + 1,
+	       last_error = $2,
+	       next_attempt = NULL
+	 WHERE id = $1
+`
+
+const purgeDeliveredStatusUpdatesSQL = `
+	DELETE FROM vice_status_outbox
+	 WHERE delivered_date < now() - $1 * interval '1 second'
+`
+
+const listUndeliveredStatusUpdatesSQL = `
+	SELECT id, external_id, state, message, host, created_date, attempts,
+	       next_attempt, coalesce(last_error, '') AS last_error, delivered_date
+	  FROM vice_status_outbox
+	 WHERE delivered_date IS NULL
+	 ORDER BY id
+`
+
+const listStuckStatusUpdatesSQL = `
+	SELECT id, external_id, state, message, host, created_date, attempts,
+	       next_attempt, coalesce(last_error, '') AS last_error, delivered_date
+	  FROM vice_status_outbox
+	 WHERE delivered_date IS NULL
+	   AND next_attempt IS NULL
+	 ORDER BY id
+`
+
+const replayStatusUpdateSQL = `
+	UPDATE vice_status_outbox
+	   SET attempts = 0,
+	       next_attempt = now()
+	 WHERE id = $1
+	   AND delivered_date IS NULL
+`
+
+const replayStuckStatusUpdatesSQL = `
+	UPDATE vice_status_outbox
+	   SET attempts = 0,
+	       next_attempt = now()
+	 WHERE delivered_date IS NULL
+	   AND next_attempt IS NULL
+`
+
+func (o *OutboxPublisher) enqueue(jobID, msg string, jobState messaging.JobState) error {
+	if _, err := o.db.Exec(enqueueStatusSQL, jobID, string(jobState), msg, hostname()); err != nil {
+		return errors.Wrapf(err, "error adding %s status for external-id %s to the outbox", jobState, jobID)
+	}
+	return nil
+}
+
+// Fail adds an analysis failure update to the outbox. Should be sent once.
+func (o *OutboxPublisher) Fail(jobID, msg string) error {
+	log.Warnf("Queueing failure job status update for external-id %s", jobID)
+	return o.enqueue(jobID, msg, messaging.FailedState)
+}
+
+// Success adds an analysis success update to the outbox. Should be sent once.
+func (o *OutboxPublisher) Success(jobID, msg string) error {
+	log.Warnf("Queueing success job status update for external-id %s", jobID)
+	return o.enqueue(jobID, msg, messaging.SucceededState)
+}
+
+// Running adds an analysis running update to the outbox. May be sent multiple
+// times, preferably with different messages.
+func (o *OutboxPublisher) Running(jobID, msg string) error {
+	log.Warnf("Queueing running job status update for external-id %s", jobID)
+	return o.enqueue(jobID, msg, messaging.RunningState)
+}
+
+// outboxBackoff returns how long to wait before the next delivery attempt for
+// a message that has already failed the given number of times.
+func outboxBackoff(attempts int, initial, max time.Duration) time.Duration {
+	backoff := float64(initial) * math.Pow(2, float64(attempts))
+	if backoff > float64(max) {
+		return max
+	}
+	return time.Duration(backoff)
+}
+
+// deliverStatusUpdate sends a single message from the outbox, recording the
+// outcome. Returns false if the message couldn't be delivered.
+func (i *Internal) deliverStatusUpdate(m *OutboxMessage) (bool, error) {
+	status := &AnalysisStatus{
+		Host:    m.Host,
+		State:   messaging.JobState(m.State),
+		Message: m.Message,
+	}
+
+	deliveryErr := i.statusDeliverer.Deliver(m.ExternalID, status)
+	if deliveryErr == nil {
+		if _, err := i.db.Exec(markStatusDeliveredSQL, m.ID); err != nil {
+			return false, errors.Wrapf(err, "error marking status update %d as delivered", m.ID)
+		}
+		return true, nil
+	}
+
+	log.Error(errors.Wrapf(deliveryErr, "error delivering status update %d for external-id %s", m.ID, m.ExternalID))
+
+	var err error
+	if i.OutboxMaxAttempts > 0 && m.Attempts+1 >= i.OutboxMaxAttempts {
+		log.Errorf("giving up on status update %d for external-id %s after %d attempts", m.ID, m.ExternalID, m.Attempts+1)
+		_, err = i.db.Exec(markStatusStuckSQL, m.ID, deliveryErr.Error())
+	} else {
+		backoff := outboxBackoff(m.Attempts, i.OutboxInitialBackoff, i.OutboxMaxBackoff)
+		_, err = i.db.Exec(markStatusFailedSQL, m.ID, deliveryErr.Error(), backoff.Seconds())
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error recording the failed delivery of status update %d", m.ID)
+	}
+
+	return false, nil
+}
+
+// deliverStatusUpdates sends the messages in the outbox that are ready to go.
+// Only the oldest undelivered message for each analysis is ready at a time, so
+// this keeps going until a pass doesn't deliver anything.
+func (i *Internal) deliverStatusUpdates() error {
+	for {
+		messages := []OutboxMessage{}
+		if err := i.db.Select(&messages, readyStatusUpdatesSQL, i.OutboxBatchSize); err != nil {
+			return errors.Wrap(err, "error reading status updates from the outbox")
+		}
+
+		delivered := 0
+		for idx := range messages {
+			ok, err := i.deliverStatusUpdate(&messages[idx])
+			if err != nil {
+				log.Error(err)
+			}
+			if ok {
+				delivered++
+			}
+		}
+
+		if delivered == 0 {
+			break
+		}
+	}
+
+	if _, err := i.db.Exec(purgeDeliveredStatusUpdatesSQL, i.OutboxRetention.Seconds()); err != nil {
+		return errors.Wrap(err, "error removing delivered status updates from the outbox")
+	}
+
+	return nil
+}
+
+// DeliverStatusUpdates fires up a goroutine that delivers the status updates in
+// the outbox, retrying failed deliveries with exponential backoff. It should
+// only run in one replica so that the updates for each analysis arrive in
+// order. The goroutine exits when the context is cancelled.
+func (i *Internal) DeliverStatusUpdates(ctx context.Context) {
+	go func() {
+		for {
+			if err := i.deliverStatusUpdates(); err != nil {
+				log.Error(err)
+			}
+			if !sleepContext(ctx, i.OutboxPollInterval) {
+				return
+			}
+		}
+	}()
+}
+
+// AdminListOutboxHandler lists the status updates that haven't been delivered
+// yet. Pass stuck=true to only list the ones that have run out of attempts.
+func (i *Internal) AdminListOutboxHandler(c echo.Context) error {
+	query := listUndeliveredStatusUpdatesSQL
+	if stuck, _ := strconv.ParseBool(c.QueryParam("stuck")); stuck {
+		query = listStuckStatusUpdatesSQL
+	}
+
+	messages := []OutboxMessage{}
+	if err := i.db.Select(&messages, query); err != nil {
+		return errors.Wrap(err, "error listing status updates in the outbox")
+	}
+
+	return c.JSON(http.StatusOK, map[string][]OutboxMessage{"messages": messages})
+}
+
+// AdminReplayOutboxHandler schedules an undelivered status update to be sent
+// again right away, with a fresh set of attempts.
+func (i *Internal) AdminReplayOutboxHandler(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "id must be an integer")
+	}
+
+	result, err := i.db.Exec(replayStatusUpdateSQL, id)
+	if err != nil {
+		return errors.Wrapf(err, "error replaying status update %d", id)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("undelivered status update %d not found", id))
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// AdminReplayStuckOutboxHandler schedules every status update that has run out
+// of attempts to be sent again.
+func (i *Internal) AdminReplayStuckOutboxHandler(c echo.Context) error {
+	result, err := i.db.Exec(replayStuckStatusUpdatesSQL)
+	if err != nil {
+		return errors.Wrap(err, "error replaying stuck status updates")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]int64{"replayed": rows})
+}
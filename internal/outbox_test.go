@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// testDeliverer records the status updates delivered from the outbox, failing
+// if err is set.
+type testDeliverer struct {
+	delivered []*AnalysisStatus
+	err       error
+}
+
+func (d *testDeliverer) Deliver(jobID string, status *AnalysisStatus) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.delivered = append(d.delivered, status)
+	return nil
+}
+
+// outboxColumns are the columns returned when reading from the outbox.
+var outboxColumns = []string{
+	"id", "external_id", "state", "message", "host", "created_date",
+	"attempts", "next_attempt", "last_error", "delivered_date",
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, outboxBackoff(0, time.Second, time.Minute))
+	assert.Equal(t, 8*time.Second, outboxBackoff(3, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, outboxBackoff(10, time.Second, time.Minute))
+}
+
+func TestOutboxPublisher(t *testing.T) {
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	mock.ExpectExec(`INSERT INTO vice_status_outbox \(.*, next_attempt\) VALUES \(\$1, \$2, \$3, \$4, now\(\)\)`).
+		WithArgs("external-id", "Running", "still going", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	publisher := &OutboxPublisher{db: internal.db}
+	assert.NoError(t, publisher.Running("external-id", "still going"))
+	assert.NoError(t, mock.ExpectationsWereMet(), "the update should be written to the outbox")
+}
+
+func TestDeliverStatusUpdates(t *testing.T) {
+	tests := []struct {
+		description string
+		attempts    int
+		err         error
+		expectation string
+	}{
+		{"delivered", 0, nil, "SET delivered_date = now()"},
+		{"retried", 0, errors.New("connection refused"), "next_attempt = now\\(\\) \\+"},
+		{"out of attempts", 4, errors.New("connection refused"), "next_attempt = NULL"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			internal, mock := setupInternal(t, []runtime.Object{})
+			defer internal.db.Close()
+			deliverer := &testDeliverer{err: test.err}
+			internal.statusDeliverer = deliverer
+			internal.OutboxBatchSize = 10
+			internal.OutboxInitialBackoff = time.Second
+			internal.OutboxMaxBackoff = time.Minute
+			internal.OutboxMaxAttempts = 5
+			internal.OutboxRetention = time.Hour
+
+			mock.ExpectQuery("SELECT o.id, o.external_id").
+				WithArgs(10).
+				WillReturnRows(sqlmock.NewRows(outboxColumns).
+					AddRow(1, "external-id", "Completed", "done", "host", time.Now(), test.attempts, time.Now(), "", nil))
+			mock.ExpectExec(test.expectation).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			// Delivering a message makes the next one for the analysis ready,
+			// so the outbox is checked again.
+			if test.err == nil {
+				mock.ExpectQuery("SELECT o.id, o.external_id").
+					WithArgs(10).
+					WillReturnRows(sqlmock.NewRows(outboxColumns))
+			}
+
+			mock.ExpectExec("DELETE FROM vice_status_outbox").
+				WithArgs(float64(3600)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			assert.NoError(internal.deliverStatusUpdates())
+			assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+
+			if test.err == nil && assert.Len(deliverer.delivered, 1) {
+				assert.Equal("host", deliverer.delivered[0].Host)
+				assert.Equal("done", deliverer.delivered[0].Message)
+			}
+		})
+	}
+}
+
+func TestAdminReplayOutboxHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	internal, mock := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+
+	mock.ExpectExec("UPDATE vice_status_outbox SET attempts = 0").
+		WithArgs(42).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("42")
+
+	err := internal.AdminReplayOutboxHandler(c)
+	if httpErr, ok := err.(*echo.HTTPError); assert.True(ok, "an HTTP error should be returned") {
+		assert.Equal(http.StatusNotFound, httpErr.Code)
+	}
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The reasons given for the pod problems that are reported to the user.
+const (
+	imagePullReason = "ImagePullBackOff"
+	crashLoopReason = "CrashLoopBackOff"
+	oomKilledReason = "OOMKilled"
+)
+
+// imagePullWaitingReasons are the reasons Kubernetes gives for a container
+// that's waiting because its image can't be pulled.
+var imagePullWaitingReasons = map[string]bool{
+	"ImagePullBackOff": true,
+	"ErrImagePull":     true,
+	"InvalidImageName": true,
+}
+
+// podProblem describes a container in a VICE pod that isn't going to start
+// without help.
+type podProblem struct {
+	Reason    string
+	Container string
+	Message   string
+	Restarts  int32
+}
+
+// key returns a string that changes whenever the problem is worth reporting
+// again.
+func (p *podProblem) key() string {
+	return fmt.Sprintf("%s/%s/%d", p.Container, p.Reason, p.Restarts)
+}
+
+// classifyContainer returns the problem with a single container, if any. A
+// container that ran out of memory is reported whether it's waiting to be
+// restarted or already running again, since Kubernetes restarts it right away
+// the first few times.
+func classifyContainer(status *corev1.ContainerStatus) *podProblem {
+	waiting := status.State.Waiting
+
+	if waiting != nil && imagePullWaitingReasons[waiting.Reason] {
+		return &podProblem{
+			Reason:    imagePullReason,
+			Container: status.Name,
+			Message:   waiting.Message,
+			Restarts:  status.RestartCount,
+		}
+	}
+
+	if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason == oomKilledReason {
+		return &podProblem{
+			Reason:    oomKilledReason,
+			Container: status.Name,
+			Message:   fmt.Sprintf("the container ran out of memory and exited with code %d", terminated.ExitCode),
+			Restarts:  status.RestartCount,
+		}
+	}
+
+	if waiting != nil && waiting.Reason == crashLoopReason {
+		return &podProblem{
+			Reason:    crashLoopReason,
+			Container: status.Name,
+			Message:   waiting.Message,
+			Restarts:  status.RestartCount,
+		}
+	}
+
+	return nil
+}
+
+// classifyPod returns the first problem found with the containers in the pod,
+// looking at the init containers first since the other containers can't start
+// until they finish.
+func classifyPod(pod *corev1.Pod) *podProblem {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+
+	for idx := range statuses {
+		if problem := classifyContainer(&statuses[idx]); problem != nil {
+			return problem
+		}
+	}
+
+	return nil
+}
+
+// podProblemIsFatal returns true if the problem has gone on long enough that
+// the analysis should be considered failed. Image pull failures are given a
+// while to resolve themselves, while crash loops are given a number of
+// restarts. A threshold of zero means the analysis is never failed.
+func (i *Internal) podProblemIsFatal(pod *corev1.Pod, problem *podProblem, now time.Time) bool {
+	if problem.Reason == imagePullReason {
+		return i.ImagePullTimeout > 0 && now.Sub(pod.GetCreationTimestamp().Time) >= i.ImagePullTimeout
+	}
+
+	return i.MaxPodRestarts > 0 && problem.Restarts >= i.MaxPodRestarts
+}
+
+// The problems that have been reported and the analyses that have been failed
+// are kept in the database so that they survive restarts and changes of
+// leader. Otherwise the same problems would be reported again, and analyses
+// that were failed would be marked as completed once they're deleted.
+const recordPodProblemSQL = `
+	INSERT INTO vice_pod_problems (pod_name, external_id, problem)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (pod_name) DO UPDATE
+	   SET problem = EXCLUDED.problem,
+	       reported_at = now()
+	 WHERE vice_pod_problems.problem <> EXCLUDED.problem
+`
+
+const clearPodProblemSQL = `
+	DELETE FROM vice_pod_problems
+	 WHERE pod_name = $1
+`
+
+const clearAnalysisPodProblemsSQL = `
+	DELETE FROM vice_pod_problems
+	 WHERE external_id = $1
+`
+
+const markAnalysisFailedSQL = `
+	INSERT INTO vice_analysis_failures (external_id)
+	VALUES ($1)
+	ON CONFLICT (external_id) DO NOTHING
+`
+
+const clearAnalysisFailedSQL = `
+	DELETE FROM vice_analysis_failures
+	 WHERE external_id = $1
+`
+
+// The records for analyses that no longer have a deployment are left behind
+// if a deployment is deleted while nothing is watching.
+const pruneAnalysisFailuresSQL = `
+	DELETE FROM vice_analysis_failures
+	 WHERE NOT external_id = ANY($1)
+`
+
+const prunePodProblemsSQL = `
+	DELETE FROM vice_pod_problems
+	 WHERE NOT external_id = ANY($1)
+`
+
+// execChanged runs a statement, returning true if it changed any rows.
+func (i *Internal) execChanged(query string, args ...interface{}) (bool, error) {
+	result, err := i.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// podProblemChanged records the problem reported for the pod, returning false
+// if the same problem had already been reported. Passing a nil problem clears
+// the record for the pod.
+func (i *Internal) podProblemChanged(pod *corev1.Pod, problem *podProblem) (bool, error) {
+	if problem == nil {
+		if _, err := i.db.Exec(clearPodProblemSQL, pod.Name); err != nil {
+			return false, errors.Wrapf(err, "error clearing the problem recorded for pod %s", pod.Name)
+		}
+		return false, nil
+	}
+
+	changed, err := i.execChanged(recordPodProblemSQL, pod.Name, pod.Labels["external-id"], problem.key())
+	if err != nil {
+		return false, errors.Wrapf(err, "error recording the problem with pod %s", pod.Name)
+	}
+
+	return changed, nil
+}
+
+// markAnalysisFailed records that a failure has been published for the
+// analysis, returning false if that was already the case.
+func (i *Internal) markAnalysisFailed(externalID string) (bool, error) {
+	marked, err := i.execChanged(markAnalysisFailedSQL, externalID)
+	if err != nil {
+		return false, errors.Wrapf(err, "error recording the failure of the analysis with external-id %s", externalID)
+	}
+
+	return marked, nil
+}
+
+// clearAnalysisFailed forgets the failure and pod problems recorded for the
+// analysis, returning true if there was a failure.
+func (i *Internal) clearAnalysisFailed(externalID string) (bool, error) {
+	if _, err := i.db.Exec(clearAnalysisPodProblemsSQL, externalID); err != nil {
+		return false, errors.Wrapf(err, "error clearing the pod problems recorded for external-id %s", externalID)
+	}
+
+	failed, err := i.execChanged(clearAnalysisFailedSQL, externalID)
+	if err != nil {
+		return false, errors.Wrapf(err, "error clearing the failure recorded for external-id %s", externalID)
+	}
+
+	return failed, nil
+}
+
+// prunePodFailures removes the failures and pod problems recorded for
+// analyses that no longer have a deployment in the cluster.
+func (i *Internal) prunePodFailures() error {
+	deployments, err := i.deploymentList(i.ViceNamespace, map[string]string{}, []string{})
+	if err != nil {
+		return errors.Wrap(err, "error listing deployments to prune pod failures")
+	}
+
+	externalIDs := []string{}
+	for _, deployment := range deployments.Items {
+		if externalID, ok := deployment.GetLabels()["external-id"]; ok {
+			externalIDs = append(externalIDs, externalID)
+		}
+	}
+
+	for _, query := range []string{pruneAnalysisFailuresSQL, prunePodProblemsSQL} {
+		if _, err = i.db.Exec(query, pq.Array(externalIDs)); err != nil {
+			return errors.Wrap(err, "error pruning pod failures")
+		}
+	}
+
+	return nil
+}
+
+// eventPodModified reports problems with the containers in a VICE pod, marking
+// the analysis as failed once the problem has gone on for too long.
+func (i *Internal) eventPodModified(pod *corev1.Pod, now time.Time) error {
+	if pod.DeletionTimestamp != nil {
+		_, err := i.podProblemChanged(pod, nil)
+		return err
+	}
+
+	externalID, ok := pod.Labels["external-id"]
+	if !ok {
+		return fmt.Errorf("pod %s is missing the external-id label", pod.Name)
+	}
+
+	problem := classifyPod(pod)
+	if problem == nil {
+		_, err := i.podProblemChanged(pod, nil)
+		return err
+	}
+
+	analysisName := pod.Labels["analysis-name"]
+
+	if i.podProblemIsFatal(pod, problem, now) {
+		marked, err := i.markAnalysisFailed(externalID)
+		if err != nil || !marked {
+			return err
+		}
+
+		msg := fmt.Sprintf(
+			"analysis %s failed: container %s in pod %s is in %s after %d restarts: %s",
+			analysisName,
+			problem.Container,
+			pod.Name,
+			problem.Reason,
+			problem.Restarts,
+			problem.Message,
+		)
+		if err := i.statusPublisher.Fail(externalID, msg); err != nil {
+			return err
+		}
+
+		if i.ExitOnPodFailure {
+			go func() {
+				if err := i.doExit(context.Background(), externalID, podFailureExitReason); err != nil {
+					log.Error(errors.Wrapf(err, "error shutting down failed analysis with external-id %s", externalID))
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	changed, err := i.podProblemChanged(pod, problem)
+	if err != nil || !changed {
+		return err
+	}
+
+	return i.statusPublisher.Running(
+		externalID,
+		fmt.Sprintf(
+			"container %s in pod %s for analysis %s is in %s after %d restarts: %s",
+			problem.Container,
+			pod.Name,
+			analysisName,
+			problem.Reason,
+			problem.Restarts,
+			problem.Message,
+		),
+	)
+}
+
+// MonitorVICEPods fires up a goroutine that watches the pods for VICE analyses
+// and reports image pull failures, crash loops, and containers running out of
+// memory, none of which show up in the deployment status. The goroutine exits
+// when the context is cancelled.
+func (i *Internal) MonitorVICEPods(ctx context.Context) {
+	go func(clientset kubernetes.Interface) {
+		for ctx.Err() == nil {
+			log.Debug("beginning to monitor k8s pods")
+
+			if err := i.prunePodFailures(); err != nil {
+				log.Error(err)
+			}
+
+			set := labels.Set(map[string]string{
+				"app-type": "interactive",
+			})
+			factory := informers.NewSharedInformerFactoryWithOptions(
+				clientset,
+				0,
+				informers.WithNamespace(i.ViceNamespace),
+				informers.WithTweakListOptions(func(listoptions *v1.ListOptions) {
+					listoptions.LabelSelector = set.AsSelector().String()
+				}),
+			)
+
+			podInformer := factory.Core().V1().Pods().Informer()
+
+			handlePod := func(obj interface{}) {
+				pod, ok := obj.(*corev1.Pod)
+				if !ok {
+					log.Error(errors.New("unexpected type pod object"))
+					return
+				}
+
+				if err := i.eventPodModified(pod, time.Now()); err != nil {
+					log.Error(err)
+				}
+			}
+
+			podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: handlePod,
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					handlePod(newObj)
+				},
+				DeleteFunc: func(obj interface{}) {
+					if pod, ok := obj.(*corev1.Pod); ok {
+						if _, err := i.podProblemChanged(pod, nil); err != nil {
+							log.Error(err)
+						}
+					}
+				},
+			})
+
+			podInformer.Run(ctx.Done())
+		}
+	}(i.clientset)
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// vicePod creates a fake VICE pod with a single container in the given state.
+func vicePod(created time.Time, status corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "vice-apps",
+			Name:              "analysis-pod",
+			CreationTimestamp: metav1.NewTime(created),
+			Labels: map[string]string{
+				"app-type":      "interactive",
+				"external-id":   "external-id",
+				"analysis-name": "analysis",
+			},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{status},
+		},
+	}
+}
+
+// waitingStatus returns the status of a container waiting for the given reason.
+func waitingStatus(reason string, restarts int32) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:         "analysis",
+		RestartCount: restarts,
+		State: corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "something went wrong"},
+		},
+	}
+}
+
+func TestClassifyPod(t *testing.T) {
+	now := time.Now()
+
+	oomKilled := waitingStatus("CrashLoopBackOff", 3)
+	oomKilled.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}
+
+	running := corev1.ContainerStatus{
+		Name:  "analysis",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}
+
+	restartedAfterOOM := running
+	restartedAfterOOM.RestartCount = 1
+	restartedAfterOOM.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}
+
+	tests := []struct {
+		description string
+		status      corev1.ContainerStatus
+		expected    string
+	}{
+		{"running", running, ""},
+		{"creating", waitingStatus("ContainerCreating", 0), ""},
+		{"image pull back off", waitingStatus("ImagePullBackOff", 0), imagePullReason},
+		{"image pull error", waitingStatus("ErrImagePull", 0), imagePullReason},
+		{"crash loop", waitingStatus("CrashLoopBackOff", 2), crashLoopReason},
+		{"out of memory", oomKilled, oomKilledReason},
+		{"running again after running out of memory", restartedAfterOOM, oomKilledReason},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			problem := classifyPod(vicePod(now, test.status))
+			if test.expected == "" {
+				assert.Nil(t, problem)
+			} else if assert.NotNil(t, problem) {
+				assert.Equal(t, test.expected, problem.Reason)
+				assert.Equal(t, "analysis", problem.Container)
+			}
+		})
+	}
+}
+
+func TestEventPodModified(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		description string
+		pod         *corev1.Pod
+		running     int
+		failed      int
+	}{
+		{"new crash loop", vicePod(now, waitingStatus("CrashLoopBackOff", 1)), 1, 0},
+		{"too many restarts", vicePod(now, waitingStatus("CrashLoopBackOff", 5)), 0, 1},
+		{"recent image pull failure", vicePod(now, waitingStatus("ImagePullBackOff", 0)), 1, 0},
+		{"old image pull failure", vicePod(now.Add(-time.Hour), waitingStatus("ImagePullBackOff", 0)), 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			internal, mock := setupInternal(t, []runtime.Object{})
+			defer internal.db.Close()
+			publisher := &testPublisher{}
+			internal.statusPublisher = publisher
+			internal.MaxPodRestarts = 5
+			internal.ImagePullTimeout = 10 * time.Minute
+
+			// The second time around, the database says that the problem or
+			// failure has already been reported.
+			query := "INSERT INTO vice_pod_problems"
+			if test.failed == 1 {
+				query = "INSERT INTO vice_analysis_failures"
+			}
+			mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DELETE FROM vice_pod_problems").
+				WithArgs("external-id").
+				WillReturnResult(sqlmock.NewResult(0, int64(test.running)))
+			mock.ExpectExec("DELETE FROM vice_analysis_failures").
+				WithArgs("external-id").
+				WillReturnResult(sqlmock.NewResult(0, int64(test.failed)))
+
+			// Handle the same event twice to make sure repeated updates don't
+			// publish the same status again.
+			for n := 0; n < 2; n++ {
+				assert.NoError(internal.eventPodModified(test.pod, now))
+			}
+
+			assert.Len(publisher.messages, test.running+test.failed)
+
+			failed, err := internal.clearAnalysisFailed("external-id")
+			assert.NoError(err)
+			assert.Equal(test.failed == 1, failed)
+			assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+		})
+	}
+}
+
+func TestPrunePodFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	externalID := testAnalyses[0].externalID
+	dep := viceDeployment(0, "vice-apps", "foo", externalID)
+	dep.Labels["app-type"] = "interactive"
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+
+	mock.ExpectExec("DELETE FROM vice_analysis_failures WHERE NOT external_id = ANY").
+		WithArgs(pq.Array([]string{*externalID})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM vice_pod_problems WHERE NOT external_id = ANY").
+		WithArgs(pq.Array([]string{*externalID})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(internal.prunePodFailures())
+	assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/model"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourceQuota contains the caps on the total resources a user's running
+// analyses may request. A column that's NULL isn't capped.
+type resourceQuota struct {
+	CPUCores    sql.NullFloat64 `db:"cpu_cores"`
+	MemoryBytes sql.NullInt64   `db:"memory_bytes"`
+	GPUs        sql.NullInt64   `db:"gpus"`
+
+	// Source is where the quota came from, either "user" or "default".
+	Source string `db:"-"`
+}
+
+const getResourceQuotaForUserSQL = `
+	SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas
+	WHERE launcher = regexp_replace($1, '-', '_')
+`
+
+const getDefaultResourceQuotaSQL = `
+	SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas
+	WHERE launcher IS NULL
+`
+
+// getResourceQuota runs one of the quota queries, returning nil if there's no
+// quota.
+func (i *Internal) getResourceQuota(ctx context.Context, source, query string, args ...interface{}) (*resourceQuota, error) {
+	quota := &resourceQuota{Source: source}
+	err := i.db.QueryRowContext(ctx, query, args...).Scan(&quota.CPUCores, &quota.MemoryBytes, &quota.GPUs)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// getResourceQuotaForUser returns the user's resource quota. The user's own
+// quota replaces the default quota entirely. Returns nil if neither is set.
+func (i *Internal) getResourceQuotaForUser(ctx context.Context, username string) (*resourceQuota, error) {
+	quota, err := i.getResourceQuota(ctx, "user", getResourceQuotaForUserSQL, username)
+	if err != nil || quota != nil {
+		return quota, err
+	}
+	return i.getResourceQuota(ctx, "default", getDefaultResourceQuotaSQL)
+}
+
+// jobRequestedResources returns the resources the analysis for the job will
+// request once it's launched. Only the analysis container requests resources.
+func jobRequestedResources(job *model.Job) resourceTotals {
+	totals := resourceTotals{
+		CPUCores:    math.Round(float64(cpuResourceRequest(job))*1000) / 1000,
+		MemoryBytes: memResourceRequest(job),
+	}
+	if gpuEnabled(job) {
+		totals.GPUs = 1
+	}
+	return totals
+}
+
+// deploymentRequestedResources returns the total resources requested by the
+// deployments.
+func deploymentRequestedResources(deployments []v1.Deployment) resourceTotals {
+	var totals resourceTotals
+	for idx := range deployments {
+		requested := requestedResources(&deployments[idx].Spec.Template.Spec)
+		totals.CPUCores += requested.CPUCores
+		totals.MemoryBytes += requested.MemoryBytes
+		totals.GPUs += requested.GPUs
+	}
+	return totals
+}
+
+// quotaCheck compares the amount of a resource a user would be using with
+// their quota for it.
+type quotaCheck struct {
+	resource  string
+	unit      string
+	capped    bool
+	quota     float64
+	used      float64
+	requested float64
+	format    func(float64) string
+}
+
+func formatCPU(cores float64) string {
+	return strconv.FormatFloat(cores, 'f', -1, 64) + " CPU cores"
+}
+
+func formatMemory(bytes float64) string {
+	return resource.NewQuantity(int64(bytes), resource.BinarySI).String() + " of memory"
+}
+
+func formatGPUs(gpus float64) string {
+	return fmt.Sprintf("%d GPUs", int64(gpus))
+}
+
+// quotaChecks returns a check for each resource covered by a quota.
+func quotaChecks(quota *resourceQuota, used, requested resourceTotals) []quotaCheck {
+	return []quotaCheck{
+		{
+			resource:  "cpu",
+			unit:      "cores",
+			capped:    quota.CPUCores.Valid,
+			quota:     quota.CPUCores.Float64,
+			used:      used.CPUCores,
+			requested: requested.CPUCores,
+			format:    formatCPU,
+		},
+		{
+			resource:  "memory",
+			unit:      "bytes",
+			capped:    quota.MemoryBytes.Valid,
+			quota:     float64(quota.MemoryBytes.Int64),
+			used:      float64(used.MemoryBytes),
+			requested: float64(requested.MemoryBytes),
+			format:    formatMemory,
+		},
+		{
+			resource:  "gpu",
+			unit:      "gpus",
+			capped:    quota.GPUs.Valid,
+			quota:     float64(quota.GPUs.Int64),
+			used:      float64(used.GPUs),
+			requested: float64(requested.GPUs),
+			format:    formatGPUs,
+		},
+	}
+}
+
+// validateResourceQuota makes sure that the resources requested by a new
+// analysis fit in what's left of the user's quota. The error for the first
+// resource that doesn't fit says how far over the quota it would go.
+func validateResourceQuota(user string, quota *resourceQuota, used, requested resourceTotals) (int, error) {
+	if quota == nil {
+		return http.StatusOK, nil
+	}
+
+	for _, check := range quotaChecks(quota, used, requested) {
+		total := check.used + check.requested
+
+		// CPU cores are compared in millicores to avoid rounding errors.
+		if !check.capped || math.Round(total*1000) <= math.Round(check.quota*1000) {
+			continue
+		}
+
+		exceededBy := total - check.quota
+		return http.StatusBadRequest, common.ErrorResponse{
+			ErrorCode: "ERR_LIMIT_REACHED",
+			Message: fmt.Sprintf(
+				"%s would be using %s, which is %s more than their quota of %s",
+				user,
+				check.format(total),
+				check.format(exceededBy),
+				check.format(check.quota),
+			),
+			Details: &map[string]interface{}{
+				"resource":    check.resource,
+				"unit":        check.unit,
+				"quota":       check.quota,
+				"quotaSource": quota.Source,
+				"used":        check.used,
+				"requested":   check.requested,
+				"exceededBy":  exceededBy,
+			},
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateUserResourceQuota makes sure that the resources requested by a new
+// analysis, added to the resources requested by the user's running
+// deployments, don't exceed the user's quota.
+func (i *Internal) validateUserResourceQuota(ctx context.Context, user string, deployments []v1.Deployment, requested resourceTotals) (int, error) {
+	quota, err := i.getResourceQuotaForUser(ctx, user)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrapf(err, "unable to determine the resource quota for %s", user)
+	}
+
+	return validateResourceQuota(user, quota, deploymentRequestedResources(deployments), requested)
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/model"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidateResourceQuota(t *testing.T) {
+	quota := &resourceQuota{
+		CPUCores:    sql.NullFloat64{Float64: 4, Valid: true},
+		MemoryBytes: sql.NullInt64{Int64: 16 * gibibyte, Valid: true},
+		Source:      "default",
+	}
+
+	tests := []struct {
+		description string
+		quota       *resourceQuota
+		used        resourceTotals
+		requested   resourceTotals
+		resource    string
+		exceededBy  float64
+	}{
+		{
+			description: "no quota",
+			quota:       nil,
+			used:        resourceTotals{CPUCores: 100},
+			requested:   resourceTotals{CPUCores: 1},
+		},
+		{
+			description: "exactly at the quota",
+			quota:       quota,
+			used:        resourceTotals{CPUCores: 2.9, MemoryBytes: 8 * gibibyte},
+			requested:   resourceTotals{CPUCores: 1.1, MemoryBytes: 8 * gibibyte},
+		},
+		{
+			description: "uncapped resource",
+			quota:       quota,
+			used:        resourceTotals{GPUs: 4},
+			requested:   resourceTotals{GPUs: 1},
+		},
+		{
+			description: "cpu exhausted",
+			quota:       quota,
+			used:        resourceTotals{CPUCores: 3.5},
+			requested:   resourceTotals{CPUCores: 1},
+			resource:    "cpu",
+			exceededBy:  0.5,
+		},
+		{
+			description: "memory exhausted",
+			quota:       quota,
+			used:        resourceTotals{CPUCores: 1, MemoryBytes: 12 * gibibyte},
+			requested:   resourceTotals{CPUCores: 1, MemoryBytes: 8 * gibibyte},
+			resource:    "memory",
+			exceededBy:  4 * gibibyte,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			status, err := validateResourceQuota("foo", test.quota, test.used, test.requested)
+			if test.resource == "" {
+				assert.Equal(http.StatusOK, status)
+				assert.NoError(err)
+				return
+			}
+
+			assert.Equal(http.StatusBadRequest, status)
+			if errResp, ok := err.(common.ErrorResponse); assert.True(ok, "an error response should be returned") {
+				details := *errResp.Details
+				assert.Equal("ERR_LIMIT_REACHED", errResp.ErrorCode)
+				assert.Equal(test.resource, details["resource"])
+				assert.Equal("default", details["quotaSource"])
+				assert.InDelta(test.exceededBy, details["exceededBy"], 0.0001)
+			}
+		})
+	}
+}
+
+func TestValidateJobResourceQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	dep := viceDeployment(0, "vice-apps", "foo", nil)
+	dep.Spec.Template.Spec.Containers = []apiv1.Container{
+		{
+			Name: analysisContainerName,
+			Resources: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{
+					apiv1.ResourceCPU:    resource.MustParse("1"),
+					apiv1.ResourceMemory: resource.MustParse("2Gi"),
+				},
+				Limits: apiv1.ResourceList{gpuResourceName: resource.MustParse("1")},
+			},
+		},
+	}
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+
+	registerLimitQuery(mock, "foo", intPointer(5))
+	registerDefaultLimitQuery(mock, 2)
+	mock.ExpectQuery("SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas WHERE launcher =").
+		WithArgs("foo").
+		WillReturnRows(mock.NewRows([]string{"cpu_cores", "memory_bytes", "gpus"}).AddRow(8, nil, 1))
+
+	// The default of one CPU core and 2 GiB of memory fits in the quota.
+	job := createTestSubmission("foo")
+	status, err := internal.validateJob(context.Background(), job)
+	assert.Equal(http.StatusOK, status, "the job should fit without a GPU")
+	assert.NoError(err)
+
+	registerLimitQuery(mock, "foo", intPointer(5))
+	registerDefaultLimitQuery(mock, 2)
+	mock.ExpectQuery("SELECT cpu_cores, memory_bytes, gpus FROM vice_resource_quotas WHERE launcher =").
+		WithArgs("foo").
+		WillReturnRows(mock.NewRows([]string{"cpu_cores", "memory_bytes", "gpus"}).AddRow(8, nil, 1))
+
+	// Asking for a GPU doesn't, since the user's only GPU is already in use.
+	job.Steps[0].Component.Container.Devices = []model.Device{{HostPath: "/dev/nvidia0"}}
+	status, err = internal.validateJob(context.Background(), job)
+	assert.Equal(http.StatusBadRequest, status)
+	if errResp, ok := err.(common.ErrorResponse); assert.True(ok, "an error response should be returned") {
+		details := *errResp.Details
+		assert.Equal("gpu", details["resource"])
+		assert.Equal("user", details["quotaSource"])
+		assert.Equal(1.0, details["used"])
+		assert.Equal(1.0, details["requested"])
+		assert.Equal(1.0, details["exceededBy"])
+		assert.Equal("foo would be using 2 GPUs, which is 1 GPUs more than their quota of 1 GPUs", errResp.Message)
+	}
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const getAnalysisStateSQL = `
+	SELECT j.id, j.status, j.end_date
+	  FROM jobs j
+	  JOIN job_steps s ON s.job_id = j.id
+	 WHERE s.external_id = $1
+`
+
+// DriftObject is a Kubernetes object that belongs to an analysis that should
+// no longer have anything running in the cluster.
+type DriftObject struct {
+	Kind              string `json:"kind"`
+	Name              string `json:"name"`
+	Namespace         string `json:"namespace"`
+	CreationTimestamp string `json:"creationTimestamp"`
+}
+
+// AnalysisDrift describes the orphaned objects left behind for a single
+// analysis.
+type AnalysisDrift struct {
+	ExternalID    string        `json:"externalID"`
+	AnalysisID    string        `json:"analysisID"`
+	Status        string        `json:"status"`
+	Reason        string        `json:"reason"`
+	OrphanedSince string        `json:"orphanedSince"`
+	CleanupReady  bool          `json:"cleanupReady"`
+	Objects       []DriftObject `json:"objects"`
+
+	orphanedSince time.Time
+}
+
+// DriftReport lists the analyses that have objects in the cluster even though
+// the database says they've finished or doesn't know about them at all.
+type DriftReport struct {
+	Analyses []AnalysisDrift `json:"analyses"`
+}
+
+// vicePersistentVolumes returns the cluster-scoped persistent volumes created
+// for VICE analyses by the CSI driver support.
+func (i *Internal) vicePersistentVolumes() ([]metav1.Object, error) {
+	pvList, err := i.clientset.CoreV1().PersistentVolumes().List(getListOptions(map[string]string{}, []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []metav1.Object{}
+	for idx := range pvList.Items {
+		objects = append(objects, &pvList.Items[idx])
+	}
+
+	return objects, nil
+}
+
+// viceObjects returns every VICE object in the cluster, keyed by kind.
+func (i *Internal) viceObjects() (map[string][]metav1.Object, error) {
+	objects := map[string][]metav1.Object{}
+	ns := i.ViceNamespace
+
+	depList, err := i.deploymentList(ns, map[string]string{}, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing deployments")
+	}
+	for idx := range depList.Items {
+		objects["Deployment"] = append(objects["Deployment"], &depList.Items[idx])
+	}
+
+	svcList, err := i.serviceList(ns, map[string]string{}, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing services")
+	}
+	for idx := range svcList.Items {
+		objects["Service"] = append(objects["Service"], &svcList.Items[idx])
+	}
+
+	ingList, err := i.ingressList(ns, map[string]string{}, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing ingresses")
+	}
+	for idx := range ingList.Items {
+		objects["Ingress"] = append(objects["Ingress"], &ingList.Items[idx])
+	}
+
+	cmList, err := i.configmapsList(ns, map[string]string{}, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing configmaps")
+	}
+	for idx := range cmList.Items {
+		objects["ConfigMap"] = append(objects["ConfigMap"], &cmList.Items[idx])
+	}
+
+	pvcList, err := i.clientset.CoreV1().PersistentVolumeClaims(ns).List(getListOptions(map[string]string{}, []string{}))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing persistent volume claims")
+	}
+	for idx := range pvcList.Items {
+		objects["PersistentVolumeClaim"] = append(objects["PersistentVolumeClaim"], &pvcList.Items[idx])
+	}
+
+	pvs, err := i.vicePersistentVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing persistent volumes")
+	}
+	objects["PersistentVolume"] = pvs
+
+	return objects, nil
+}
+
+// orphanReason returns the reason the objects for an analysis with the given
+// status shouldn't be in the cluster anymore, or an empty string if they
+// should be.
+func orphanReason(found bool, status string) string {
+	if !found {
+		return "analysis not found in the database"
+	}
+
+	if !shouldCountStatus(status) {
+		return fmt.Sprintf("analysis is %s", status)
+	}
+
+	return ""
+}
+
+// driftReport compares the VICE objects in the cluster with the status of the
+// analyses they belong to. Objects without an external-id label are ignored,
+// since there's no way to tell which analysis they belong to.
+func (i *Internal) driftReport(now time.Time) (*DriftReport, error) {
+	objects, err := i.viceObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	byExternalID := map[string][]DriftObject{}
+	newest := map[string]time.Time{}
+
+	for kind, objs := range objects {
+		for _, obj := range objs {
+			externalID, ok := obj.GetLabels()["external-id"]
+			if !ok || externalID == "" {
+				continue
+			}
+
+			byExternalID[externalID] = append(byExternalID[externalID], DriftObject{
+				Kind:              kind,
+				Name:              obj.GetName(),
+				Namespace:         obj.GetNamespace(),
+				CreationTimestamp: obj.GetCreationTimestamp().String(),
+			})
+
+			if created := obj.GetCreationTimestamp().Time; created.After(newest[externalID]) {
+				newest[externalID] = created
+			}
+		}
+	}
+
+	report := &DriftReport{Analyses: []AnalysisDrift{}}
+
+	for externalID, driftObjects := range byExternalID {
+		var (
+			analysisID, status string
+			endDate            pq.NullTime
+			found              = true
+		)
+
+		err = i.db.QueryRow(getAnalysisStateSQL, externalID).Scan(&analysisID, &status, &endDate)
+		if err == sql.ErrNoRows {
+			found = false
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error looking up the status of the analysis with external-id %s", externalID)
+		}
+
+		reason := orphanReason(found, status)
+		if reason == "" {
+			continue
+		}
+
+		// The grace period starts when the analysis ended. If the database
+		// doesn't say when that was, it starts when the most recent object
+		// was created so that objects for analyses that are still being set
+		// up aren't removed out from under them.
+		since := newest[externalID]
+		if endDate.Valid {
+			since = endDate.Time
+		}
+
+		sort.Slice(driftObjects, func(a, b int) bool {
+			if driftObjects[a].Kind != driftObjects[b].Kind {
+				return driftObjects[a].Kind < driftObjects[b].Kind
+			}
+			return driftObjects[a].Name < driftObjects[b].Name
+		})
+
+		report.Analyses = append(report.Analyses, AnalysisDrift{
+			ExternalID:    externalID,
+			AnalysisID:    analysisID,
+			Status:        status,
+			Reason:        reason,
+			OrphanedSince: since.Format(time.RFC3339),
+			CleanupReady:  now.Sub(since) >= i.OrphanGracePeriod,
+			Objects:       driftObjects,
+			orphanedSince: since,
+		})
+	}
+
+	sort.Slice(report.Analyses, func(a, b int) bool {
+		return report.Analyses[a].orphanedSince.Before(report.Analyses[b].orphanedSince)
+	})
+
+	return report, nil
+}
+
+// cleanUpOrphan removes the objects left behind for an analysis. The
+// cluster-scoped persistent volumes are removed explicitly, since they aren't
+// always cleaned up along with their claims.
+func (i *Internal) cleanUpOrphan(externalID string) error {
+	if err := i.doExit(context.Background(), externalID, orphanExitReason); err != nil {
+		return err
+	}
+
+	pvclient := i.clientset.CoreV1().PersistentVolumes()
+	pvList, err := pvclient.List(getListOptions(map[string]string{"external-id": externalID}, []string{}))
+	if err != nil {
+		return err
+	}
+
+	for _, pv := range pvList.Items {
+		if err = pvclient.Delete(pv.Name, &metav1.DeleteOptions{}); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// reconcile logs the drift between the cluster and the database, removing
+// the orphaned objects that are past the grace period if cleanup is enabled.
+func (i *Internal) reconcile() {
+	report, err := i.driftReport(time.Now())
+	if err != nil {
+		log.Error(errors.Wrap(err, "error generating the drift report"))
+		return
+	}
+
+	for _, drift := range report.Analyses {
+		log.Warnf(
+			"found %d orphaned objects for external-id %s: %s",
+			len(drift.Objects),
+			drift.ExternalID,
+			drift.Reason,
+		)
+
+		if !i.CleanUpOrphans || !drift.CleanupReady {
+			continue
+		}
+
+		log.Infof("cleaning up orphaned objects for external-id %s", drift.ExternalID)
+		if err = i.cleanUpOrphan(drift.ExternalID); err != nil {
+			log.Error(errors.Wrapf(err, "error cleaning up orphaned objects for external-id %s", drift.ExternalID))
+		}
+	}
+}
+
+// ReconcileOrphans fires up a goroutine that periodically looks for VICE
+// objects that have outlived their analyses. Cleaning up is idempotent, so
+// it's safe to run this in more than one replica. The goroutine exits when the
+// context is cancelled.
+func (i *Internal) ReconcileOrphans(ctx context.Context) {
+	go func() {
+		for {
+			log.Debug("looking for orphaned VICE objects")
+			i.reconcile()
+			if !sleepContext(ctx, i.ReconcileInterval) {
+				return
+			}
+		}
+	}()
+}
+
+// AdminDriftHandler returns the objects in the cluster that belong to analyses
+// that have finished or that the database doesn't know about.
+func (i *Internal) AdminDriftHandler(c echo.Context) error {
+	report, err := i.driftReport(time.Now())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
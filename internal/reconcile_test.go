@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// viceObjectMeta returns the metadata for a fake VICE object.
+func viceObjectMeta(namespace, name, externalID string, created time.Time) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(created),
+		Labels: map[string]string{
+			"app-type":    "interactive",
+			"external-id": externalID,
+		},
+	}
+}
+
+func TestOrphanReason(t *testing.T) {
+	assert.Equal(t, "analysis not found in the database", orphanReason(false, ""))
+	assert.Equal(t, "analysis is Completed", orphanReason(true, "Completed"))
+	assert.Equal(t, "", orphanReason(true, "Running"))
+}
+
+func TestDriftReport(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	objs := []runtime.Object{
+		&corev1.Service{ObjectMeta: viceObjectMeta("vice-apps", "running", "running-id", now.Add(-48*time.Hour))},
+		&corev1.Service{ObjectMeta: viceObjectMeta("vice-apps", "completed", "completed-id", now.Add(-48*time.Hour))},
+		&corev1.ConfigMap{ObjectMeta: viceObjectMeta("vice-apps", "completed-cm", "completed-id", now.Add(-48*time.Hour))},
+		&corev1.PersistentVolume{ObjectMeta: viceObjectMeta("", "missing-pv", "missing-id", now.Add(-time.Minute))},
+	}
+
+	internal, mock := setupInternal(t, objs)
+	defer internal.db.Close()
+	internal.OrphanGracePeriod = time.Hour
+	mock.MatchExpectationsInOrder(false)
+
+	columns := []string{"id", "status", "end_date"}
+	mock.ExpectQuery("SELECT j.id, j.status, j.end_date FROM jobs j").
+		WithArgs("running-id").
+		WillReturnRows(mock.NewRows(columns).AddRow("a", "Running", nil))
+	mock.ExpectQuery("SELECT j.id, j.status, j.end_date FROM jobs j").
+		WithArgs("completed-id").
+		WillReturnRows(mock.NewRows(columns).AddRow("b", "Completed", now.Add(-2*time.Hour)))
+	mock.ExpectQuery("SELECT j.id, j.status, j.end_date FROM jobs j").
+		WithArgs("missing-id").
+		WillReturnRows(mock.NewRows(columns))
+
+	report, err := internal.driftReport(now)
+	assert.NoError(err, "generating the drift report should not fail")
+	assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+
+	if assert.Len(report.Analyses, 2) {
+		completed := report.Analyses[0]
+		assert.Equal("completed-id", completed.ExternalID)
+		assert.Equal("b", completed.AnalysisID)
+		assert.True(completed.CleanupReady, "the completed analysis should be past the grace period")
+		assert.Equal([]string{"ConfigMap", "Service"}, []string{completed.Objects[0].Kind, completed.Objects[1].Kind})
+
+		missing := report.Analyses[1]
+		assert.Equal("missing-id", missing.ExternalID)
+		assert.False(missing.CleanupReady, "the recently created volume should still be in the grace period")
+		assert.Equal("PersistentVolume", missing.Objects[0].Kind)
+	}
+}
+
+func TestCleanUpOrphan(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	objs := []runtime.Object{
+		&corev1.Service{ObjectMeta: viceObjectMeta("vice-apps", "orphan", "orphan-id", now)},
+		&corev1.PersistentVolume{ObjectMeta: viceObjectMeta("", "orphan-pv", "orphan-id", now)},
+		&corev1.PersistentVolume{ObjectMeta: viceObjectMeta("", "other-pv", "other-id", now)},
+	}
+
+	internal, _ := setupInternal(t, objs)
+	defer internal.db.Close()
+
+	assert.NoError(internal.cleanUpOrphan("orphan-id"), "cleaning up should not fail")
+
+	svcs, err := internal.clientset.CoreV1().Services("vice-apps").List(metav1.ListOptions{})
+	assert.NoError(err)
+	assert.Len(svcs.Items, 0)
+
+	pvs, err := internal.clientset.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	assert.NoError(err)
+	if assert.Len(pvs.Items, 1) {
+		assert.Equal("other-pv", pvs.Items[0].Name)
+	}
+}
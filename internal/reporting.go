@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
@@ -141,11 +142,15 @@ type MetaInfo struct {
 // DeploymentInfo contains information returned about a Deployment.
 type DeploymentInfo struct {
 	MetaInfo
-	Image   string   `json:"image"`
-	Command []string `json:"command"`
-	Port    int32    `json:"port"`
-	User    int64    `json:"user"`
-	Group   int64    `json:"group"`
+	Image     string   `json:"image"`
+	Command   []string `json:"command"`
+	Port      int32    `json:"port"`
+	User      int64    `json:"user"`
+	Group     int64    `json:"group"`
+	Suspended bool     `json:"suspended"`
+
+	// Usage is only filled in for the admin listing.
+	Usage *AnalysisUsage `json:"usage,omitempty"`
 }
 
 func deploymentInfo(deployment *v1.Deployment) *DeploymentInfo {
@@ -184,11 +189,12 @@ func deploymentInfo(deployment *v1.Deployment) *DeploymentInfo {
 			CreationTimestamp: deployment.GetCreationTimestamp().String(),
 		},
 
-		Image:   image,
-		Command: command,
-		Port:    port,
-		User:    user,
-		Group:   group,
+		Image:     image,
+		Command:   command,
+		Port:      port,
+		User:      user,
+		Group:     group,
+		Suspended: isSuspended(deployment),
 	}
 }
 
@@ -563,7 +569,7 @@ func (i *Internal) DescribeAnalysisHandler(c echo.Context) error {
 	// the user ID.
 	fixedUser := i.fixUsername(user)
 	a := apps.NewApps(i.db, i.UserSuffix)
-	_, err := a.GetUserID(fixedUser)
+	_, err := a.GetUserID(c.Request().Context(), fixedUser)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("user %s not found", fixedUser))
@@ -588,7 +594,7 @@ func (i *Internal) DescribeAnalysisHandler(c echo.Context) error {
 	// is set in the database.
 	if len(listing.Deployments) > 0 {
 		externalID := listing.Deployments[0].ExternalID
-		analysisID, err := a.GetAnalysisIDByExternalID(externalID)
+		analysisID, err := a.GetAnalysisIDByExternalID(c.Request().Context(), externalID)
 		if err != nil {
 			return err
 		}
@@ -623,7 +629,7 @@ func (i *Internal) FilterableResourcesHandler(c echo.Context) error {
 	// the user ID.
 	user = i.fixUsername(user)
 	a := apps.NewApps(i.db, i.UserSuffix)
-	userID, err := a.GetUserID(user)
+	userID, err := a.GetUserID(c.Request().Context(), user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("user %s not found", user))
@@ -656,16 +662,32 @@ func (i *Internal) AdminFilterableResourcesHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	// The listing is still useful without the usage, so failing to read it
+	// in time isn't an error.
+	ctx := c.Request().Context()
+	if i.UsageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.UsageTimeout)
+		defer cancel()
+	}
+	usages, err := i.collectUsageContext(ctx, filter)
+	if err != nil {
+		log.Error(errors.Wrap(err, "error reading resource usage for the admin listing"))
+	}
+	for idx := range listing.Deployments {
+		listing.Deployments[idx].Usage = usages[listing.Deployments[idx].ExternalID]
+	}
+
 	return c.JSON(http.StatusOK, listing)
 }
 
-func populateAnalysisID(a *apps.Apps, existingLabels map[string]string) (map[string]string, error) {
+func populateAnalysisID(ctx context.Context, a *apps.Apps, existingLabels map[string]string) (map[string]string, error) {
 	if _, ok := existingLabels["analysis-id"]; !ok {
 		externalID, ok := existingLabels["external-id"]
 		if !ok {
 			return existingLabels, fmt.Errorf("missing external-id key")
 		}
-		analysisID, err := a.GetAnalysisIDByExternalID(externalID)
+		analysisID, err := a.GetAnalysisIDByExternalID(ctx, externalID)
 		if err != nil {
 			log.Debug(errors.Wrapf(err, "error getting analysis id for external id %s", externalID))
 		} else {
@@ -687,10 +709,10 @@ func populateSubdomain(existingLabels map[string]string) map[string]string {
 	return existingLabels
 }
 
-func populateLoginIP(a *apps.Apps, existingLabels map[string]string) (map[string]string, error) {
+func populateLoginIP(ctx context.Context, a *apps.Apps, existingLabels map[string]string) (map[string]string, error) {
 	if _, ok := existingLabels["login-ip"]; !ok {
 		if userID, ok := existingLabels["user-id"]; ok {
-			ipAddr, err := a.GetUserIP(userID)
+			ipAddr, err := a.GetUserIP(ctx, userID)
 			if err != nil {
 				return existingLabels, err
 			}
@@ -701,7 +723,7 @@ func populateLoginIP(a *apps.Apps, existingLabels map[string]string) (map[string
 	return existingLabels, nil
 }
 
-func (i *Internal) relabelDeployments() []error {
+func (i *Internal) relabelDeployments(ctx context.Context) []error {
 	filter := map[string]string{} // Empty on purpose. Only filter based on interactive label.
 	errors := []error{}
 
@@ -718,12 +740,12 @@ func (i *Internal) relabelDeployments() []error {
 
 		existingLabels = populateSubdomain(existingLabels)
 
-		existingLabels, err = populateLoginIP(a, existingLabels)
+		existingLabels, err = populateLoginIP(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
 
-		existingLabels, err = populateAnalysisID(a, existingLabels)
+		existingLabels, err = populateAnalysisID(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -738,7 +760,7 @@ func (i *Internal) relabelDeployments() []error {
 	return errors
 }
 
-func (i *Internal) relabelConfigMaps() []error {
+func (i *Internal) relabelConfigMaps(ctx context.Context) []error {
 	filter := map[string]string{} // Empty on purpose. Only filter based on interactive label.
 	errors := []error{}
 
@@ -755,12 +777,12 @@ func (i *Internal) relabelConfigMaps() []error {
 
 		existingLabels = populateSubdomain(existingLabels)
 
-		existingLabels, err = populateLoginIP(a, existingLabels)
+		existingLabels, err = populateLoginIP(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
 
-		existingLabels, err = populateAnalysisID(a, existingLabels)
+		existingLabels, err = populateAnalysisID(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -775,7 +797,7 @@ func (i *Internal) relabelConfigMaps() []error {
 	return errors
 }
 
-func (i *Internal) relabelServices() []error {
+func (i *Internal) relabelServices(ctx context.Context) []error {
 	filter := map[string]string{} // Empty on purpose. Only filter based on interactive label.
 	errors := []error{}
 
@@ -792,12 +814,12 @@ func (i *Internal) relabelServices() []error {
 
 		existingLabels = populateSubdomain(existingLabels)
 
-		existingLabels, err = populateLoginIP(a, existingLabels)
+		existingLabels, err = populateLoginIP(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
 
-		existingLabels, err = populateAnalysisID(a, existingLabels)
+		existingLabels, err = populateAnalysisID(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -812,7 +834,7 @@ func (i *Internal) relabelServices() []error {
 	return errors
 }
 
-func (i *Internal) relabelIngresses() []error {
+func (i *Internal) relabelIngresses(ctx context.Context) []error {
 	filter := map[string]string{} // Empty on purpose. Only filter based on interactive label.
 	errors := []error{}
 
@@ -829,12 +851,12 @@ func (i *Internal) relabelIngresses() []error {
 
 		existingLabels = populateSubdomain(existingLabels)
 
-		existingLabels, err = populateLoginIP(a, existingLabels)
+		existingLabels, err = populateLoginIP(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
 
-		existingLabels, err = populateAnalysisID(a, existingLabels)
+		existingLabels, err = populateAnalysisID(ctx, a, existingLabels)
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -852,25 +874,25 @@ func (i *Internal) relabelIngresses() []error {
 // ApplyAsyncLabels ensures that the required labels are applied to all running VICE analyses.
 // This is useful to avoid race conditions between the DE database and the k8s cluster,
 // and also for adding new labels to "old" analyses during an update.
-func (i *Internal) ApplyAsyncLabels() []error {
+func (i *Internal) ApplyAsyncLabels(ctx context.Context) []error {
 	errors := []error{}
 
-	labelDepsErrors := i.relabelDeployments()
+	labelDepsErrors := i.relabelDeployments(ctx)
 	if len(labelDepsErrors) > 0 {
 		errors = append(errors, labelDepsErrors...)
 	}
 
-	labelCMErrors := i.relabelConfigMaps()
+	labelCMErrors := i.relabelConfigMaps(ctx)
 	if len(labelCMErrors) > 0 {
 		errors = append(errors, labelCMErrors...)
 	}
 
-	labelSVCErrors := i.relabelServices()
+	labelSVCErrors := i.relabelServices(ctx)
 	if len(labelSVCErrors) > 0 {
 		errors = append(errors, labelSVCErrors...)
 	}
 
-	labelIngressesErrors := i.relabelIngresses()
+	labelIngressesErrors := i.relabelIngresses(ctx)
 	if len(labelIngressesErrors) > 0 {
 		errors = append(errors, labelIngressesErrors...)
 	}
@@ -881,7 +903,7 @@ func (i *Internal) ApplyAsyncLabels() []error {
 // ApplyAsyncLabelsHandler is the http handler for triggering the application
 // of labels on running VICE analyses.
 func (i *Internal) ApplyAsyncLabelsHandler(c echo.Context) error {
-	errs := i.ApplyAsyncLabels()
+	errs := i.ApplyAsyncLabels(c.Request().Context())
 
 	if len(errs) > 0 {
 		var errMsg strings.Builder
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unknownNodePool is reported for analyses running on nodes that don't have
+// the node pool label.
+const unknownNodePool = "unknown"
+
+var (
+	runningByAppDesc = prometheus.NewDesc(
+		"app_exposer_running_analyses",
+		"The number of VICE analyses with a deployment that isn't suspended, by app.",
+		[]string{"app_id", "app_name"},
+		nil,
+	)
+
+	runningByNodePoolDesc = prometheus.NewDesc(
+		"app_exposer_running_analysis_pods",
+		"The number of running VICE analysis pods, by node pool.",
+		[]string{"node_pool"},
+		nil,
+	)
+)
+
+// errRunningCachesNotSynced is reported until the informer caches used for the
+// running analysis counts have been filled.
+var errRunningCachesNotSynced = errors.New("the caches for the running analysis counts haven't synced yet")
+
+// runningAnalysesCollector is a prometheus.Collector that reports the number
+// of running analyses. The counts are read from informer caches when the
+// metrics are scraped so that every replica reports them, not just the leader,
+// without making requests to the API server for each scrape. Rather than
+// watching every node in the cluster, the node pools of the nodes running VICE
+// pods are looked up when a pod is first seen on them and cached.
+type runningAnalysesCollector struct {
+	clientset     kubernetes.Interface
+	deployments   appslisters.DeploymentLister
+	pods          corelisters.PodLister
+	nodePoolLabel string
+	synced        []cache.InformerSynced
+
+	mu        sync.Mutex
+	nodePools map[string]string
+}
+
+// RunningAnalysesCollector returns a prometheus.Collector that reports the
+// number of running VICE analyses per app and per node pool. The informers
+// that it reads from run until the stop channel is closed.
+func (i *Internal) RunningAnalysesCollector(stop <-chan struct{}) prometheus.Collector {
+	set := labels.Set(map[string]string{
+		"app-type": "interactive",
+	})
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		i.clientset,
+		0,
+		informers.WithNamespace(i.ViceNamespace),
+		informers.WithTweakListOptions(func(listoptions *metav1.ListOptions) {
+			listoptions.LabelSelector = set.AsSelector().String()
+		}),
+	)
+
+	deploymentInformer := factory.Apps().V1().Deployments()
+	podInformer := factory.Core().V1().Pods()
+
+	r := &runningAnalysesCollector{
+		clientset:     i.clientset,
+		deployments:   deploymentInformer.Lister(),
+		pods:          podInformer.Lister(),
+		nodePoolLabel: i.NodePoolLabel,
+		synced: []cache.InformerSynced{
+			deploymentInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+		},
+		nodePools: map[string]string{},
+	}
+
+	factory.Start(stop)
+
+	return r
+}
+
+func (r *runningAnalysesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runningByAppDesc
+	ch <- runningByNodePoolDesc
+}
+
+func (r *runningAnalysesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, synced := range r.synced {
+		if !synced() {
+			ch <- prometheus.NewInvalidMetric(runningByAppDesc, errRunningCachesNotSynced)
+			ch <- prometheus.NewInvalidMetric(runningByNodePoolDesc, errRunningCachesNotSynced)
+			return
+		}
+	}
+
+	counts, err := r.runningByApp()
+	if err != nil {
+		log.Error(err)
+		ch <- prometheus.NewInvalidMetric(runningByAppDesc, err)
+	} else {
+		for a, count := range counts {
+			ch <- prometheus.MustNewConstMetric(runningByAppDesc, prometheus.GaugeValue, float64(count), a.id, a.name)
+		}
+	}
+
+	pools, err := r.runningPodsByNodePool()
+	if err != nil {
+		log.Error(err)
+		ch <- prometheus.NewInvalidMetric(runningByNodePoolDesc, err)
+		return
+	}
+	for pool, count := range pools {
+		ch <- prometheus.MustNewConstMetric(runningByNodePoolDesc, prometheus.GaugeValue, float64(count), pool)
+	}
+}
+
+// runningApp identifies the app of a running analysis.
+type runningApp struct{ id, name string }
+
+// runningByApp counts the VICE deployments for each app. Suspended analyses
+// aren't running, so they're left out.
+func (r *runningAnalysesCollector) runningByApp() (map[runningApp]int, error) {
+	deployments, err := r.deployments.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[runningApp]int{}
+	for _, d := range deployments {
+		if isSuspended(d) {
+			continue
+		}
+		counts[runningApp{d.Labels["app-id"], d.Labels["app-name"]}]++
+	}
+
+	return counts, nil
+}
+
+// runningPodsByNodePool counts the running VICE pods on the nodes in each
+// node pool, as named by the NodePoolLabel label on the nodes.
+func (r *runningAnalysesCollector) runningPodsByNodePool() (map[string]int, error) {
+	pods, err := r.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := map[string]int{}
+	seen := map[string]bool{}
+
+	for _, pod := range pods {
+		if pod.Status.Phase != apiv1.PodRunning || pod.Spec.NodeName == "" {
+			continue
+		}
+		seen[pod.Spec.NodeName] = true
+		counts[r.nodePool(pod.Spec.NodeName)]++
+	}
+
+	// Forget the nodes that no longer run VICE pods, so that the cache doesn't
+	// grow as nodes are replaced.
+	for name := range r.nodePools {
+		if !seen[name] {
+			delete(r.nodePools, name)
+		}
+	}
+
+	return counts, nil
+}
+
+// nodePool returns the node pool of the named node, looking it up if it isn't
+// cached. Nodes that can't be read are reported in the unknown pool and looked
+// up again on the next scrape. The caller must hold r.mu.
+func (r *runningAnalysesCollector) nodePool(name string) string {
+	if pool, ok := r.nodePools[name]; ok {
+		return pool
+	}
+
+	node, err := r.clientset.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("error getting node %s: %s", name, err)
+		return unknownNodePool
+	}
+
+	pool := unknownNodePool
+	if node.Labels[r.nodePoolLabel] != "" {
+		pool = node.Labels[r.nodePoolLabel]
+	}
+	r.nodePools[name] = pool
+
+	return pool
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func runningPod(name, nodeName string, phase apiv1.PodPhase) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "vice-apps",
+			Labels:    map[string]string{"app-type": "interactive"},
+		},
+		Spec:   apiv1.PodSpec{NodeName: nodeName},
+		Status: apiv1.PodStatus{Phase: phase},
+	}
+}
+
+// startRunningCollector returns a collector reading from the fake clientset
+// once its caches have synced, along with the channel that stops it.
+func startRunningCollector(t *testing.T, internal *Internal) (*runningAnalysesCollector, chan struct{}) {
+	stop := make(chan struct{})
+	r := internal.RunningAnalysesCollector(stop).(*runningAnalysesCollector)
+	if !cache.WaitForCacheSync(stop, r.synced...) {
+		t.Fatal("the caches didn't sync")
+	}
+	return r, stop
+}
+
+func TestRunningPodsByNodePool(t *testing.T) {
+	assert := assert.New(t)
+
+	objects := []runtime.Object{
+		&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{"node-pool": "gpu"}}},
+		&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu-1", Labels: map[string]string{"node-pool": "cpu"}}},
+		&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		runningPod("a", "gpu-1", apiv1.PodRunning),
+		runningPod("b", "cpu-1", apiv1.PodRunning),
+		runningPod("c", "cpu-1", apiv1.PodRunning),
+		runningPod("d", "other", apiv1.PodRunning),
+		runningPod("e", "cpu-1", apiv1.PodPending),
+		runningPod("f", "missing", apiv1.PodRunning),
+	}
+
+	internal, _ := setupInternal(t, objects)
+	defer internal.db.Close()
+	internal.ViceNamespace = "vice-apps"
+	internal.NodePoolLabel = "node-pool"
+
+	r, stop := startRunningCollector(t, internal)
+	defer close(stop)
+
+	counts, err := r.runningPodsByNodePool()
+	assert.NoError(err)
+	assert.Equal(map[string]int{"gpu": 1, "cpu": 2, unknownNodePool: 2}, counts)
+	assert.Equal(map[string]string{"gpu-1": "gpu", "cpu-1": "cpu", "other": unknownNodePool}, r.nodePools, "nodes that can't be read shouldn't be cached")
+}
+
+func TestRunningPodsByNodePoolCache(t *testing.T) {
+	assert := assert.New(t)
+
+	objects := []runtime.Object{
+		&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{"node-pool": "gpu"}}},
+		runningPod("a", "gpu-1", apiv1.PodRunning),
+	}
+
+	internal, _ := setupInternal(t, objects)
+	defer internal.db.Close()
+	internal.NodePoolLabel = "node-pool"
+
+	r, stop := startRunningCollector(t, internal)
+	defer close(stop)
+
+	_, err := r.runningPodsByNodePool()
+	assert.NoError(err)
+
+	// The node pool is cached, so the node isn't read again.
+	err = internal.clientset.CoreV1().Nodes().Delete("gpu-1", &metav1.DeleteOptions{})
+	assert.NoError(err)
+	counts, err := r.runningPodsByNodePool()
+	assert.NoError(err)
+	assert.Equal(map[string]int{"gpu": 1}, counts)
+
+	// Nodes without running VICE pods are forgotten.
+	r.pods = corelisters.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+	_, err = r.runningPodsByNodePool()
+	assert.NoError(err)
+	assert.Empty(r.nodePools)
+}
+
+func TestRunningByApp(t *testing.T) {
+	assert := assert.New(t)
+
+	running := viceDeployment(0, "vice-apps", "foo", nil)
+	suspended := viceDeployment(1, "vice-apps", "foo", nil)
+	suspended.Labels[suspendedLabel] = "true"
+	for _, d := range []*v1.Deployment{running, suspended} {
+		d.Labels["app-type"] = "interactive"
+		d.Labels["app-id"] = "app-1"
+		d.Labels["app-name"] = "App"
+	}
+
+	internal, _ := setupInternal(t, []runtime.Object{running, suspended})
+	defer internal.db.Close()
+
+	r, stop := startRunningCollector(t, internal)
+	defer close(stop)
+
+	counts, err := r.runningByApp()
+	assert.NoError(err)
+	assert.Equal(map[runningApp]int{{"app-1", "App"}: 1}, counts, "suspended analyses shouldn't be counted")
+}
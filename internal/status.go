@@ -2,13 +2,17 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"time"
 
+	"github.com/cyverse-de/app-exposer/metrics"
 	"github.com/cyverse-de/messaging"
 	"github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
@@ -27,10 +31,30 @@ type AnalysisStatusPublisher interface {
 	Running(jobID, msg string) error
 }
 
-// JSLPublisher is a concrete implementation of AnalysisStatusPublisher that
-// posts status updates to the job-status-listener service.
+// StatusDeliverer is the interface for types that send status updates read
+// from the outbox on to the service that records them.
+type StatusDeliverer interface {
+	Deliver(jobID string, status *AnalysisStatus) error
+}
+
+// JSLPublisher is a concrete implementation of StatusDeliverer that posts
+// status updates to the job-status-listener service.
 type JSLPublisher struct {
 	statusURL string
+	client    *http.Client
+}
+
+// NewJSLPublisher returns a *JSLPublisher that posts status updates to the
+// job-status-listener service at the given URL, giving up on requests that
+// take longer than the timeout.
+func NewJSLPublisher(statusURL string, timeout time.Duration) *JSLPublisher {
+	return &JSLPublisher{
+		statusURL: statusURL,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: metrics.NewTransport("job-status-listener", nil),
+		},
+	}
 }
 
 // AnalysisStatus contains the data needed to post a status update to the
@@ -41,19 +65,16 @@ type AnalysisStatus struct {
 	Message string
 }
 
-func (j *JSLPublisher) postStatus(jobID, msg string, jobState messaging.JobState) error {
-	status := &AnalysisStatus{
-		Host:    hostname(),
-		State:   jobState,
-		Message: msg,
-	}
+// Deliver posts the status update to job-status-listener.
+func (j *JSLPublisher) Deliver(jobID string, status *AnalysisStatus) error {
+	jobState := status.State
 
 	u, err := url.Parse(j.statusURL)
 	if err != nil {
 		return errors.Wrapf(
 			err,
 			"error parsing URL %s for job %s before posting %s status",
-			j,
+			j.statusURL,
 			jobID,
 			jobState,
 		)
@@ -70,7 +91,13 @@ func (j *JSLPublisher) postStatus(jobID, msg string, jobState messaging.JobState
 		)
 
 	}
-	response, err := http.Post(u.String(), "application/json", bytes.NewReader(js))
+
+	client := j.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Post(u.String(), "application/json", bytes.NewReader(js))
 	if err != nil {
 		return errors.Wrapf(
 			err,
@@ -80,47 +107,28 @@ func (j *JSLPublisher) postStatus(jobID, msg string, jobState messaging.JobState
 			u.String(),
 		)
 	}
+	defer response.Body.Close()
+
 	if response.StatusCode < 200 || response.StatusCode > 399 {
-		return errors.Wrapf(
-			err,
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf(
 			"error status code %d returned after posting %s status for job %s to %s: %s",
 			response.StatusCode,
 			jobState,
 			jobID,
 			u.String(),
-			response.Body,
+			body,
 		)
 	}
 	return nil
 }
 
-// Fail sends an analysis failure update with the provided message via the AMQP
-// broker. Should be sent once.
-func (j *JSLPublisher) Fail(jobID, msg string) error {
-	log.Warnf("Sending failure job status update for external-id %s", jobID)
-
-	return j.postStatus(jobID, msg, messaging.FailedState)
-}
-
-// Success sends a success update via the AMQP broker. Should be sent once.
-func (j *JSLPublisher) Success(jobID, msg string) error {
-	log.Warnf("Sending success job status update for external-id %s", jobID)
-
-	return j.postStatus(jobID, msg, messaging.SucceededState)
-}
-
-// Running sends an analysis running status update with the provided message via the
-// AMQP broker. May be sent multiple times, preferably with different messages.
-func (j *JSLPublisher) Running(jobID, msg string) error {
-	log.Warnf("Sending running job status update for external-id %s", jobID)
-	return j.postStatus(jobID, msg, messaging.RunningState)
-}
-
 // MonitorVICEEvents fires up a goroutine that forwards events from the cluster
-// to the status receiving service (probably job-status-listener).
-func (i *Internal) MonitorVICEEvents() {
+// to the status receiving service (probably job-status-listener). The goroutine
+// exits when the context is cancelled.
+func (i *Internal) MonitorVICEEvents(ctx context.Context) {
 	go func(clientset kubernetes.Interface) {
-		for {
+		for ctx.Err() == nil {
 			log.Debug("beginning to monitor k8s events")
 			set := labels.Set(map[string]string{
 				"app-type": "interactive",
@@ -135,9 +143,6 @@ func (i *Internal) MonitorVICEEvents() {
 			)
 
 			deploymentInformer := factory.Apps().V1().Deployments().Informer()
-			deploymentInformerStop := make(chan struct{})
-			// no-op, defer doesn't work in infinite loop
-			// defer close(deploymentInformerStop)
 
 			deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 				AddFunc: func(obj interface{}) {
@@ -194,16 +199,36 @@ func (i *Internal) MonitorVICEEvents() {
 
 					log.Infof("processing deployment deletion for job %s", jobID)
 
+					summary := i.finishDeploymentTracking(jobID)
+
+					if deployment, ok := obj.(*appsv1.Deployment); ok {
+						if err = i.recordUsage(context.Background(), deployment, time.Now()); err != nil {
+							log.Error(err)
+						}
+					}
+
+					// Analyses that were failed because their pods couldn't
+					// start shouldn't be marked as completed.
+					failed, err := i.clearAnalysisFailed(jobID)
+					if err != nil {
+						log.Error(err)
+					}
+					if failed {
+						return
+					}
+
 					analysisName, ok := labels["analysis-name"]
 					if !ok {
 						log.Error(errors.New("deployment is missing analysis-name label"))
 						return
 					}
 
-					if err = i.statusPublisher.Success(
-						jobID,
-						fmt.Sprintf("deployment %s has been deleted for analysis %s", depObj.GetName(), analysisName),
-					); err != nil {
+					msg := fmt.Sprintf("deployment %s has been deleted for analysis %s", depObj.GetName(), analysisName)
+					if summary != "" {
+						msg = fmt.Sprintf("%s; %s", msg, summary)
+					}
+
+					if err = i.statusPublisher.Success(jobID, msg); err != nil {
 						log.Error(err)
 					}
 				},
@@ -232,37 +257,21 @@ func (i *Internal) MonitorVICEEvents() {
 				},
 			})
 
-			deploymentInformer.Run(deploymentInformerStop)
+			deploymentInformer.Run(ctx.Done())
 		}
 	}(i.clientset)
 }
 
 // eventDeploymentModified handles emitting job status updates when the pod for the
-// VICE analysis generates a modified event from k8s.
+// VICE analysis generates a modified event from k8s. Updates that don't change
+// the replica counts are dropped, and bursts of changes are coalesced.
 func (i *Internal) eventDeploymentModified(deployment *appsv1.Deployment, jobID string) error {
-	var err error
-
-	analysisName := deployment.Labels["analysis-name"]
-
 	if deployment.DeletionTimestamp != nil {
 		// Pod was deleted at some point, don't do anything now.
 		return nil
 	}
 
-	err = i.statusPublisher.Running(
-		jobID,
-		fmt.Sprintf(
-			"deployment %s for analysis %s summary: \n replicas: %d ready replicas: %d \n available replicas: %d \n unavailable replicas: %d",
-			deployment.Name,
-			analysisName,
-			deployment.Status.Replicas,
-			deployment.Status.ReadyReplicas,
-			deployment.Status.AvailableReplicas,
-			deployment.Status.UnavailableReplicas,
-		),
-	)
-
-	return err
+	return i.recordDeploymentState(jobID, deployment, time.Now())
 }
 
 func hostname() string {
@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/apps"
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/labstack/echo/v4"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// suspendedLabel is the label applied to the Deployment of a VICE analysis
+// that has been scaled down to zero replicas by a suspend request.
+const suspendedLabel = "suspended"
+
+// suspendedAtAnnotation records when the Deployment of a VICE analysis was
+// suspended, and suspendedSecondsAnnotation how many seconds it spent suspended
+// before that. They keep the suspended time out of the usage records.
+const (
+	suspendedAtAnnotation      = "suspended-at"
+	suspendedSecondsAnnotation = "suspended-seconds"
+)
+
+// suspension returns the number of seconds the Deployment spent suspended
+// before it was last resumed, and the time it was suspended if it still is.
+func suspension(deployment *appsv1.Deployment) (float64, *time.Time) {
+	annotations := deployment.GetAnnotations()
+
+	seconds, err := strconv.ParseFloat(annotations[suspendedSecondsAnnotation], 64)
+	if err != nil {
+		seconds = 0
+	}
+
+	at, err := time.Parse(time.RFC3339, annotations[suspendedAtAnnotation])
+	if err != nil {
+		return seconds, nil
+	}
+
+	return seconds, &at
+}
+
+// suspendedDuration returns the total time the Deployment has spent suspended
+// up to the given time.
+func suspendedDuration(deployment *appsv1.Deployment, now time.Time) time.Duration {
+	seconds, at := suspension(deployment)
+	total := time.Duration(seconds * float64(time.Second))
+	if at != nil && now.After(*at) {
+		total += now.Sub(*at)
+	}
+	return total
+}
+
+// setAnnotation sets an annotation on the Deployment, or removes it if the
+// value is blank.
+func setAnnotation(deployment *appsv1.Deployment, key, value string) {
+	annotations := deployment.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	if value == "" {
+		delete(annotations, key)
+	} else {
+		annotations[key] = value
+	}
+
+	deployment.SetAnnotations(annotations)
+}
+
+// isSuspended returns true if the Deployment has been suspended.
+func isSuspended(deployment *appsv1.Deployment) bool {
+	return deployment.GetLabels()[suspendedLabel] == "true"
+}
+
+// analysisDeployments returns the Deployments associated with the external ID.
+func (i *Internal) analysisDeployments(externalID string) ([]appsv1.Deployment, error) {
+	set := labels.Set(map[string]string{
+		"external-id": externalID,
+	})
+
+	listoptions := metav1.ListOptions{
+		LabelSelector: set.AsSelector().String(),
+	}
+
+	deplist, err := i.clientset.AppsV1().Deployments(i.ViceNamespace).List(listoptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deplist.Items) < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no deployments found for external-id %s", externalID))
+	}
+
+	return deplist.Items, nil
+}
+
+// doSuspend scales the Deployment for the VICE analysis down to zero replicas.
+// The Service, Ingress, ConfigMaps, and persistent volume claims are left alone
+// so that the analysis can be resumed later.
+func (i *Internal) doSuspend(externalID string) error {
+	deployments, err := i.analysisDeployments(externalID)
+	if err != nil {
+		return err
+	}
+
+	depclient := i.clientset.AppsV1().Deployments(i.ViceNamespace)
+
+	for _, dep := range deployments {
+		if isSuspended(&dep) {
+			continue
+		}
+
+		dep.Spec.Replicas = int32Ptr(0)
+
+		depLabels := dep.GetLabels()
+		depLabels[suspendedLabel] = "true"
+		dep.SetLabels(depLabels)
+		setAnnotation(&dep, suspendedAtAnnotation, time.Now().UTC().Format(time.RFC3339))
+
+		if _, err = depclient.Update(&dep); err != nil {
+			return err
+		}
+	}
+
+	msg := fmt.Sprintf("analysis with external-id %s has been suspended", externalID)
+	if err = i.statusPublisher.Running(externalID, msg); err != nil {
+		log.Error(err)
+	}
+
+	return nil
+}
+
+// doResume scales the Deployment for a suspended VICE analysis back up to a
+// single replica, as long as doing so wouldn't put the user over their job limit.
+func (i *Internal) doResume(ctx context.Context, externalID string) (int, error) {
+	deployments, err := i.analysisDeployments(externalID)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	suspended := []appsv1.Deployment{}
+	for _, dep := range deployments {
+		if isSuspended(&dep) {
+			suspended = append(suspended, dep)
+		}
+	}
+
+	// There's nothing to do if the analysis is already running, and checking
+	// the limits would count it against itself.
+	if len(suspended) == 0 {
+		return http.StatusOK, nil
+	}
+
+	a := apps.NewApps(i.db, i.UserSuffix)
+
+	analysisID, err := a.GetAnalysisIDByExternalID(ctx, externalID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	user, _, err := a.GetUserByAnalysisID(ctx, analysisID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	// The suspended deployment isn't counted, so resuming it has to fit under
+	// the limit and quota just like a new launch would.
+	if status, err := i.validateUserJobLimits(ctx, user, deploymentRequestedResources(suspended)); err != nil {
+		return status, err
+	}
+
+	depclient := i.clientset.AppsV1().Deployments(i.ViceNamespace)
+	now := time.Now()
+
+	for _, dep := range suspended {
+		dep.Spec.Replicas = int32Ptr(1)
+
+		depLabels := dep.GetLabels()
+		delete(depLabels, suspendedLabel)
+		dep.SetLabels(depLabels)
+
+		seconds := suspendedDuration(&dep, now).Seconds()
+		setAnnotation(&dep, suspendedSecondsAnnotation, strconv.FormatFloat(seconds, 'f', -1, 64))
+		setAnnotation(&dep, suspendedAtAnnotation, "")
+
+		if _, err = depclient.Update(&dep); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	msg := fmt.Sprintf("analysis with external-id %s has been resumed", externalID)
+	if err = i.statusPublisher.Running(externalID, msg); err != nil {
+		log.Error(err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// SuspendHandler scales the VICE analysis down to zero replicas without
+// deleting any of the other resources associated with it. Uses the external-id
+// label to find the Deployment for the analysis.
+func (i *Internal) SuspendHandler(c echo.Context) error {
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
+	return i.doSuspend(c.Param("id"))
+}
+
+// ResumeHandler scales a suspended VICE analysis back up. The user's job limits
+// are checked before the analysis is resumed.
+func (i *Internal) ResumeHandler(c echo.Context) error {
+	if err := i.checkAnalysisAccess(c, c.Param("id"), modifyPermissionLevel); err != nil {
+		return err
+	}
+
+	status, err := i.doResume(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		if validationErr, ok := err.(common.ErrorResponse); ok {
+			return validationErr
+		}
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			return httpErr
+		}
+		return echo.NewHTTPError(status, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// testPublisher records status updates instead of sending them anywhere.
+type testPublisher struct {
+	messages []string
+}
+
+func (p *testPublisher) Fail(jobID, msg string) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *testPublisher) Success(jobID, msg string) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *testPublisher) Running(jobID, msg string) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func TestSuspend(t *testing.T) {
+	assert := assert.New(t)
+
+	externalID := testAnalyses[0].externalID
+	dep := viceDeployment(0, "vice-apps", "foo", externalID)
+	dep.Spec.Replicas = int32Ptr(1)
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+
+	assert.NoError(internal.doSuspend(*externalID), "suspending should not fail")
+
+	updated, err := internal.clientset.AppsV1().Deployments("vice-apps").Get(dep.Name, meta_v1.GetOptions{})
+	assert.NoError(err, "the deployment should still exist")
+	assert.Equal(int32(0), *updated.Spec.Replicas, "the deployment should be scaled to zero")
+	assert.True(isSuspended(updated), "the deployment should be labelled as suspended")
+	_, suspendedAt := suspension(updated)
+	assert.NotNil(suspendedAt, "the suspension time should be recorded")
+	assert.Len(publisher.messages, 1, "a status update should be sent")
+
+	// Suspended deployments are skipped without any database lookups.
+	count, err := internal.countJobsForUser(context.Background(), labelValueString("foo"))
+	assert.NoError(err, "counting jobs should not fail")
+	assert.Equal(0, count, "suspended analyses should not be counted")
+	assert.NoError(mock.ExpectationsWereMet(), "no queries should be executed")
+}
+
+func TestSuspendMissingDeployment(t *testing.T) {
+	internal, _ := setupInternal(t, []runtime.Object{})
+	defer internal.db.Close()
+	internal.statusPublisher = &testPublisher{}
+
+	assert.Error(t, internal.doSuspend("missing"), "suspending a missing analysis should fail")
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		description string
+		limit       *int
+		resumed     bool
+	}{
+		{description: "limit not reached", limit: intPointer(1), resumed: true},
+		{description: "analyses disabled", limit: intPointer(0), resumed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			externalID := testAnalyses[0].externalID
+			analysisID := testAnalyses[0].analysisID
+			dep := viceDeployment(0, "vice-apps", "foo", externalID)
+			dep.Spec.Replicas = int32Ptr(0)
+			dep.Labels[suspendedLabel] = "true"
+			dep.Annotations = map[string]string{
+				suspendedAtAnnotation:      time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+				suspendedSecondsAnnotation: "1800",
+			}
+
+			internal, mock := setupInternal(t, []runtime.Object{dep})
+			defer internal.db.Close()
+			internal.statusPublisher = &testPublisher{}
+
+			registerAnalysisIDQuery(mock, externalID, analysisID)
+			mock.ExpectQuery("SELECT u.username, u.id FROM users u").
+				WithArgs(*analysisID).
+				WillReturnRows(mock.NewRows([]string{"username", "id"}).AddRow("foo@example.org", "1"))
+			registerLimitQuery(mock, "foo", test.limit)
+			registerDefaultLimitQuery(mock, 0)
+			if test.resumed {
+				registerQuotaQueries(mock, "foo")
+			}
+
+			_, err := internal.doResume(context.Background(), *externalID)
+
+			updated, getErr := internal.clientset.AppsV1().Deployments("vice-apps").Get(dep.Name, meta_v1.GetOptions{})
+			assert.NoError(getErr, "the deployment should still exist")
+
+			if test.resumed {
+				assert.NoError(err, "resuming should not fail")
+				assert.Equal(int32(1), *updated.Spec.Replicas, "the deployment should be scaled up")
+				assert.False(isSuspended(updated), "the suspended label should be removed")
+				seconds, suspendedAt := suspension(updated)
+				assert.Nil(suspendedAt, "the suspension time should be removed")
+				assert.InDelta(5400, seconds, 60, "the suspended time should be added up")
+			} else {
+				assert.Error(err, "resuming should fail")
+				assert.Equal(int32(0), *updated.Spec.Replicas, "the deployment should not be scaled up")
+				assert.True(isSuspended(updated), "the deployment should still be suspended")
+			}
+			assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+		})
+	}
+}
+
+func TestResumeRunningAnalysis(t *testing.T) {
+	assert := assert.New(t)
+
+	dep := viceDeployment(0, "vice-apps", "foo", testAnalyses[0].externalID)
+	dep.Spec.Replicas = int32Ptr(1)
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+
+	// A user at their limit can still resume an analysis that's already
+	// running, since nothing changes.
+	status, err := internal.doResume(context.Background(), *testAnalyses[0].externalID)
+	assert.NoError(err, "resuming a running analysis should not fail")
+	assert.Equal(http.StatusOK, status)
+	assert.Empty(publisher.messages, "no status update should be sent")
+	assert.NoError(mock.ExpectationsWereMet(), "no queries should be executed")
+}
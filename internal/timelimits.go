@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/apps"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// timeLimitExitEvent is the event recorded when an analysis is shut down
+// because it reached its time limit.
+const timeLimitExitEvent = "exit"
+
+const getPlannedEndDateSQL = `
+	SELECT planned_end_date
+	  FROM jobs
+	 WHERE id = $1
+`
+
+// Each event is only recorded once per planned end date, so extending the time
+// limit allows the warnings to be sent again. Whichever replica manages to
+// insert the row is the one that sends the message for the event.
+const claimTimeLimitEventSQL = `
+	INSERT INTO vice_time_limit_events (analysis_id, planned_end_date, event)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (analysis_id, planned_end_date, event) DO NOTHING
+`
+
+// timeLimitWarningEvent returns the name of the event recorded when a warning
+// is sent the given amount of time before the time limit.
+func timeLimitWarningEvent(before time.Duration) string {
+	return fmt.Sprintf("warning-%s", before)
+}
+
+// timeLimitEvent returns the event that applies to an analysis with the given
+// planned end date, if any. Only the closest warning is returned, so an
+// analysis that's already within an hour of its time limit doesn't also get
+// the warning for a day out.
+func timeLimitEvent(plannedEnd, now time.Time, warnings []time.Duration) (string, time.Duration, bool) {
+	remaining := plannedEnd.Sub(now)
+	if remaining <= 0 {
+		return timeLimitExitEvent, 0, true
+	}
+
+	sorted := make([]time.Duration, len(warnings))
+	copy(sorted, warnings)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+
+	for _, before := range sorted {
+		if remaining <= before {
+			return timeLimitWarningEvent(before), remaining, true
+		}
+	}
+
+	return "", remaining, false
+}
+
+// claimTimeLimitEvent records the event for the analysis, returning false if
+// it had already been recorded by this or another replica.
+func (i *Internal) claimTimeLimitEvent(analysisID string, plannedEnd time.Time, event string) (bool, error) {
+	result, err := i.db.Exec(claimTimeLimitEventSQL, analysisID, plannedEnd, event)
+	if err != nil {
+		return false, errors.Wrapf(err, "error recording %s time limit event for analysis %s", event, analysisID)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// checkTimeLimit warns the user about or shuts down a single analysis based
+// on its planned end date.
+func (i *Internal) checkTimeLimit(ctx context.Context, a *apps.Apps, deployment *appsv1.Deployment, now time.Time) error {
+	depLabels := deployment.GetLabels()
+
+	externalID, ok := depLabels["external-id"]
+	if !ok {
+		return fmt.Errorf("deployment %s is missing the external-id label", deployment.GetName())
+	}
+
+	analysisID, ok := depLabels["analysis-id"]
+	if !ok {
+		var err error
+		if analysisID, err = a.GetAnalysisIDByExternalID(ctx, externalID); err != nil {
+			return errors.Wrapf(err, "error looking up the analysis ID for external-id %s", externalID)
+		}
+	}
+
+	var plannedEnd pq.NullTime
+	if err := i.db.QueryRow(getPlannedEndDateSQL, analysisID).Scan(&plannedEnd); err != nil {
+		return errors.Wrapf(err, "error looking up the planned end date for analysis %s", analysisID)
+	}
+
+	if !plannedEnd.Valid {
+		return nil
+	}
+
+	event, remaining, ok := timeLimitEvent(plannedEnd.Time, now, i.TimeLimitWarnings)
+	if !ok {
+		return nil
+	}
+
+	analysisName := depLabels["analysis-name"]
+
+	// Saving the outputs or deleting the analysis can fail, so the exit is
+	// attempted again on every check for as long as the deployment is still
+	// around. The event only keeps the user from being told more than once.
+	if event == timeLimitExitEvent {
+		if !i.startPendingExit(externalID) {
+			return nil
+		}
+
+		claimed, err := i.claimTimeLimitEvent(analysisID, plannedEnd.Time, event)
+		if err != nil {
+			i.finishPendingExit(externalID)
+			return err
+		}
+
+		if claimed {
+			msg := fmt.Sprintf("analysis %s has reached its time limit and is being saved and shut down", analysisName)
+			if err = i.statusPublisher.Running(externalID, msg); err != nil {
+				log.Error(err)
+			}
+		} else {
+			log.Infof("analysis %s is past its time limit and still running, retrying the exit", externalID)
+		}
+
+		go func() {
+			i.doSaveAndExit(context.Background(), externalID, timeLimitExitReason) // nolint:errcheck
+			i.finishPendingExit(externalID)
+		}()
+
+		return nil
+	}
+
+	claimed, err := i.claimTimeLimitEvent(analysisID, plannedEnd.Time, event)
+	if err != nil || !claimed {
+		return err
+	}
+
+	msg := fmt.Sprintf(
+		"analysis %s will reach its time limit in %s and will be saved and shut down at %s unless the time limit is extended",
+		analysisName,
+		remaining.Round(time.Minute),
+		plannedEnd.Time.Format(time.RFC1123),
+	)
+	if err = i.statusPublisher.Running(externalID, msg); err != nil {
+		log.Error(err)
+	}
+
+	return nil
+}
+
+// checkTimeLimits looks at every VICE analysis in the cluster and handles the
+// ones that are approaching or past their planned end date. Suspended analyses
+// are skipped, since there's no pod to save their outputs from. One that's
+// past its time limit is saved and shut down on the first check after it's
+// resumed.
+func (i *Internal) checkTimeLimits(ctx context.Context) {
+	deployments, err := i.deploymentList(i.ViceNamespace, map[string]string{}, []string{suspendedLabel})
+	if err != nil {
+		log.Error(errors.Wrap(err, "error listing deployments for time limit checks"))
+		return
+	}
+
+	a := apps.NewApps(i.db, i.UserSuffix)
+	now := time.Now()
+
+	for _, deployment := range deployments.Items {
+		if err = i.checkTimeLimit(ctx, a, &deployment, now); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// MonitorTimeLimits fires up a goroutine that periodically warns users whose
+// VICE analyses are about to reach their time limits and saves and shuts down
+// the analyses that have reached them. It's safe to run this in more than one
+// replica, since each warning and exit is claimed in the database first. The
+// goroutine exits when the context is cancelled.
+func (i *Internal) MonitorTimeLimits(ctx context.Context) {
+	go func() {
+		for {
+			log.Debug("checking analysis time limits")
+			i.checkTimeLimits(ctx)
+			if !sleepContext(ctx, i.TimeLimitCheckInterval) {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/app-exposer/apps"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestTimeLimitEvent(t *testing.T) {
+	now := time.Now()
+	warnings := []time.Duration{time.Hour, 24 * time.Hour}
+
+	tests := []struct {
+		description string
+		plannedEnd  time.Time
+		expected    string
+		ok          bool
+	}{
+		{"far from the time limit", now.Add(72 * time.Hour), "", false},
+		{"within a day", now.Add(12 * time.Hour), "warning-24h0m0s", true},
+		{"within an hour", now.Add(30 * time.Minute), "warning-1h0m0s", true},
+		{"past the time limit", now.Add(-time.Minute), timeLimitExitEvent, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			event, _, ok := timeLimitEvent(test.plannedEnd, now, warnings)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expected, event)
+		})
+	}
+}
+
+func TestCheckTimeLimitWarning(t *testing.T) {
+	tests := []struct {
+		description string
+		claimed     bool
+		messages    int
+	}{
+		{"warning not sent yet", true, 1},
+		{"warning already sent by another replica", false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			externalID := testAnalyses[0].externalID
+			analysisID := testAnalyses[0].analysisID
+			dep := viceDeployment(0, "vice-apps", "foo", externalID)
+
+			internal, mock := setupInternal(t, []runtime.Object{dep})
+			defer internal.db.Close()
+			publisher := &testPublisher{}
+			internal.statusPublisher = publisher
+			internal.TimeLimitWarnings = []time.Duration{time.Hour}
+
+			now := time.Now()
+			plannedEnd := now.Add(30 * time.Minute)
+
+			var rowsAffected int64
+			if test.claimed {
+				rowsAffected = 1
+			}
+
+			registerAnalysisIDQuery(mock, externalID, analysisID)
+			mock.ExpectQuery("SELECT planned_end_date FROM jobs").
+				WithArgs(*analysisID).
+				WillReturnRows(mock.NewRows([]string{"planned_end_date"}).AddRow(plannedEnd))
+			mock.ExpectExec("INSERT INTO vice_time_limit_events").
+				WithArgs(*analysisID, plannedEnd, "warning-1h0m0s").
+				WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+
+			a := apps.NewApps(internal.db, internal.UserSuffix)
+			assert.NoError(internal.checkTimeLimit(context.Background(), a, dep, now), "checking the time limit should not fail")
+			assert.Len(publisher.messages, test.messages)
+			assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+		})
+	}
+}
+
+func TestCheckTimeLimitExitInProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	externalID := testAnalyses[0].externalID
+	analysisID := testAnalyses[0].analysisID
+	dep := viceDeployment(0, "vice-apps", "foo", externalID)
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	publisher := &testPublisher{}
+	internal.statusPublisher = publisher
+
+	now := time.Now()
+
+	registerAnalysisIDQuery(mock, externalID, analysisID)
+	mock.ExpectQuery("SELECT planned_end_date FROM jobs").
+		WithArgs(*analysisID).
+		WillReturnRows(mock.NewRows([]string{"planned_end_date"}).AddRow(now.Add(-time.Minute)))
+
+	// The exit shouldn't be claimed or started again while the last attempt
+	// is still saving the outputs.
+	assert.True(internal.startPendingExit(*externalID))
+
+	a := apps.NewApps(internal.db, internal.UserSuffix)
+	assert.NoError(internal.checkTimeLimit(context.Background(), a, dep, now), "checking the time limit should not fail")
+	assert.Empty(publisher.messages)
+	assert.NoError(mock.ExpectationsWereMet(), "the correct queries should be executed")
+
+	internal.finishPendingExit(*externalID)
+	assert.True(internal.startPendingExit(*externalID), "the exit should be retried once the last attempt is done")
+}
+
+func TestCheckTimeLimitsSkipsSuspended(t *testing.T) {
+	dep := viceDeployment(0, "vice-apps", "foo", testAnalyses[0].externalID)
+	dep.Labels["app-type"] = "interactive"
+	dep.Labels["analysis-id"] = *testAnalyses[0].analysisID
+	dep.Labels[suspendedLabel] = "true"
+
+	internal, mock := setupInternal(t, []runtime.Object{dep})
+	defer internal.db.Close()
+	internal.statusPublisher = &testPublisher{}
+
+	mock.ExpectQuery("SELECT planned_end_date FROM jobs").
+		WithArgs(*testAnalyses[0].analysisID).
+		WillReturnRows(mock.NewRows([]string{"planned_end_date"}).AddRow(time.Now().Add(-time.Minute)))
+
+	internal.checkTimeLimits(context.Background())
+	assert.Error(t, mock.ExpectationsWereMet(), "the planned end date of a suspended analysis should not be looked up")
+}
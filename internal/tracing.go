@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cyverse-de/app-exposer/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// startK8sSpan starts a span for a call to the Kubernetes API made on behalf
+// of the request in the context. The version of client-go in use doesn't accept
+// a context, so the spans recorded by the traced transport can't be tied to
+// the request that made the call.
+func (i *Internal) startK8sSpan(ctx context.Context, verb, resource, name string) trace.Span {
+	_, span := tracing.Start(
+		ctx,
+		fmt.Sprintf("k8s %s %s", verb, resource),
+		attribute.String("k8s.namespace", i.ViceNamespace),
+		attribute.String("k8s.name", name),
+	)
+	return span
+}
+
+// endK8sSpan ends a span started by startK8sSpan. Not found errors are
+// expected when checking whether an object exists, so they aren't recorded as
+// errors.
+func endK8sSpan(span trace.Span, err error) {
+	if k8serrors.IsNotFound(err) {
+		span.SetAttributes(attribute.Bool("k8s.not_found", true))
+		err = nil
+	}
+	tracing.End(span, err)
+}
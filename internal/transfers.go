@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"path"
 	"sync"
 	"time"
 
+	"github.com/cyverse-de/app-exposer/metrics"
 	"github.com/cyverse-de/model"
 
 	"github.com/pkg/errors"
@@ -19,6 +19,10 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 )
 
+// transferClient records the latency of requests to the file transfer
+// containers in VICE analyses.
+var transferClient = metrics.NewClient("file-transfers")
+
 const (
 	// RequestedStatus means the the transfer has been requested but hasn't started
 	RequestedStatus = "requested"
@@ -113,7 +117,7 @@ func requestTransfer(svc apiv1.Service, reqpath string) (*transferResponse, erro
 	svcurl.Host = fmt.Sprintf("%s.%s:%d", svc.Name, svc.Namespace, fileTransfersPort)
 	svcurl.Path = reqpath
 
-	resp, posterr := http.Post(svcurl.String(), "", nil)
+	resp, posterr := transferClient.Post(svcurl.String(), "", nil)
 	if posterr != nil {
 		return nil, errors.Wrapf(posterr, "error POSTing to %s", svcurl.String())
 	}
@@ -153,7 +157,7 @@ func getTransferDetails(id string, svc apiv1.Service, reqpath string) (*transfer
 	svcurl.Host = fmt.Sprintf("%s.%s:%d", svc.Name, svc.Namespace, fileTransfersPort)
 	svcurl.Path = reqpath
 
-	resp, posterr := http.Get(svcurl.String())
+	resp, posterr := transferClient.Get(svcurl.String())
 	if posterr != nil {
 		return nil, errors.Wrapf(posterr, "error on GET %s", svcurl.String())
 	}
@@ -246,6 +250,10 @@ func (i *Internal) doFileTransfer(externalID, reqpath, kind string, async bool)
 
 			log.Infof("%s transfer for %s", kind, externalID)
 
+			start := time.Now()
+			succeeded := false
+			defer func() { metrics.RecordFileTransfer(kind, start, succeeded) }()
+
 			transferObj, xfererr := requestTransfer(svc, reqpath)
 			if xfererr != nil {
 				log.Error(xfererr)
@@ -282,6 +290,8 @@ func (i *Internal) doFileTransfer(externalID, reqpath, kind string, async bool)
 
 					log.Info(msg)
 
+					succeeded = true
+
 					if successerr := i.statusPublisher.Running(externalID, msg); successerr != nil {
 						log.Error(successerr)
 					}
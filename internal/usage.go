@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// ResourceUsage compares the amount of a resource a container is using with the
+// amount it requested and its limit. CPU is in millicores, and memory and
+// storage are in bytes. The usage is left out if it isn't known, and the
+// request and limit are zero if they aren't set.
+type ResourceUsage struct {
+	Usage            *int64   `json:"usage,omitempty"`
+	Request          int64    `json:"request"`
+	Limit            int64    `json:"limit"`
+	PercentOfRequest *float64 `json:"percent_of_request,omitempty"`
+	PercentOfLimit   *float64 `json:"percent_of_limit,omitempty"`
+}
+
+// ContainerUsage contains the resource usage of a container in an analysis.
+type ContainerUsage struct {
+	Pod              string        `json:"pod"`
+	Container        string        `json:"container"`
+	CPU              ResourceUsage `json:"cpu"`
+	Memory           ResourceUsage `json:"memory"`
+	EphemeralStorage ResourceUsage `json:"ephemeral_storage"`
+}
+
+// PodUsage contains the ephemeral storage used by a pod as a whole, which
+// includes its emptyDir volumes as well as its containers' writable layers and
+// logs. The request and limit are the totals for the pod's containers, which
+// are what the kubelet compares the usage to when it evicts pods.
+type PodUsage struct {
+	Pod              string        `json:"pod"`
+	EphemeralStorage ResourceUsage `json:"ephemeral_storage"`
+}
+
+// AnalysisUsage contains the resource usage of every container in an analysis.
+type AnalysisUsage struct {
+	ExternalID string `json:"external_id"`
+
+	// Timestamp is when the CPU and memory usage were sampled.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	Containers []ContainerUsage `json:"containers"`
+	Pods       []PodUsage       `json:"pods"`
+}
+
+// fsStats is the part of the kubelet's file system stats used here.
+type fsStats struct {
+	UsedBytes *uint64 `json:"usedBytes"`
+}
+
+// used returns the number of bytes used, or zero if it isn't known.
+func (fs *fsStats) used() int64 {
+	if fs == nil || fs.UsedBytes == nil {
+		return 0
+	}
+	return int64(*fs.UsedBytes)
+}
+
+// kubeletSummary is the part of the kubelet's stats summary used to find out
+// how much ephemeral storage the pods and their containers are using.
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name   string   `json:"name"`
+			Rootfs *fsStats `json:"rootfs"`
+			Logs   *fsStats `json:"logs"`
+		} `json:"containers"`
+		Volumes []struct {
+			fsStats
+			Name   string    `json:"name"`
+			PVCRef *struct{} `json:"pvcRef"`
+		} `json:"volume"`
+		EphemeralStorage *fsStats `json:"ephemeral-storage"`
+	} `json:"pods"`
+}
+
+// summaryReader returns the stats summary of the kubelet on a node.
+type summaryReader func(nodeName string) (*kubeletSummary, error)
+
+// kubernetesSummaryReader returns a summaryReader that goes through the node
+// proxy in the Kubernetes API. Requests that take longer than the timeout are
+// abandoned, unless the timeout is zero.
+func kubernetesSummaryReader(clientset kubernetes.Interface, timeout time.Duration) summaryReader {
+	return func(nodeName string) (*kubeletSummary, error) {
+		req := clientset.CoreV1().RESTClient().
+			Get().
+			Resource("nodes").
+			Name(nodeName).
+			SubResource("proxy").
+			Suffix("stats/summary")
+		if timeout > 0 {
+			req = req.Timeout(timeout)
+		}
+
+		data, err := req.DoRaw()
+		if err != nil {
+			return nil, err
+		}
+
+		summary := &kubeletSummary{}
+		if err = json.Unmarshal(data, summary); err != nil {
+			return nil, err
+		}
+
+		return summary, nil
+	}
+}
+
+// percentOf returns value as a percentage of total, or nil if total is zero.
+func percentOf(value, total int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	percent := float64(value) * 100 / float64(total)
+	return &percent
+}
+
+// quantityValue returns the value of the quantity, in millicores for CPU.
+func quantityValue(name apiv1.ResourceName, q resource.Quantity) int64 {
+	if name == apiv1.ResourceCPU {
+		return q.MilliValue()
+	}
+	return q.Value()
+}
+
+// newResourceUsage compares the usage of the named resource to the container's
+// requests and limits. The usage may be nil if it isn't known.
+func newResourceUsage(name apiv1.ResourceName, usage *int64, reqs apiv1.ResourceRequirements) ResourceUsage {
+	u := ResourceUsage{Usage: usage}
+	if q, ok := reqs.Requests[name]; ok {
+		u.Request = quantityValue(name, q)
+	}
+	if q, ok := reqs.Limits[name]; ok {
+		u.Limit = quantityValue(name, q)
+	}
+	if usage != nil {
+		u.PercentOfRequest = percentOf(*usage, u.Request)
+		u.PercentOfLimit = percentOf(*usage, u.Limit)
+	}
+	return u
+}
+
+// nodeStorage is the ephemeral storage used by the pods on a node and by
+// their containers.
+type nodeStorage struct {
+	// containers is keyed by pod and container name.
+	containers map[string]int64
+
+	// pods is keyed by pod name.
+	pods map[string]int64
+}
+
+// storageUsage returns the ephemeral storage used by each pod on the node and
+// by each of their containers. A pod's usage includes its emptyDir volumes,
+// which don't belong to any one container. The kubelet works the pod's total
+// out itself, and it's added up from the containers and the volumes that
+// aren't persistent volume claims if the kubelet didn't report it.
+func storageUsage(summary *kubeletSummary, namespace string) *nodeStorage {
+	usage := &nodeStorage{
+		containers: map[string]int64{},
+		pods:       map[string]int64{},
+	}
+	for _, pod := range summary.Pods {
+		if pod.PodRef.Namespace != namespace {
+			continue
+		}
+
+		var podUsed int64
+		for _, container := range pod.Containers {
+			used := container.Rootfs.used() + container.Logs.used()
+			usage.containers[pod.PodRef.Name+"/"+container.Name] = used
+			podUsed += used
+		}
+
+		if pod.EphemeralStorage != nil && pod.EphemeralStorage.UsedBytes != nil {
+			podUsed = pod.EphemeralStorage.used()
+		} else {
+			for _, volume := range pod.Volumes {
+				if volume.PVCRef == nil {
+					podUsed += volume.used()
+				}
+			}
+		}
+		usage.pods[pod.PodRef.Name] = podUsed
+	}
+	return usage
+}
+
+// podStorageRequirements returns the total ephemeral storage requested by the
+// pod's containers and the total of their limits.
+func podStorageRequirements(spec *apiv1.PodSpec) apiv1.ResourceRequirements {
+	var request, limit resource.Quantity
+	reqs := apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{},
+		Limits:   apiv1.ResourceList{},
+	}
+	for _, container := range spec.Containers {
+		if q, ok := container.Resources.Requests[apiv1.ResourceEphemeralStorage]; ok {
+			request.Add(q)
+			reqs.Requests[apiv1.ResourceEphemeralStorage] = request
+		}
+		if q, ok := container.Resources.Limits[apiv1.ResourceEphemeralStorage]; ok {
+			limit.Add(q)
+			reqs.Limits[apiv1.ResourceEphemeralStorage] = limit
+		}
+	}
+	return reqs
+}
+
+// readNodeStorage reads the stats summaries of the nodes concurrently and
+// returns the storage used on each of them. Nodes whose summaries can't be
+// read are left out.
+func (i *Internal) readNodeStorage(nodeNames map[string]bool) map[string]*nodeStorage {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		storage = map[string]*nodeStorage{}
+	)
+
+	for nodeName := range nodeNames {
+		wg.Add(1)
+		go func(nodeName string) {
+			defer wg.Done()
+
+			summary, err := i.kubeletSummary(nodeName)
+			if err != nil {
+				log.Error(errors.Wrapf(err, "error reading the stats summary for node %s", nodeName))
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			storage[nodeName] = storageUsage(summary, i.ViceNamespace)
+		}(nodeName)
+	}
+
+	wg.Wait()
+	return storage
+}
+
+// collectUsage returns the resource usage of the analyses with pods that have
+// the given labels, keyed by external ID. The CPU and memory usage are read
+// from the metrics API, and the ephemeral storage usage from the kubelets. Any
+// usage that can't be read is left out.
+func (i *Internal) collectUsage(filter map[string]string) (map[string]*AnalysisUsage, error) {
+	if i.metricsClient == nil {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "resource usage is unavailable")
+	}
+
+	pods, err := i.podList(i.ViceNamespace, filter, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing pods")
+	}
+
+	podMetrics := map[string]*metricsv1beta1.PodMetrics{}
+	metricsList, err := i.metricsClient.MetricsV1beta1().PodMetricses(i.ViceNamespace).List(getListOptions(filter, []string{}))
+	if err != nil {
+		log.Error(errors.Wrap(err, "error reading pod metrics"))
+	} else {
+		for idx := range metricsList.Items {
+			podMetrics[metricsList.Items[idx].Name] = &metricsList.Items[idx]
+		}
+	}
+
+	// Each node's summary covers every pod on it, so it's only read once.
+	nodeNames := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" {
+			nodeNames[pod.Spec.NodeName] = true
+		}
+	}
+	storage := i.readNodeStorage(nodeNames)
+
+	usages := map[string]*AnalysisUsage{}
+	for _, pod := range pods.Items {
+		externalID := pod.Labels["external-id"]
+		usage, ok := usages[externalID]
+		if !ok {
+			usage = &AnalysisUsage{ExternalID: externalID, Containers: []ContainerUsage{}, Pods: []PodUsage{}}
+			usages[externalID] = usage
+		}
+
+		containerMetrics := map[string]apiv1.ResourceList{}
+		if m, ok := podMetrics[pod.Name]; ok {
+			timestamp := m.Timestamp.Time
+			usage.Timestamp = &timestamp
+			for _, c := range m.Containers {
+				containerMetrics[c.Name] = c.Usage
+			}
+		}
+
+		nodeStorage := storage[pod.Spec.NodeName]
+
+		var podEphemeral *int64
+		if nodeStorage != nil {
+			if used, ok := nodeStorage.pods[pod.Name]; ok {
+				podEphemeral = &used
+			}
+		}
+		usage.Pods = append(usage.Pods, PodUsage{
+			Pod:              pod.Name,
+			EphemeralStorage: newResourceUsage(apiv1.ResourceEphemeralStorage, podEphemeral, podStorageRequirements(&pod.Spec)),
+		})
+
+		for _, container := range pod.Spec.Containers {
+			var cpu, memory, ephemeral *int64
+			if resources, ok := containerMetrics[container.Name]; ok {
+				if q, ok := resources[apiv1.ResourceCPU]; ok {
+					v := q.MilliValue()
+					cpu = &v
+				}
+				if q, ok := resources[apiv1.ResourceMemory]; ok {
+					v := q.Value()
+					memory = &v
+				}
+			}
+			if nodeStorage != nil {
+				if used, ok := nodeStorage.containers[pod.Name+"/"+container.Name]; ok {
+					ephemeral = &used
+				}
+			}
+
+			usage.Containers = append(usage.Containers, ContainerUsage{
+				Pod:              pod.Name,
+				Container:        container.Name,
+				CPU:              newResourceUsage(apiv1.ResourceCPU, cpu, container.Resources),
+				Memory:           newResourceUsage(apiv1.ResourceMemory, memory, container.Resources),
+				EphemeralStorage: newResourceUsage(apiv1.ResourceEphemeralStorage, ephemeral, container.Resources),
+			})
+		}
+	}
+
+	return usages, nil
+}
+
+// collectUsageContext collects the usage like collectUsage, but gives up once
+// the context is done. The requests that are still running finish in the
+// background.
+func (i *Internal) collectUsageContext(ctx context.Context, filter map[string]string) (map[string]*AnalysisUsage, error) {
+	type result struct {
+		usages map[string]*AnalysisUsage
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		usages, err := i.collectUsage(filter)
+		done <- result{usages, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.usages, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "gave up reading resource usage")
+	}
+}
+
+// newMetricsClient returns a client for the metrics API, or nil if there's no
+// Kubernetes client config to create it from.
+func newMetricsClient(init *Init) metricsclient.Interface {
+	if init.KubernetesConfig == nil {
+		return nil
+	}
+
+	client, err := metricsclient.NewForConfig(init.KubernetesConfig)
+	if err != nil {
+		log.Error(errors.Wrap(err, "error creating the metrics client"))
+		return nil
+	}
+
+	return client
+}
+
+// UsageHandler returns the current CPU, memory and ephemeral storage usage of
+// each container in the analysis, along with the container's requests and
+// limits.
+func (i *Internal) UsageHandler(c echo.Context) error {
+	id := c.Param("analysis-id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id parameter is empty")
+	}
+
+	user := i.callerUsername(c)
+	if user == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "user is not set")
+	}
+
+	externalID, err := i.analysisExternalID(user, id)
+	if err != nil {
+		return err
+	}
+
+	usages, err := i.collectUsage(map[string]string{"external-id": externalID})
+	if err != nil {
+		return err
+	}
+
+	usage, ok := usages[externalID]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no pods found for analysis %s", id))
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+const testSummary = `{
+	"pods": [
+		{
+			"podRef": {"name": "analysis-pod", "namespace": "vice-apps"},
+			"containers": [
+				{"name": "analysis", "rootfs": {"usedBytes": 1000}, "logs": {"usedBytes": 24}},
+				{"name": "vice-proxy", "rootfs": {"usedBytes": 10}}
+			],
+			"volume": [
+				{"name": "scratch", "usedBytes": 500},
+				{"name": "data", "usedBytes": 100000, "pvcRef": {"name": "data", "namespace": "vice-apps"}}
+			],
+			"ephemeral-storage": {"usedBytes": 1600}
+		},
+		{
+			"podRef": {"name": "analysis-pod", "namespace": "elsewhere"},
+			"containers": [{"name": "analysis", "rootfs": {"usedBytes": 99999}}]
+		}
+	]
+}`
+
+func TestCollectUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := map[string]string{"external-id": "external-id", "app-type": "interactive"}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    labels,
+		},
+		Spec: apiv1.PodSpec{
+			NodeName: "node-1",
+			Containers: []apiv1.Container{
+				{
+					Name: "analysis",
+					Resources: apiv1.ResourceRequirements{
+						Requests: apiv1.ResourceList{
+							apiv1.ResourceCPU:    resource.MustParse("1"),
+							apiv1.ResourceMemory: resource.MustParse("2Gi"),
+						},
+						Limits: apiv1.ResourceList{
+							apiv1.ResourceCPU:              resource.MustParse("2"),
+							apiv1.ResourceMemory:           resource.MustParse("4Gi"),
+							apiv1.ResourceEphemeralStorage: resource.MustParse("2000"),
+						},
+					},
+				},
+				{Name: "vice-proxy"},
+			},
+		},
+	}
+
+	sampled := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	podMetrics := &metricsv1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    labels,
+		},
+		Timestamp: metav1.NewTime(sampled),
+		Containers: []metricsv1beta1.ContainerMetrics{
+			{
+				Name: "analysis",
+				Usage: apiv1.ResourceList{
+					apiv1.ResourceCPU:    resource.MustParse("500m"),
+					apiv1.ResourceMemory: resource.MustParse("3Gi"),
+				},
+			},
+		},
+	}
+
+	i, _ := setupInternal(t, []runtime.Object{pod})
+	// The fake client reads pod metrics from the pods resource, which isn't
+	// where NewSimpleClientset would put them.
+	metrics := metricsfake.NewSimpleClientset()
+	podMetricsResource := schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+	if err := metrics.Tracker().Create(podMetricsResource, podMetrics, testConfig.ViceNamespace); err != nil {
+		t.Fatal(err)
+	}
+	i.metricsClient = metrics
+	i.kubeletSummary = func(nodeName string) (*kubeletSummary, error) {
+		assert.Equal("node-1", nodeName)
+		summary := &kubeletSummary{}
+		return summary, json.Unmarshal([]byte(testSummary), summary)
+	}
+
+	usages, err := i.collectUsage(map[string]string{"external-id": "external-id"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	usage := usages["external-id"]
+	if !assert.NotNil(usage) || !assert.Len(usage.Containers, 2) || !assert.NotNil(usage.Timestamp) {
+		return
+	}
+	assert.Equal(sampled, usage.Timestamp.UTC())
+
+	analysis := usage.Containers[0]
+	assert.Equal("analysis", analysis.Container)
+	assert.Equal(int64(500), *analysis.CPU.Usage)
+	assert.Equal(int64(1000), analysis.CPU.Request)
+	assert.Equal(50.0, *analysis.CPU.PercentOfRequest)
+	assert.Equal(25.0, *analysis.CPU.PercentOfLimit)
+	assert.Equal(int64(3*1024*1024*1024), *analysis.Memory.Usage)
+	assert.Equal(75.0, *analysis.Memory.PercentOfLimit)
+	assert.Equal(int64(1024), *analysis.EphemeralStorage.Usage)
+	assert.Nil(analysis.EphemeralStorage.PercentOfRequest)
+	assert.Equal(51.2, *analysis.EphemeralStorage.PercentOfLimit)
+
+	proxy := usage.Containers[1]
+	assert.Nil(proxy.CPU.Usage)
+	assert.Nil(proxy.CPU.PercentOfLimit)
+	assert.Equal(int64(10), *proxy.EphemeralStorage.Usage)
+
+	// The pod's usage includes its emptyDir volume.
+	if assert.Len(usage.Pods, 1) {
+		podUsage := usage.Pods[0]
+		assert.Equal("analysis-pod", podUsage.Pod)
+		assert.Equal(int64(1600), *podUsage.EphemeralStorage.Usage)
+		assert.Equal(int64(2000), podUsage.EphemeralStorage.Limit)
+		assert.Equal(80.0, *podUsage.EphemeralStorage.PercentOfLimit)
+	}
+}
+
+func TestStorageUsageWithoutPodTotal(t *testing.T) {
+	summary := &kubeletSummary{}
+	if err := json.Unmarshal([]byte(testSummary), summary); err != nil {
+		t.Fatal(err)
+	}
+	summary.Pods[0].EphemeralStorage = nil
+
+	// The containers and the emptyDir volume are added up, but the
+	// persistent volume claim isn't.
+	usage := storageUsage(summary, testConfig.ViceNamespace)
+	assert.Equal(t, int64(1000+24+10+500), usage.pods["analysis-pod"])
+	assert.Equal(t, int64(1024), usage.containers["analysis-pod/analysis"])
+}
+
+func TestCollectUsageContextTimeout(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "analysis-pod",
+			Namespace: testConfig.ViceNamespace,
+			Labels:    map[string]string{"external-id": "external-id", "app-type": "interactive"},
+		},
+		Spec: apiv1.PodSpec{NodeName: "node-1"},
+	}
+
+	i, _ := setupInternal(t, []runtime.Object{pod})
+	i.metricsClient = metricsfake.NewSimpleClientset()
+
+	release := make(chan struct{})
+	defer close(release)
+	i.kubeletSummary = func(nodeName string) (*kubeletSummary, error) {
+		<-release
+		return &kubeletSummary{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := i.collectUsageContext(ctx, map[string]string{})
+	assert.Error(t, err, "collecting the usage should give up when the context is done")
+}
+
+func TestCollectUsageUnavailable(t *testing.T) {
+	i, _ := setupInternal(t, nil)
+	i.metricsClient = nil
+
+	_, err := i.collectUsage(map[string]string{})
+	assert.Error(t, err)
+}
@@ -1,30 +1,74 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 
+	"github.com/cyverse-de/app-exposer/auth"
 	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/app-exposer/groups"
+	"github.com/cyverse-de/app-exposer/internal"
+	"github.com/cyverse-de/app-exposer/metrics"
+	"github.com/cyverse-de/app-exposer/tracing"
 	"github.com/cyverse-de/configurate"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/transport"
 	"k8s.io/klog" // pull in to set klog output to stderr
 )
 
 var log = common.Log
 
+// durationMap reads a map of names to durations from the config, such as the
+// per-app idle timeouts. Viper lowercases map keys, so it shouldn't be used
+// for anything keyed by a case-sensitive name such as a username.
+func durationMap(cfg *viper.Viper, key string) (map[string]time.Duration, error) {
+	retval := map[string]time.Duration{}
+
+	for name, value := range cfg.GetStringMapString(key) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse the duration for %s in %s", name, key)
+		}
+		retval[name] = d
+	}
+
+	return retval, nil
+}
+
+// durationList reads a list of durations from the config, such as the times
+// before a time limit at which users are warned.
+func durationList(cfg *viper.Viper, key string) ([]time.Duration, error) {
+	retval := []time.Duration{}
+
+	for _, value := range cfg.GetStringSlice(key) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse the duration %s in %s", value, key)
+		}
+		retval = append(retval, d)
+	}
+
+	return retval, nil
+}
+
 func init() {
 	// Set klog, used by the k8s client, to use its "log to stderr"
 	// functionality. Otherwise, it'll crash without a /tmp directory, and
@@ -151,6 +195,8 @@ func main() {
 		}
 	}
 
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, tracing.NewKubernetesTransport)
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatal(errors.Wrap(err, "error creating clientset from config"))
@@ -184,8 +230,163 @@ func main() {
 		proxyImage = fmt.Sprintf("%s:%s", *viceProxy, proxyTag)
 	}
 
+	cfg.SetDefault("vice.idle.enabled", false)
+	cfg.SetDefault("vice.idle.timeout", "24h")
+	cfg.SetDefault("vice.idle.grace-period", "1h")
+	cfg.SetDefault("vice.idle.check-interval", "5m")
+
+	appIdleTimeouts, err := durationMap(cfg, "vice.idle.apps")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var userIdleTimeouts []internal.UserIdleTimeout
+	if err = cfg.UnmarshalKey("vice.idle.users", &userIdleTimeouts); err != nil {
+		log.Fatal(errors.Wrap(err, "can't parse vice.idle.users in the config file"))
+	}
+
+	cfg.SetDefault("vice.time-limits.enabled", true)
+	cfg.SetDefault("vice.time-limits.warnings", []string{"24h", "1h"})
+	cfg.SetDefault("vice.time-limits.check-interval", "1m")
+
+	timeLimitWarnings, err := durationList(cfg, "vice.time-limits.warnings")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.SetDefault("vice.time-limits.default-extension", "72h")
+
+	var timeLimitPolicy internal.TimeLimitPolicy
+	if err = cfg.UnmarshalKey("vice.time-limits.policy", &timeLimitPolicy); err != nil {
+		log.Fatal(errors.Wrap(err, "can't parse vice.time-limits.policy in the config file"))
+	}
+
+	var userTimeLimitPolicies []internal.UserTimeLimitPolicy
+	if err = cfg.UnmarshalKey("vice.time-limits.users", &userTimeLimitPolicies); err != nil {
+		log.Fatal(errors.Wrap(err, "can't parse vice.time-limits.users in the config file"))
+	}
+
+	groupTimeLimitPolicies := map[string]internal.GroupTimeLimitPolicy{}
+	if err = cfg.UnmarshalKey("vice.time-limits.groups", &groupTimeLimitPolicies); err != nil {
+		log.Fatal(errors.Wrap(err, "can't parse vice.time-limits.groups in the config file"))
+	}
+
+	cfg.SetDefault("vice.reconciler.enabled", true)
+	cfg.SetDefault("vice.reconciler.interval", "10m")
+	cfg.SetDefault("vice.reconciler.grace-period", "1h")
+	cfg.SetDefault("vice.reconciler.cleanup", false)
+
+	cfg.SetDefault("vice.pod-failures.enabled", true)
+	cfg.SetDefault("vice.pod-failures.max-restarts", 5)
+	cfg.SetDefault("vice.pod-failures.image-pull-timeout", "10m")
+	cfg.SetDefault("vice.pod-failures.exit-on-failure", false)
+
+	cfg.SetDefault("vice.events.enabled", true)
+	cfg.SetDefault("vice.events.dedupe-window", "10m")
+
+	cfg.SetDefault("vice.job-status.timeout", "30s")
+	cfg.SetDefault("vice.job-status.min-interval", "30s")
+	cfg.SetDefault("vice.job-status.outbox.poll-interval", "1s")
+	cfg.SetDefault("vice.job-status.outbox.batch-size", 100)
+	cfg.SetDefault("vice.job-status.outbox.initial-backoff", "1s")
+	cfg.SetDefault("vice.job-status.outbox.max-backoff", "5m")
+	cfg.SetDefault("vice.job-status.outbox.max-attempts", 20)
+	cfg.SetDefault("vice.job-status.outbox.retention", "168h")
+
+	cfg.SetDefault("vice.job-status.transport", internal.HTTPStatusTransport)
+	cfg.SetDefault("amqp.exchange.name", "de")
+
+	statusTransport := cfg.GetString("vice.job-status.transport")
+	switch statusTransport {
+	case internal.HTTPStatusTransport:
+	case internal.AMQPStatusTransport:
+		if cfg.GetString("amqp.uri") == "" {
+			log.Fatal("amqp.uri must be set when vice.job-status.transport is amqp")
+		}
+	default:
+		log.Fatalf("unsupported vice.job-status.transport %s", statusTransport)
+	}
+
+	cfg.SetDefault("vice.logs.heartbeat-interval", "15s")
+	cfg.SetDefault("vice.logs.max-streams-per-user", 5)
+	cfg.SetDefault("vice.logs.max-stream-duration", "1h")
+	cfg.SetDefault("vice.logs.archive.enabled", true)
+	cfg.SetDefault("vice.logs.archive.tail-lines", 10000)
+	cfg.SetDefault("vice.logs.archive.timeout", "30s")
+	cfg.SetDefault("vice.usage.timeout", "5s")
+	cfg.SetDefault("vice.usage.reconcile-interval", "10m")
+
+	cfg.SetDefault("groups.base", "")
+	cfg.SetDefault("groups.user", "de_grouper")
+	cfg.SetDefault("groups.timeout", "5s")
+
+	groupMembers := []groups.Membership{}
+	if err = cfg.UnmarshalKey("groups.members", &groupMembers); err != nil {
+		log.Fatal(errors.Wrap(err, "can't parse groups.members in the config file"))
+	}
+
+	cfg.SetDefault("metrics.node-pool-label", "node-pool")
+
+	cfg.SetDefault("auth.enabled", false)
+	cfg.SetDefault("auth.trusted-service-mode", true)
+	cfg.SetDefault("auth.support-roles", []string{"vice-support"})
+	cfg.SetDefault("auth.operator-roles", []string{"vice-operators"})
+	cfg.SetDefault("auth.service-roles", []string{})
+
+	cfg.SetDefault("tracing.enabled", false)
+	cfg.SetDefault("tracing.insecure", false)
+
+	cfg.SetDefault("leader-election.enabled", true)
+	cfg.SetDefault("leader-election.lease-name", "app-exposer")
+	cfg.SetDefault("leader-election.lease-duration", "15s")
+	cfg.SetDefault("leader-election.renew-deadline", "10s")
+	cfg.SetDefault("leader-election.retry-period", "2s")
+
+	shutdownTracing, err := tracing.Init(context.Background(), &tracing.Config{
+		Enabled:  cfg.GetBool("tracing.enabled"),
+		Endpoint: cfg.GetString("tracing.endpoint"),
+		Insecure: cfg.GetBool("tracing.insecure"),
+	})
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error setting up tracing"))
+	}
+
 	dbURI := cfg.GetString("db.uri")
-	db = sqlx.MustConnect("postgres", dbURI)
+	db, err = tracing.OpenDB(dbURI)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error connecting to the database"))
+	}
+
+	var authenticator *auth.Authenticator
+	if cfg.GetBool("auth.enabled") {
+		keycloakBaseURL := cfg.GetString("keycloak.base")
+		keycloakRealm := cfg.GetString("keycloak.realm")
+
+		// A local key set file can stand in for Keycloak's.
+		var keys auth.KeySet
+		if keySetFile := cfg.GetString("auth.jwks-file"); keySetFile != "" {
+			keys, err = auth.LoadKeySetFile(keySetFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			keys = auth.NewRemoteKeySet(
+				auth.KeycloakJWKSURL(keycloakBaseURL, keycloakRealm),
+				metrics.NewClient("keycloak"),
+			)
+		}
+
+		authenticator = auth.New(&auth.Config{
+			Issuer:             auth.KeycloakIssuer(keycloakBaseURL, keycloakRealm),
+			Audience:           cfg.GetString("auth.audience"),
+			UserSuffix:         *userSuffix,
+			TrustedServiceMode: cfg.GetBool("auth.trusted-service-mode"),
+			Keys:               keys,
+			SupportRoles:       cfg.GetStringSlice("auth.support-roles"),
+			OperatorRoles:      cfg.GetStringSlice("auth.operator-roles"),
+			ServiceRoles:       cfg.GetStringSlice("auth.service-roles"),
+		})
+	}
 
 	exposerInit := &ExposerAppInit{
 		Namespace:                     *namespace,
@@ -214,10 +415,120 @@ func main() {
 		KeycloakRealm:                 cfg.GetString("keycloak.realm"),
 		KeycloakClientID:              cfg.GetString("keycloak.client-id"),
 		KeycloakClientSecret:          cfg.GetString("keycloak.client-secret"),
+		IdleTimeout:                   cfg.GetDuration("vice.idle.timeout"),
+		IdleGracePeriod:               cfg.GetDuration("vice.idle.grace-period"),
+		IdleCheckInterval:             cfg.GetDuration("vice.idle.check-interval"),
+		AppIdleTimeouts:               appIdleTimeouts,
+		UserIdleTimeouts:              userIdleTimeouts,
+		TimeLimitWarnings:             timeLimitWarnings,
+		TimeLimitCheckInterval:        cfg.GetDuration("vice.time-limits.check-interval"),
+		DefaultTimeLimitExtension:     cfg.GetDuration("vice.time-limits.default-extension"),
+		TimeLimitPolicy:               timeLimitPolicy,
+		UserTimeLimitPolicies:         userTimeLimitPolicies,
+		GroupTimeLimitPolicies:        groupTimeLimitPolicies,
+		ReconcileInterval:             cfg.GetDuration("vice.reconciler.interval"),
+		OrphanGracePeriod:             cfg.GetDuration("vice.reconciler.grace-period"),
+		CleanUpOrphans:                cfg.GetBool("vice.reconciler.cleanup"),
+		MaxPodRestarts:                cfg.GetInt32("vice.pod-failures.max-restarts"),
+		ImagePullTimeout:              cfg.GetDuration("vice.pod-failures.image-pull-timeout"),
+		ExitOnPodFailure:              cfg.GetBool("vice.pod-failures.exit-on-failure"),
+		EventDedupeWindow:             cfg.GetDuration("vice.events.dedupe-window"),
+		LeaderElectionEnabled:         cfg.GetBool("leader-election.enabled"),
+		LeaseName:                     cfg.GetString("leader-election.lease-name"),
+		LeaseDuration:                 cfg.GetDuration("leader-election.lease-duration"),
+		LeaseRenewDeadline:            cfg.GetDuration("leader-election.renew-deadline"),
+		LeaseRetryPeriod:              cfg.GetDuration("leader-election.retry-period"),
+		StatusRequestTimeout:          cfg.GetDuration("vice.job-status.timeout"),
+		OutboxPollInterval:            cfg.GetDuration("vice.job-status.outbox.poll-interval"),
+		OutboxBatchSize:               cfg.GetInt("vice.job-status.outbox.batch-size"),
+		OutboxInitialBackoff:          cfg.GetDuration("vice.job-status.outbox.initial-backoff"),
+		OutboxMaxBackoff:              cfg.GetDuration("vice.job-status.outbox.max-backoff"),
+		OutboxMaxAttempts:             cfg.GetInt("vice.job-status.outbox.max-attempts"),
+		OutboxRetention:               cfg.GetDuration("vice.job-status.outbox.retention"),
+		StatusTransport:               statusTransport,
+		AMQPURI:                       cfg.GetString("amqp.uri"),
+		AMQPExchange:                  cfg.GetString("amqp.exchange.name"),
+		StatusUpdateInterval:          cfg.GetDuration("vice.job-status.min-interval"),
+		NodePoolLabel:                 cfg.GetString("metrics.node-pool-label"),
+		LogStreamHeartbeat:            cfg.GetDuration("vice.logs.heartbeat-interval"),
+		MaxLogStreamsPerUser:          cfg.GetInt("vice.logs.max-streams-per-user"),
+		MaxLogStreamDuration:          cfg.GetDuration("vice.logs.max-stream-duration"),
+		ArchiveLogs:                   cfg.GetBool("vice.logs.archive.enabled"),
+		LogArchiveTailLines:           cfg.GetInt64("vice.logs.archive.tail-lines"),
+		LogArchiveTimeout:             cfg.GetDuration("vice.logs.archive.timeout"),
+		UsageTimeout:                  cfg.GetDuration("vice.usage.timeout"),
+		UsageReconcileInterval:        cfg.GetDuration("vice.usage.reconcile-interval"),
+		KubernetesConfig:              config,
+		GroupsURL:                     cfg.GetString("groups.base"),
+		GroupsUser:                    cfg.GetString("groups.user"),
+		GroupsTimeout:                 cfg.GetDuration("groups.timeout"),
+		GroupMembers:                  groupMembers,
+		Authenticator:                 authenticator,
 	}
 
 	app := NewExposerApp(exposerInit, *ingressClass, clientset)
+
+	// Stop the background tasks and give up the leader election Lease on
+	// shutdown so that another replica can take over without waiting for the
+	// Lease to expire.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	prometheus.MustRegister(app.internal.RunningAnalysesCollector(ctx.Done()))
 	log.Printf("listening on port %d", *listenPort)
-	app.internal.MonitorVICEEvents()
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", strconv.Itoa(*listenPort)), app.router))
+	tasks := []internal.BackgroundTask{
+		app.internal.DeliverStatusUpdates,
+		app.internal.MonitorVICEEvents,
+		app.internal.ReconcileUsage,
+	}
+	if cfg.GetBool("vice.idle.enabled") {
+		tasks = append(tasks, app.internal.MonitorIdleAnalyses)
+	}
+	if cfg.GetBool("vice.time-limits.enabled") {
+		tasks = append(tasks, app.internal.MonitorTimeLimits)
+	}
+	if cfg.GetBool("vice.pod-failures.enabled") {
+		tasks = append(tasks, app.internal.MonitorVICEPods)
+	}
+	if cfg.GetBool("vice.events.enabled") {
+		tasks = append(tasks, app.internal.ForwardVICEEvents)
+	}
+	if cfg.GetBool("vice.reconciler.enabled") {
+		tasks = append(tasks, app.internal.ReconcileOrphans)
+	}
+
+	tasksDone := make(chan struct{})
+	go func() {
+		app.internal.RunBackgroundTasks(ctx, tasks)
+		close(tasksDone)
+	}()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", strconv.Itoa(*listenPort)),
+		Handler: app.router,
+	}
+
+	// ListenAndServe returns as soon as the shutdown starts, so main waits for
+	// the in-flight requests to drain and the spans to be flushed.
+	shutdownDone := make(chan struct{})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(shutdownDone)
+		sig := <-signals
+		log.Infof("received %s, shutting down", sig)
+		cancel()
+		<-tasksDone
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Error(err)
+		}
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	if err = server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
 }
@@ -0,0 +1,141 @@
+// Package metrics contains the Prometheus metrics reported by app-exposer.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/cyverse-de/app-exposer/tracing"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "app_exposer"
+
+// The outcomes used to label the metrics.
+const (
+	SuccessOutcome = "success"
+	FailureOutcome = "failure"
+)
+
+var (
+	// Launches counts VICE analysis launch requests by outcome and error code.
+	Launches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "launches_total",
+		Help:      "The number of VICE analysis launch requests, by outcome and error code.",
+	}, []string{"outcome", "error_code"})
+
+	// LaunchReadySeconds tracks how long it takes for a VICE analysis to have a
+	// ready replica after its deployment is created.
+	LaunchReadySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "launch_ready_seconds",
+		Help:      "The time between the creation of a VICE deployment and its first ready replica.",
+		Buckets:   []float64{5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	})
+
+	// Exits counts the VICE analyses shut down by app-exposer, by reason.
+	Exits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exits_total",
+		Help:      "The number of VICE analyses shut down, by reason.",
+	}, []string{"reason"})
+
+	// FileTransferSeconds tracks how long input and output file transfers take.
+	FileTransferSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "file_transfer_seconds",
+		Help:      "The duration of VICE file transfers, by kind and outcome.",
+		Buckets:   []float64{5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 14400},
+	}, []string{"kind", "outcome"})
+
+	// FileTransferFailures counts the file transfers that failed.
+	FileTransferFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_transfer_failures_total",
+		Help:      "The number of failed VICE file transfers, by kind.",
+	}, []string{"kind"})
+
+	// OutboundRequestSeconds tracks the latency of requests to other services.
+	OutboundRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbound_request_seconds",
+		Help:      "The latency of requests to other services, by service and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "code"})
+)
+
+// errorCode returns the value of the error_code label for an error returned by
+// a handler.
+func errorCode(err error) string {
+	switch e := err.(type) {
+	case common.ErrorResponse:
+		if e.ErrorCode != "" {
+			return e.ErrorCode
+		}
+	case *echo.HTTPError:
+		return strconv.Itoa(e.Code)
+	}
+	return strconv.Itoa(http.StatusInternalServerError)
+}
+
+// RecordLaunch counts a launch request that returned the given error, which
+// may be nil.
+func RecordLaunch(err error) {
+	if err == nil {
+		Launches.WithLabelValues(SuccessOutcome, "").Inc()
+		return
+	}
+	Launches.WithLabelValues(FailureOutcome, errorCode(err)).Inc()
+}
+
+// RecordFileTransfer records the duration and outcome of a file transfer that
+// started at the given time.
+func RecordFileTransfer(kind string, start time.Time, succeeded bool) {
+	outcome := SuccessOutcome
+	if !succeeded {
+		outcome = FailureOutcome
+		FileTransferFailures.WithLabelValues(kind).Inc()
+	}
+	FileTransferSeconds.WithLabelValues(kind, outcome).Observe(time.Since(start).Seconds())
+}
+
+// transport is an http.RoundTripper that records the latency of the requests
+// sent through it.
+type transport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	OutboundRequestSeconds.WithLabelValues(t.service, code).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// NewTransport returns an http.RoundTripper that records the latency of
+// requests to the named service before passing them along to next. The
+// default transport, with tracing, is used if next is nil.
+func NewTransport(service string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = tracing.NewTransport(nil)
+	}
+	return &transport{service: service, next: next}
+}
+
+// NewClient returns an *http.Client that records the latency of requests to
+// the named service.
+func NewClient(service string) *http.Client {
+	return &http.Client{Transport: NewTransport(service, nil)}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "ERR_LIMIT_REACHED", errorCode(common.ErrorResponse{ErrorCode: "ERR_LIMIT_REACHED"}))
+	assert.Equal(t, "400", errorCode(echo.NewHTTPError(http.StatusBadRequest, "bad")))
+	assert.Equal(t, "500", errorCode(errors.New("oops")))
+}
+
+func TestRecordLaunch(t *testing.T) {
+	before := testutil.ToFloat64(Launches.WithLabelValues(FailureOutcome, "400"))
+	RecordLaunch(echo.NewHTTPError(http.StatusBadRequest, "bad"))
+	assert.Equal(t, before+1, testutil.ToFloat64(Launches.WithLabelValues(FailureOutcome, "400")))
+
+	before = testutil.ToFloat64(Launches.WithLabelValues(SuccessOutcome, ""))
+	RecordLaunch(nil)
+	assert.Equal(t, before+1, testutil.ToFloat64(Launches.WithLabelValues(SuccessOutcome, "")))
+}
+
+func TestRecordFileTransfer(t *testing.T) {
+	before := testutil.ToFloat64(FileTransferFailures.WithLabelValues("upload"))
+	RecordFileTransfer("upload", time.Now(), false)
+	RecordFileTransfer("upload", time.Now(), true)
+	assert.Equal(t, before+1, testutil.ToFloat64(FileTransferFailures.WithLabelValues("upload")))
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	resp, err := NewClient("test-service").Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(OutboundRequestSeconds), "the request latency should be recorded")
+}
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS vice_analysis_activity;
+
+COMMIT;
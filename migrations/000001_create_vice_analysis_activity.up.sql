@@ -0,0 +1,11 @@
+BEGIN;
+
+-- The last time vice-proxy reported activity for each running analysis, and
+-- when the user was warned that it's idle.
+CREATE TABLE IF NOT EXISTS vice_analysis_activity (
+    external_id text PRIMARY KEY,
+    last_activity timestamp with time zone NOT NULL DEFAULT now(),
+    idle_warning_sent timestamp with time zone
+);
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+-- The time limit warnings and exits that have been handled for each analysis.
+-- Events are recorded per planned end date so that extending the time limit
+-- allows the warnings to be sent again.
+CREATE TABLE IF NOT EXISTS vice_time_limit_events (
+    analysis_id uuid NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    planned_end_date timestamp with time zone NOT NULL,
+    event text NOT NULL,
+    recorded_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (analysis_id, planned_end_date, event)
+);
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+-- Every extension of an analysis's time limit. Extensions granted by
+-- administrators are flagged so that they don't count against the user's time
+-- limit policy.
+CREATE TABLE IF NOT EXISTS vice_time_limit_extensions (
+    id bigserial PRIMARY KEY,
+    analysis_id uuid NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    extended_by uuid NOT NULL REFERENCES users(id),
+    extension interval NOT NULL,
+    admin boolean NOT NULL DEFAULT false,
+    extended_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS vice_time_limit_extensions_analysis_id_index
+    ON vice_time_limit_extensions (analysis_id);
+
+COMMIT;
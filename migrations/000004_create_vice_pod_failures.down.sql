@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS vice_analysis_failures;
+DROP TABLE IF EXISTS vice_pod_problems;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+-- The last problem reported for each VICE pod that's having trouble starting.
+CREATE TABLE IF NOT EXISTS vice_pod_problems (
+    pod_name text PRIMARY KEY,
+    external_id text NOT NULL,
+    problem text NOT NULL,
+    reported_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS vice_pod_problems_external_id_index
+    ON vice_pod_problems (external_id);
+
+-- The analyses that have been failed because their pods couldn't start, so
+-- that they aren't marked as completed once their deployments are deleted.
+CREATE TABLE IF NOT EXISTS vice_analysis_failures (
+    external_id text PRIMARY KEY,
+    failed_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+COMMIT;
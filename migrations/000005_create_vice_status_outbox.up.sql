@@ -0,0 +1,26 @@
+BEGIN;
+
+-- Status updates waiting to be delivered to job-status-listener. Updates are
+-- delivered in order of their IDs for each analysis. A NULL next_attempt means
+-- the update has run out of attempts and is waiting to be replayed.
+CREATE TABLE IF NOT EXISTS vice_status_outbox (
+    id bigserial PRIMARY KEY,
+    external_id text NOT NULL,
+    state text NOT NULL,
+    message text NOT NULL,
+    host text NOT NULL,
+    created_date timestamp with time zone NOT NULL DEFAULT now(),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt timestamp with time zone DEFAULT now(),
+    last_error text,
+    delivered_date timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS vice_status_outbox_undelivered_index
+    ON vice_status_outbox (external_id, id)
+    WHERE delivered_date IS NULL;
+
+CREATE INDEX IF NOT EXISTS vice_status_outbox_delivered_date_index
+    ON vice_status_outbox (delivered_date);
+
+COMMIT;
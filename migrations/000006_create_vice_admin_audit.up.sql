@@ -0,0 +1,27 @@
+BEGIN;
+
+-- The mutating requests made to the admin and instant launch endpoints, along
+-- with their outcomes. Credentials are redacted from the payloads before
+-- they're stored.
+CREATE TABLE IF NOT EXISTS vice_admin_audit (
+    id bigserial PRIMARY KEY,
+    actor text NOT NULL,
+    action text NOT NULL,
+    target_type text NOT NULL,
+    target_id text NOT NULL,
+    payload text NOT NULL,
+    status_code integer NOT NULL,
+    error text,
+    created_date timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS vice_admin_audit_created_date_index
+    ON vice_admin_audit (created_date);
+
+CREATE INDEX IF NOT EXISTS vice_admin_audit_actor_index
+    ON vice_admin_audit (actor, created_date);
+
+CREATE INDEX IF NOT EXISTS vice_admin_audit_target_id_index
+    ON vice_admin_audit (target_id, created_date);
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+-- The logs of the containers in an analysis, saved before its pods were
+-- deleted. The logs column contains the merged logs returned by the merged
+-- logs endpoint.
+CREATE TABLE IF NOT EXISTS vice_log_archives (
+    id bigserial PRIMARY KEY,
+    analysis_id uuid NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    external_id text NOT NULL,
+    reason text NOT NULL,
+    logs jsonb NOT NULL,
+    created_date timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS vice_log_archives_analysis_id_index
+    ON vice_log_archives (analysis_id, created_date);
+
+COMMIT;
@@ -0,0 +1,41 @@
+BEGIN;
+
+-- The compute used by each VICE analysis. A record is opened without an end
+-- date when the analysis is launched, and finished when its deployment is
+-- deleted or by the usage reconciler. The suspended_seconds and suspended_at
+-- columns track the time an open record's analysis has spent suspended, which
+-- is left out of the hours.
+CREATE TABLE IF NOT EXISTS vice_usage_records (
+    analysis_id uuid PRIMARY KEY REFERENCES jobs(id) ON DELETE CASCADE,
+    external_id text NOT NULL,
+    user_id uuid NOT NULL,
+    username text NOT NULL,
+    app_id text NOT NULL,
+    app_name text NOT NULL,
+    start_date timestamp with time zone NOT NULL,
+    end_date timestamp with time zone,
+    cpu_cores double precision NOT NULL DEFAULT 0,
+    memory_bytes bigint NOT NULL DEFAULT 0,
+    gpus bigint NOT NULL DEFAULT 0,
+    cpu_core_hours double precision NOT NULL DEFAULT 0,
+    memory_gib_hours double precision NOT NULL DEFAULT 0,
+    gpu_hours double precision NOT NULL DEFAULT 0,
+    suspended_hours double precision NOT NULL DEFAULT 0,
+    suspended_seconds double precision NOT NULL DEFAULT 0,
+    suspended_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS vice_usage_records_end_date_index
+    ON vice_usage_records (end_date);
+
+CREATE INDEX IF NOT EXISTS vice_usage_records_username_index
+    ON vice_usage_records (username, end_date);
+
+CREATE INDEX IF NOT EXISTS vice_usage_records_app_id_index
+    ON vice_usage_records (app_id, end_date);
+
+CREATE INDEX IF NOT EXISTS vice_usage_records_open_index
+    ON vice_usage_records (start_date)
+    WHERE end_date IS NULL;
+
+COMMIT;
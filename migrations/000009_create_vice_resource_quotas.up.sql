@@ -0,0 +1,21 @@
+BEGIN;
+
+-- Caps on the total resources requested by a user's running analyses. The
+-- launcher column matches the one in job_limits, and the row with a NULL
+-- launcher is the default quota. A NULL in any of the resource columns leaves
+-- that resource uncapped.
+CREATE TABLE IF NOT EXISTS vice_resource_quotas (
+    id bigserial PRIMARY KEY,
+    launcher text UNIQUE,
+    cpu_cores double precision CHECK (cpu_cores >= 0),
+    memory_bytes bigint CHECK (memory_bytes >= 0),
+    gpus bigint CHECK (gpus >= 0)
+);
+
+-- The unique constraint above doesn't apply to NULLs, so this makes sure
+-- there's only one default quota.
+CREATE UNIQUE INDEX IF NOT EXISTS vice_resource_quotas_default_index
+    ON vice_resource_quotas ((launcher IS NULL))
+    WHERE launcher IS NULL;
+
+COMMIT;
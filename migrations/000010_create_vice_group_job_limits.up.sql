@@ -0,0 +1,11 @@
+BEGIN;
+
+-- Concurrent job limits for the members of a group. A user gets the most
+-- generous of their own limit, or the default limit, and the limits of the
+-- groups they belong to.
+CREATE TABLE IF NOT EXISTS vice_group_job_limits (
+    group_name text PRIMARY KEY,
+    concurrent_jobs integer NOT NULL CHECK (concurrent_jobs >= 0)
+);
+
+COMMIT;
@@ -3,11 +3,15 @@ package permissions
 import (
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"path/filepath"
+
+	"github.com/cyverse-de/app-exposer/metrics"
 )
 
+// client records the latency of requests to the permissions service.
+var client = metrics.NewClient("permissions")
+
 // Permissions performs operations related to checking permissions.
 type Permissions struct {
 	BaseURL string
@@ -62,7 +66,7 @@ func (p *Permissions) GetPermissions(lookup *Lookup) (*PermissionList, error) {
 	}
 
 	requrl.Path = filepath.Join(requrl.Path, "permissions/subjects", lookup.SubjectType, lookup.Subject, lookup.ResourceType, lookup.Resource)
-	resp, err := http.Get(requrl.String())
+	resp, err := client.Get(requrl.String())
 	if err != nil {
 		return nil, err
 	}
@@ -106,3 +110,44 @@ func (p *Permissions) IsAllowed(user, resource string) (bool, error) {
 
 	return false, nil
 }
+
+// levels contains the permission levels in the permissions service, from least
+// to most access.
+var levels = []string{"read", "write", "admin", "own"}
+
+// levelRank returns the position of the level in levels, or -1 if it isn't a
+// known level.
+func levelRank(level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasLevel will return true if the user has been granted at least the given
+// permission level on the analysis. As with IsAllowed, access should be denied
+// if an error is returned.
+func (p *Permissions) HasLevel(user, resource, minimum string) (bool, error) {
+	lookup := &Lookup{
+		Subject:      user,
+		SubjectType:  "user",
+		Resource:     resource,
+		ResourceType: "analysis",
+	}
+
+	l, err := p.GetPermissions(lookup)
+	if err != nil {
+		return false, err
+	}
+
+	want := levelRank(minimum)
+	for _, perm := range l.Permissions {
+		if rank := levelRank(perm.Level); rank >= 0 && rank >= want {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
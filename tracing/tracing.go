@@ -0,0 +1,226 @@
+// Package tracing sets up OpenTelemetry tracing for app-exposer and contains
+// helpers for adding spans to handlers, SQL queries, and outbound requests.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/cyverse-de/app-exposer/common"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName = "app-exposer"
+	tracerName  = "github.com/cyverse-de/app-exposer"
+
+	// TraceIDHeader is the response header containing the ID of the trace for
+	// the request.
+	TraceIDHeader = "X-Trace-Id"
+)
+
+var log = common.Log
+
+// Config contains the settings for exporting traces.
+type Config struct {
+	Enabled bool
+
+	// Endpoint is the host and port of the OTLP/HTTP collector. The
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used if it's blank.
+	Endpoint string
+
+	// Insecure disables TLS for connections to the collector.
+	Insecure bool
+}
+
+// Init sets up the global tracer provider and propagators. Traces are only
+// exported if tracing is enabled, but trace context is propagated either way.
+// The returned function flushes any remaining spans and should be called on
+// shutdown.
+func Init(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start begins a new span as a child of any span in the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, which may be nil, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context carrying the span from ctx but none of its
+// cancellation, for work that outlives the request that started it.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// TraceID returns the ID of the trace in the context, or an empty string if
+// there isn't one.
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// Logger returns the logger with the ID of the trace in the context added to
+// its fields.
+func Logger(ctx context.Context) *logrus.Entry {
+	if id := TraceID(ctx); id != "" {
+		return log.WithField("trace_id", id)
+	}
+	return log
+}
+
+// Middleware returns echo middleware that starts a span for each request,
+// continuing any trace propagated by the caller. The trace ID is returned to
+// the caller in the X-Trace-Id header.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			route := c.Path()
+			if route == "" {
+				route = req.URL.Path
+			}
+
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := otel.Tracer(tracerName).Start(
+				ctx,
+				req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(serviceName, route, req)...),
+			)
+			defer span.End()
+
+			c.SetRequest(req.WithContext(ctx))
+			if id := TraceID(ctx); id != "" {
+				c.Response().Header().Set(TraceIDHeader, id)
+			}
+
+			err := next(c)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+
+			return err
+		}
+	}
+}
+
+// NewTransport returns an http.RoundTripper that starts a span for each
+// request and propagates the trace context to the server. The default
+// transport is used if next is nil.
+func NewTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(next)
+}
+
+// kubernetesFilter skips the requests made by watches, which stay open for as
+// long as the informer runs, and the Lease renewals made during leader
+// election, which happen every few seconds.
+func kubernetesFilter(req *http.Request) bool {
+	if req.URL.Query().Get("watch") == "true" {
+		return false
+	}
+	return !strings.HasPrefix(req.URL.Path, "/apis/coordination.k8s.io/")
+}
+
+// NewKubernetesTransport returns an http.RoundTripper for use in the
+// Kubernetes client config that starts a span for each API call.
+func NewKubernetesTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next, otelhttp.WithFilter(kubernetesFilter))
+}
+
+// registerDriver wraps the named database driver with one that adds a span
+// for each query. Queries made outside of a request, such as the ones made by
+// the monitoring goroutines, start their own traces instead of going
+// unrecorded.
+func registerDriver(driverName string) (string, error) {
+	return otelsql.Register(
+		driverName,
+		semconv.DBSystemPostgreSQL.Value.AsString(),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{AllowRoot: true}),
+	)
+}
+
+// OpenDB connects to the Postgres database at the URI using a driver that
+// adds a span for each query.
+func OpenDB(uri string) (*sqlx.DB, error) {
+	driverName, err := registerDriver("postgres")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driverName, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the postgres driver name so that sqlx uses the right bind vars.
+	dbx := sqlx.NewDb(db, "postgres")
+	if err = dbx.Ping(); err != nil {
+		dbx.Close()
+		return nil, err
+	}
+
+	return dbx, nil
+}
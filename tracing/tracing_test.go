@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setupRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	recorder := setupRecorder()
+
+	var traceID string
+	e := echo.New()
+	e.Use(Middleware())
+	e.GET("/vice/:id/exit", func(c echo.Context) error {
+		traceID = TraceID(c.Request().Context())
+		return errors.New("oops")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/vice/foo/exit", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.NotEmpty(traceID, "the handler's context should contain the trace")
+	assert.Equal(traceID, rec.Header().Get(TraceIDHeader))
+
+	spans := recorder.Ended()
+	if assert.Len(spans, 1) {
+		assert.Equal("GET /vice/:id/exit", spans[0].Name())
+		assert.Equal(codes.Error, spans[0].Status().Code)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	setupRecorder()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := Start(ctx, "request")
+	defer span.End()
+	cancel()
+
+	detached := Detach(ctx)
+	assert.NoError(t, detached.Err(), "the detached context should not be cancelled")
+	assert.Equal(t, TraceID(ctx), TraceID(detached))
+}
+
+func TestKubernetesFilter(t *testing.T) {
+	tests := []struct {
+		url    string
+		traced bool
+	}{
+		{"https://k8s/api/v1/namespaces/vice-apps/pods", true},
+		{"https://k8s/api/v1/namespaces/vice-apps/pods?watch=true", false},
+		{"https://k8s/apis/coordination.k8s.io/v1/namespaces/de/leases/app-exposer", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		assert.Equal(t, test.traced, kubernetesFilter(req), test.url)
+	}
+}
+
+func TestRegisterDriver(t *testing.T) {
+	assert := assert.New(t)
+	recorder := setupRecorder()
+
+	driverName, err := registerDriver("sqlmock")
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, mock, err := sqlmock.NewWithDSN("TestRegisterDriver")
+	if !assert.NoError(err) {
+		return
+	}
+
+	db, err := sql.Open(driverName, "TestRegisterDriver")
+	if !assert.NoError(err) {
+		return
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM vice_pod_problems").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM vice_pod_problems").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// A query made outside of a request starts its own trace.
+	_, err = db.ExecContext(context.Background(), "DELETE FROM vice_pod_problems")
+	assert.NoError(err)
+
+	// A query made while handling a request is part of the request's trace.
+	ctx, span := Start(context.Background(), "request")
+	_, err = db.ExecContext(ctx, "DELETE FROM vice_pod_problems")
+	assert.NoError(err)
+	span.End()
+
+	assert.NoError(mock.ExpectationsWereMet())
+
+	var dbSpans []sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "sql.conn.exec" {
+			dbSpans = append(dbSpans, s)
+		}
+	}
+	if assert.Len(dbSpans, 2, "each query should have a span") {
+		assert.False(dbSpans[0].Parent().IsValid(), "the query outside of a request should be a root span")
+		assert.Equal(span.SpanContext().SpanID(), dbSpans[1].Parent().SpanID())
+	}
+}